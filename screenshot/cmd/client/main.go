@@ -1,8 +1,46 @@
 package main
 
-import "screenshot/internal/app"
+import (
+	"flag"
+	"fmt"
+	"os"
 
-// 修改远程部署时的服务端地址即可
+	"screenshot/internal/app"
+)
+
+// 修改远程部署时的服务端地址即可，也可通过 -addr 指定
 var address = "127.0.0.1:12345"
 
-func main() { app.Run(address) }
+func main() {
+	addr := flag.String("addr", address, "服务端地址")
+	useTLS := flag.Bool("tls", false, "以 TLS 连接服务端")
+	var run app.Options
+	flag.StringVar(&run.Token, "token", os.Getenv("SCREENSHOT_TOKEN"), "注册令牌（服务端启用令牌认证时），也可通过环境变量 SCREENSHOT_TOKEN 提供")
+	flag.StringVar(&run.Name, "name", "", "客户端名称，共享令牌时用于区分客户端，默认取主机名")
+	var opts app.TLSOptions
+	flag.StringVar(&opts.CAFile, "ca", "", "校验服务端证书的 CA（如 gen-certs 生成的 ca.pem），为空时使用系统根证书")
+	flag.StringVar(&opts.CertFile, "cert", "", "客户端证书（服务端要求双向 TLS 时）")
+	flag.StringVar(&opts.KeyFile, "key", "", "客户端私钥")
+	flag.StringVar(&opts.ServerName, "server-name", "", "校验的服务端名称，默认取地址中的主机名")
+	logLevel := flag.String("log-level", "info", "日志级别：debug / info / warn / error")
+	logFormat := flag.String("log-format", "text", "日志格式：text / json")
+	flag.Parse()
+
+	if err := app.SetupLogging(*logLevel, *logFormat); err != nil {
+		fmt.Fprintln(os.Stderr, "log-level:", err)
+		os.Exit(1)
+	}
+
+	if run.Name == "" {
+		run.Name, _ = os.Hostname()
+	}
+	if *useTLS {
+		tc, err := opts.Config()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "tls:", err)
+			os.Exit(1)
+		}
+		run.TLS = tc
+	}
+	app.Run(*addr, run)
+}
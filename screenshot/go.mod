@@ -2,20 +2,11 @@ module screenshot
 
 go 1.22
 
-require (
-	github.com/kbinani/screenshot v0.0.0-20240809223827-e520a411f991
-	github.com/qiniu/go-sdk/v7 v7.21.1
-)
+require github.com/kbinani/screenshot v0.0.0-20240809223827-e520a411f991
 
 require (
-	github.com/BurntSushi/toml v1.3.2 // indirect
-	github.com/alex-ant/gomath v0.0.0-20160516115720-89013a210a82 // indirect
 	github.com/gen2brain/shm v0.0.0-20230802011745-f2460f5984f7 // indirect
-	github.com/gofrs/flock v0.8.1 // indirect
-	github.com/google/uuid v1.6.0 // indirect
 	github.com/jezek/xgb v1.1.0 // indirect
 	github.com/lxn/win v0.0.0-20210218163916-a377121e959e // indirect
-	github.com/matishsiao/goInfo v0.0.0-20210923090445-da2e3fa8d45f // indirect
-	golang.org/x/sync v0.0.0-20220722155255-886fb9371eb4 // indirect
 	golang.org/x/sys v0.12.0 // indirect
 )
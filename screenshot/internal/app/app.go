@@ -1,49 +1,83 @@
 package app
 
 import (
+	"crypto/tls"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"io"
+	"log/slog"
 	"net"
+	"os"
+	"strings"
+
 	"screenshot/internal/capture"
 	"screenshot/internal/protocol"
 )
 
+// Options 连接选项
+type Options struct {
+	// 非 nil 时以 TLS 连接
+	TLS *tls.Config
+	// 服务端启用令牌认证时的注册令牌
+	Token string
+	// 客户端名称，共享令牌时用于区分客户端
+	Name string
+}
+
 // Run 启动客户端：连接服务器，循环接收命令并发送截图结果
-func Run(address string) {
-	conn, err := net.Dial("tcp", address)
+func Run(address string, opts Options) {
+	var conn net.Conn
+	var err error
+	if opts.TLS != nil {
+		conn, err = tls.Dial("tcp", address, opts.TLS)
+	} else {
+		conn, err = net.Dial("tcp", address)
+	}
 	if err != nil {
-		fmt.Println("Error connecting:", err.Error())
+		slog.Error("connect failed", "addr", address, "err", err)
 		return
 	}
 	defer conn.Close()
-	fmt.Println("已连接到服务器")
+	if opts.Token != "" {
+		if err := hello(conn, opts); err != nil {
+			slog.Error("authentication failed", "err", err)
+			return
+		}
+	}
+	slog.Info("已连接到服务器", "addr", address, "tls", opts.TLS != nil)
 
 	for {
 		// 读取命令（长度前缀帧）
-		commandBytes, err := protocol.ReadWithLengthPrefix(conn)
+		commandBytes, err := protocol.ReadWithLimit(conn, protocol.MaxHelloSize)
 		if err != nil {
 			if err == io.EOF {
-				fmt.Println("Connection closed by server")
+				slog.Info("connection closed by server")
 			} else {
-				fmt.Println("Error reading command:", err.Error())
+				slog.Error("read command", "err", err)
 			}
 			return
 		}
-		command := string(commandBytes)
-		fmt.Printf("Received command: %s\n", command)
+		command, requestID := protocol.ParseCommand(string(commandBytes))
+		log := slog.With("request_id", requestID)
+		log.Debug("received command", "command", command)
+		if command == protocol.CmdShutdown {
+			slog.Info("server is shutting down")
+			return
+		}
 
-		resp := protocol.Response{Code: 200}
+		resp := protocol.Response{Code: 200, RequestID: requestID}
 		switch command {
-		case "1": // 截图
+		case protocol.CmdCapture: // 截图
 			pngBytes, err := capture.PrimaryPNG()
 			if err != nil {
+				log.Warn("capture failed", "err", err)
 				resp.Code = 500
 				resp.Error = err.Error()
 			} else {
 				resp.Data = pngBytes
 			}
 		default:
+			log.Warn("unknown command", "command", command)
 			resp.Code = 400
 			resp.Error = "Unknown command"
 		}
@@ -51,13 +85,47 @@ func Run(address string) {
 		// 编码为 JSON 发送（仍然套长度前缀帧）
 		b, err := json.Marshal(resp)
 		if err != nil {
-			fmt.Println("Error marshalling JSON:", err)
+			log.Error("marshal response", "err", err)
 			continue
 		}
 		if err := protocol.SendWithLengthPrefix(conn, b); err != nil {
-			fmt.Println("Error sending data:", err)
+			log.Error("send response", "err", err)
 			return
 		}
-		fmt.Println("Sent response, size:", len(b))
+		log.Debug("sent response", "bytes", len(b))
+	}
+}
+
+// SetupLogging 按级别（debug/info/warn/error）与格式（text/json）设置默认 logger，输出到标准错误
+func SetupLogging(level, format string) error {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return err
+	}
+	opts := &slog.HandlerOptions{Level: l}
+	var h slog.Handler = slog.NewTextHandler(os.Stderr, opts)
+	if strings.EqualFold(format, "json") {
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+// hello 发送令牌并等待服务端确认
+func hello(conn net.Conn, opts Options) error {
+	b, err := json.Marshal(protocol.Hello{Token: opts.Token, Name: opts.Name})
+	if err != nil {
+		return err
+	}
+	if err := protocol.SendWithLengthPrefix(conn, b); err != nil {
+		return err
+	}
+	reply, err := protocol.ReadWithLimit(conn, protocol.MaxHelloSize)
+	if err != nil {
+		return err
+	}
+	if string(reply) != protocol.HelloOK {
+		return errors.New(string(reply))
 	}
+	return nil
 }
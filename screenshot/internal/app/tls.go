@@ -0,0 +1,41 @@
+package app
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// TLSOptions 连接服务器的 TLS 设置；CAFile 为空时使用系统根证书
+type TLSOptions struct {
+	CAFile   string
+	CertFile string
+	KeyFile  string
+	// 校验的服务端名称；为空时取连接地址中的主机名
+	ServerName string
+}
+
+// Config 构造 tls.Config；服务器要求客户端证书时需同时提供 CertFile 与 KeyFile
+func (o TLSOptions) Config() (*tls.Config, error) {
+	tc := &tls.Config{ServerName: o.ServerName, MinVersion: tls.VersionTLS12}
+	if o.CAFile != "" {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", o.CAFile)
+		}
+		tc.RootCAs = pool
+	}
+	if o.CertFile != "" || o.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+	return tc, nil
+}
@@ -1,7 +1,62 @@
 package main
 
-import "screensot-server/internal/app"
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"screensot-server/internal/app"
+)
+
+func usage() {
+	fmt.Fprint(os.Stderr, `usage: server [command]
+
+Without a command, starts the server using config.json.
+
+Commands:
+  gen-certs   generate a CA and TLS certificates for the capture channel
+  gen-token   generate a client token and print its config entry
+  add-user    add or update an HTTP login user
+  config      check the configuration (config check [path])
+  help        show this help
+`)
+}
 
 func main() {
-	app.New().Run()
+	if len(os.Args) > 1 {
+		var cmd func([]string) error
+		switch os.Args[1] {
+		case "gen-certs":
+			cmd = app.GenCerts
+		case "gen-token":
+			cmd = app.GenToken
+		case "add-user":
+			cmd = app.AddUser
+		case "config":
+			cmd = app.ConfigCommand
+		case "help":
+			usage()
+			return
+		default:
+			// 以 - 开头的参数留给服务本身，其余视为拼错的子命令，不能静默启动服务
+			if !strings.HasPrefix(os.Args[1], "-") {
+				fmt.Fprintf(os.Stderr, "unknown command %q\n\n", os.Args[1])
+				usage()
+				os.Exit(2)
+			}
+		}
+		if cmd != nil {
+			if err := cmd(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[1], err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+	a, err := app.New()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	a.Run()
 }
@@ -1,3 +1,5 @@
 module screensot-server
 
 go 1.22
+
+require golang.org/x/crypto v0.31.0
@@ -1,21 +1,104 @@
 package app
 
 import (
-	"fmt"
+	"context"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
 // App 持有服务器运行期状态（TCP 客户端集合、响应收集通道等）
 type App struct {
 	*state
-	cfg Config
+	// 启动时加载的配置；运行期经 conf() 读取，热加载后为新的快照
+	cfg  Config
+	live atomic.Pointer[Config]
+	// 串行化热加载
+	reloadMu sync.Mutex
+	// 识别结果缓存；未启用时为 nil
+	cache answerCache
+	// token 用量与费用账本
+	usage *usageLedger
+	// 所有请求共享的上游调用调度器
+	sched *scheduler
+	// 截图与识别结果存储；关闭存储时为 nil
+	captures *captureStore
+	// 本地题库
+	bank *questionBank
+	// 截图历史全文索引；关闭存储时为 nil
+	index *searchIndex
+	// 截屏通道令牌认证
+	tokens *tokenAuth
+	// HTTP 登录认证；未启用时为 nil
+	auth *httpAuth
+	// 截屏、识别与管理操作的审计日志
+	audit *auditLog
+	// /metrics 暴露的运行指标
+	metrics *metrics
 }
 
-// New 创建应用实例
-func New() *App { return &App{state: &state{}, cfg: loadConfig()} }
+// New 加载配置并创建应用实例；配置文件无法解析或校验失败时返回错误
+func New() (*App, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	a := &App{
+		state:    &state{},
+		cfg:      cfg,
+		cache:    newAnswerCache(cfg.Cache),
+		usage:    openUsageLedger(filepath.Join(cfg.DataDir, "usage.jsonl")),
+		sched:    newScheduler(cfg.Scheduler, cfg.Providers),
+		captures: newCaptureStore(cfg.Storage),
+		bank:     openQuestionBank(filepath.Join(cfg.DataDir, "bank.json")),
+		tokens:   newTokenAuth(cfg.TCP.Auth, filepath.Join(cfg.DataDir, "revoked_tokens.json")),
+		auth:     newHTTPAuth(cfg.HTTPAuth),
+		audit:    openAuditLog(filepath.Join(cfg.DataDir, "audit.jsonl")),
+		metrics:  newMetrics(),
+	}
+	if a.captures != nil {
+		// 截图含客户端桌面上的全部内容，开启保存时明确提示保存位置
+		slog.Warn("capture storage enabled: every screenshot and model answer is saved", "backend", cfg.Storage.Backend, "dir", cfg.Storage.Dir, "bucket", cfg.Storage.Bucket)
+		a.index = buildSearchIndex(a.captures)
+	} else {
+		slog.Info("capture storage disabled; set storage.backend to save screenshots for search, chat and retention")
+	}
+	return a, nil
+}
 
-// Run 并行启动 TCP 与 HTTP 服务
+// Run 并行启动 TCP 与 HTTP 服务，收到 SIGINT/SIGTERM 后优雅关闭
 func (a *App) Run() {
-	go a.startTCPServer() // 监听 :12345 并接收客户端
-	fmt.Println("HTTP Server listening on :8848")
-	a.startHTTPServer() // 阻塞在 HTTP 服务
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	a.startedAt = time.Now()
+	a.clients = make(map[net.Conn]*tcpClient)
+	a.captureWaiters = make(map[string]chan screenshot)
+	tcpLn := a.startTCPServer() // 监听截屏通道并接收客户端；失败时仅提供 HTTP
+	a.startGC()                 // 按保留策略后台清理截图
+	a.watchConfig(ctx)          // SIGHUP 或配置文件变化时热加载
+
+	srv := a.newHTTPServer()
+	ln, err := listen(a.conf().Server.httpAddr())
+	if err != nil {
+		slog.Error("failed to start http server", "addr", a.conf().Server.httpAddr(), "err", err)
+		return
+	}
+	a.httpUp.Store(true)
+	slog.Info("http server listening", "addr", a.conf().Server.httpAddr())
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(ln) }()
+	select {
+	case <-ctx.Done():
+	case err := <-errc:
+		slog.Error("http server stopped", "err", err)
+	}
+	stop()
+	a.shutdown(srv, tcpLn)
 }
@@ -0,0 +1,442 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 审计结果
+const (
+	auditOK      = "ok"
+	auditPartial = "partial"
+	auditError   = "error"
+	auditRefused = "refused"
+)
+
+// auditEntry 审计日志中的一条记录。每条记录的 hash 覆盖记录内容与上一条的 hash，
+// 修改、删除或插入任何一行都会使其后的校验失败
+type auditEntry struct {
+	Seq  int       `json:"seq"`
+	Time time.Time `json:"time"`
+	// 发起者：登录用户名、token:<令牌名>；未启用认证时为 anonymous
+	Actor string `json:"actor"`
+	IP    string `json:"ip"`
+	// capture / analyze / chat / login / login_failed / clients.revoke / captures.gc / users.set ...
+	Action string `json:"action"`
+	// 截屏命令下发到的客户端
+	Clients []string `json:"clients,omitempty"`
+	Models  []string `json:"models,omitempty"`
+	// ok / partial / error / refused
+	Outcome   string   `json:"outcome"`
+	Detail    string   `json:"detail,omitempty"`
+	RequestID string   `json:"request_id,omitempty"`
+	Captures  []string `json:"captures,omitempty"`
+	Prev      string   `json:"prev"`
+	Hash      string   `json:"hash"`
+}
+
+// computeHash 对 hash 置空后的 JSON 取 SHA-256
+func (e auditEntry) computeHash() string {
+	e.Hash = ""
+	b, _ := json.Marshal(e)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// auditActor 请求的发起者，随 analyzeOptions 传到后台识别任务
+type auditActor struct {
+	Name string
+	IP   string
+}
+
+func actorFrom(r *http.Request) auditActor {
+	act := auditActor{Name: "anonymous", IP: requestIP(r)}
+	if p := principalFrom(r.Context()); p != nil {
+		act.Name = p.Name
+	}
+	return act
+}
+
+// auditLog 追加写入 data_dir/audit.jsonl 的审计日志。内存中只保留链尾一条用于接续哈希链，
+// 查询时从文件流式读取；path 为空时不落盘，只在内存保留最近 maxAuditTail 条
+type auditLog struct {
+	mu   sync.Mutex
+	path string
+	// 最后一条成功写入的记录；Seq 为 0 表示日志为空
+	last auditEntry
+	tail []auditEntry
+}
+
+// maxAuditTail 不落盘时内存中保留的记录数
+const maxAuditTail = 1000
+
+// openAuditLog 读取已有日志的最后一条并校验哈希链；校验失败只告警，新记录仍接在最后一条之后
+func openAuditLog(path string) *auditLog {
+	l := &auditLog{path: path}
+	if path == "" {
+		return l
+	}
+	var chain auditChain
+	err := scanAuditFile(path, func(e auditEntry) error {
+		l.last = e
+		if chain.err == nil {
+			chain.err = chain.next(e)
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		slog.Warn("open audit log", "err", err)
+	}
+	if chain.err != nil {
+		slog.Warn("audit log failed verification", "path", path, "err", chain.err)
+	}
+	return l
+}
+
+// scanAuditFile 逐行读取日志并回调；无法解析的行作为错误返回（之前的记录已回调）
+func scanAuditFile(path string, fn func(auditEntry) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		var e auditEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+// auditChain 逐条校验序号、prev 与 hash
+type auditChain struct {
+	n    int
+	prev string
+	err  error
+}
+
+func (c *auditChain) next(e auditEntry) error {
+	c.n++
+	switch {
+	case e.Seq != c.n:
+		return fmt.Errorf("entry %d: expected seq %d", e.Seq, c.n)
+	case e.Prev != c.prev:
+		return fmt.Errorf("entry %d: prev hash does not match entry %d", e.Seq, c.n-1)
+	case e.computeHash() != e.Hash:
+		return fmt.Errorf("entry %d: content does not match its hash", e.Seq)
+	}
+	c.prev = e.Hash
+	return nil
+}
+
+// verify 重新读取磁盘上的日志并校验，返回记录数
+func (l *auditLog) verify() (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.path == "" {
+		return len(l.tail), nil
+	}
+	var chain auditChain
+	err := scanAuditFile(l.path, chain.next)
+	if errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+	return chain.n, err
+}
+
+// append 写入一条记录；写入成功后才推进链尾，写入失败的记录不占用序号
+func (l *auditLog) append(e auditEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e.Seq = l.last.Seq + 1
+	e.Prev = l.last.Hash
+	e.Hash = e.computeHash()
+	if l.path == "" {
+		l.last = e
+		if l.tail = append(l.tail, e); len(l.tail) > maxAuditTail {
+			l.tail = l.tail[len(l.tail)-maxAuditTail:]
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	b, _ := json.Marshal(e)
+	_, err = f.Write(append(b, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	l.last = e
+	return nil
+}
+
+// AuditQuery 审计查询条件；各过滤项为空表示不限
+type AuditQuery struct {
+	Actor   string `json:"actor"`
+	Action  string `json:"action"`
+	Client  string `json:"client"`
+	Outcome string `json:"outcome"`
+	// 本地时区 YYYY-MM-DD，含首尾
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Limit int    `json:"limit"`
+}
+
+func auditQueryFrom(r *http.Request) AuditQuery {
+	q := r.URL.Query()
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	return AuditQuery{
+		Actor:   strings.TrimSpace(q.Get("actor")),
+		Action:  strings.TrimSpace(q.Get("action")),
+		Client:  strings.TrimSpace(q.Get("client")),
+		Outcome: strings.TrimSpace(q.Get("outcome")),
+		From:    q.Get("from"),
+		To:      q.Get("to"),
+		Limit:   limit,
+	}
+}
+
+// matches 发起者与动作为精确匹配（动作也可按前缀，如 users 匹配 users.set），客户端为包含
+func (q AuditQuery) matches(e auditEntry) bool {
+	day := e.Time.Local().Format("2006-01-02")
+	if (q.From != "" && day < q.From) || (q.To != "" && day > q.To) {
+		return false
+	}
+	if q.Actor != "" && e.Actor != q.Actor {
+		return false
+	}
+	if q.Action != "" && e.Action != q.Action && !strings.HasPrefix(e.Action, q.Action+".") {
+		return false
+	}
+	if q.Outcome != "" && e.Outcome != q.Outcome {
+		return false
+	}
+	if q.Client != "" {
+		found := false
+		for _, c := range e.Clients {
+			found = found || strings.Contains(c, q.Client)
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// query 按条件返回记录，最新的在前；limit <= 0 表示不限。从文件流式读取，有 limit 时只保留最近的 limit 条匹配
+func (l *auditLog) query(q AuditQuery, limit int) []auditEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var out []auditEntry
+	keep := func(e auditEntry) error {
+		if !q.matches(e) {
+			return nil
+		}
+		if out = append(out, e); limit > 0 && len(out) > 2*limit {
+			out = append(out[:0], out[len(out)-limit:]...)
+		}
+		return nil
+	}
+	if l.path == "" {
+		for _, e := range l.tail {
+			keep(e)
+		}
+	} else if err := scanAuditFile(l.path, keep); err != nil && !os.IsNotExist(err) {
+		slog.Warn("read audit log", "err", err)
+	}
+	if limit > 0 && len(out) > limit {
+		out = out[len(out)-limit:]
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out
+}
+
+// recordAudit 记录一次操作；未启用审计日志时忽略
+func (a *App) recordAudit(act auditActor, e auditEntry) {
+	if a.audit == nil {
+		return
+	}
+	e.Time = time.Now()
+	e.Actor, e.IP = act.Name, act.IP
+	if err := a.audit.append(e); err != nil {
+		slog.Warn("write audit log", "action", e.Action, "err", err)
+	}
+}
+
+// auditRequest 记录由 HTTP 请求直接触发的操作（登录、追问、管理操作）
+func (a *App) auditRequest(r *http.Request, action, outcome, detail string) {
+	a.recordAudit(actorFrom(r), auditEntry{Action: action, Outcome: outcome, Detail: detail})
+}
+
+// auditCapture 记录一次截屏或识别。err 非空为失败；识别时全部模型失败为 error，
+// 部分客户端未响应或部分模型失败为 partial
+func (a *App) auditCapture(opts analyzeOptions, analyze bool, items []ImageEntry, err error) {
+	e := auditEntry{Action: "capture", Clients: opts.targets, RequestID: opts.requestID, Outcome: auditOK}
+	if analyze {
+		e.Action = "analyze"
+		e.Models = a.modelsFor(opts)
+	}
+	if err != nil {
+		e.Outcome, e.Detail = auditError, err.Error()
+		a.recordAudit(opts.actor, e)
+		return
+	}
+	answered, failed := 0, 0
+	for _, it := range items {
+		if it.ID != "" {
+			e.Captures = append(e.Captures, it.ID)
+		}
+		for _, ans := range it.ModelAnswers {
+			if ans.Error != "" {
+				failed++
+			} else {
+				answered++
+			}
+		}
+	}
+	e.Detail = fmt.Sprintf("%d/%d 个客户端返回截图", len(items), len(opts.targets))
+	if analyze {
+		e.Detail += fmt.Sprintf("，%d 个模型作答成功，%d 个失败", answered, failed)
+	}
+	switch {
+	case len(items) == 0 || (analyze && answered == 0):
+		e.Outcome = auditError
+	case len(items) < len(opts.targets) || failed > 0:
+		e.Outcome = auditPartial
+	}
+	a.recordAudit(opts.actor, e)
+}
+
+// AuditPageData 审计页模板数据
+type AuditPageData struct {
+	Query   AuditQuery
+	Entries []auditEntry
+	// 日志记录总数与哈希链校验结果
+	Total       int
+	VerifyError string
+}
+
+// ExportURL 按当前过滤条件（不含条数限制）导出的链接
+func (d AuditPageData) ExportURL(format string) string {
+	v := url.Values{"format": {format}}
+	for k, s := range map[string]string{"actor": d.Query.Actor, "action": d.Query.Action, "client": d.Query.Client, "outcome": d.Query.Outcome, "from": d.Query.From, "to": d.Query.To} {
+		if s != "" {
+			v.Set(k, s)
+		}
+	}
+	return "/api/v1/audit/export?" + v.Encode()
+}
+
+// handleAudit 渲染审计日志页（默认显示最新 200 条）
+func (a *App) handleAudit(w http.ResponseWriter, r *http.Request) {
+	if a.audit == nil {
+		http.Error(w, "audit log disabled", http.StatusNotFound)
+		return
+	}
+	tmpl, err := template.New("audit").Funcs(templateFuncs).Parse(string(auditTemplate))
+	if err != nil {
+		http.Error(w, "Internal Server Error: unable to parse template", http.StatusInternalServerError)
+		return
+	}
+	q := auditQueryFrom(r)
+	if q.Limit <= 0 {
+		q.Limit = 200
+	}
+	data := AuditPageData{Query: q, Entries: a.audit.query(q, q.Limit)}
+	n, err := a.audit.verify()
+	data.Total = n
+	if err != nil {
+		data.VerifyError = err.Error()
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := tmpl.Execute(w, data); err != nil {
+		http.Error(w, "Internal Server Error: unable to execute template", http.StatusInternalServerError)
+	}
+}
+
+// handleAPIAudit GET ?actor=&action=&client=&outcome=&from=&to=&limit= 返回 JSON 记录与哈希链校验结果
+func (a *App) handleAPIAudit(w http.ResponseWriter, r *http.Request) {
+	if a.audit == nil {
+		writeJSONError(w, http.StatusNotFound, "audit log disabled")
+		return
+	}
+	q := auditQueryFrom(r)
+	n, err := a.audit.verify()
+	resp := map[string]interface{}{"query": q, "entries": a.audit.query(q, q.Limit), "total": n, "verified": err == nil}
+	if err != nil {
+		resp["verify_error"] = err.Error()
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleAPIAuditExport GET ?format=jsonl|csv 按与 /audit 相同的条件导出（按时间正序）。
+// 不带过滤条件的 jsonl 导出即完整日志，可离线重新校验哈希链
+func (a *App) handleAPIAuditExport(w http.ResponseWriter, r *http.Request) {
+	if a.audit == nil {
+		writeJSONError(w, http.StatusNotFound, "audit log disabled")
+		return
+	}
+	q := auditQueryFrom(r)
+	entries := a.audit.query(q, q.Limit)
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	name := "audit-" + time.Now().Format("20060102-150405")
+	var buf bytes.Buffer
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "jsonl":
+		w.Header().Set("Content-Type", "application/x-ndjson; charset=utf-8")
+		name += ".jsonl"
+		for _, e := range entries {
+			b, _ := json.Marshal(e)
+			buf.Write(append(b, '\n'))
+		}
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		name += ".csv"
+		cw := csv.NewWriter(&buf)
+		_ = cw.Write([]string{"seq", "time", "actor", "ip", "action", "clients", "models", "outcome", "detail", "request_id", "captures", "hash"})
+		for _, e := range entries {
+			_ = cw.Write([]string{strconv.Itoa(e.Seq), e.Time.Format(time.RFC3339), e.Actor, e.IP, e.Action,
+				strings.Join(e.Clients, ";"), strings.Join(e.Models, ";"), e.Outcome, e.Detail, e.RequestID,
+				strings.Join(e.Captures, ";"), e.Hash})
+		}
+		cw.Flush()
+	default:
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("unknown format %q (jsonl/csv)", format))
+		return
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	_, _ = w.Write(buf.Bytes())
+}
@@ -0,0 +1,145 @@
+package app
+
+import (
+	"encoding/csv"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuditLogHashChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	a := &App{audit: openAuditLog(path)}
+	act := auditActor{Name: "ada", IP: "10.0.0.2"}
+	a.recordAudit(act, auditEntry{Action: "capture", Clients: []string{"office"}, Outcome: auditOK})
+	a.recordAudit(act, auditEntry{Action: "analyze", Clients: []string{"office", "lab"}, Models: []string{"m1"}, Outcome: auditPartial})
+	a.recordAudit(auditActor{Name: "vic", IP: "10.0.0.3"}, auditEntry{Action: "users.set", Outcome: auditOK})
+
+	// 重新打开后接着原链追加
+	a.audit = openAuditLog(path)
+	a.recordAudit(act, auditEntry{Action: "chat", Outcome: auditError})
+	if n, err := a.audit.verify(); n != 4 || err != nil {
+		t.Fatalf("verify = %d, %v", n, err)
+	}
+
+	if got := a.audit.query(AuditQuery{Actor: "ada"}, 0); len(got) != 3 || got[0].Action != "chat" {
+		t.Fatalf("actor filter = %+v", got)
+	}
+	if got := a.audit.query(AuditQuery{Client: "lab"}, 0); len(got) != 1 || got[0].Seq != 2 {
+		t.Fatalf("client filter = %+v", got)
+	}
+	if got := a.audit.query(AuditQuery{Action: "users"}, 0); len(got) != 1 || got[0].Actor != "vic" {
+		t.Fatalf("action prefix filter = %+v", got)
+	}
+
+	// 篡改中间一条的结果
+	b, _ := os.ReadFile(path)
+	if err := os.WriteFile(path, []byte(strings.Replace(string(b), `"outcome":"partial"`, `"outcome":"ok"`, 1)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.audit.verify(); err == nil || !strings.Contains(err.Error(), "entry 2") {
+		t.Fatalf("tampered verify = %v", err)
+	}
+	// 删除第一行
+	lines := strings.SplitAfter(string(b), "\n")
+	if err := os.WriteFile(path, []byte(strings.Join(lines[1:], "")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.audit.verify(); err == nil {
+		t.Fatal("truncated log should fail verification")
+	}
+}
+
+func TestAuditCaptureOutcome(t *testing.T) {
+	a := &App{audit: openAuditLog("")}
+	opts := analyzeOptions{requestID: "r1", models: []string{"m1", "m2"}, actor: auditActor{Name: "oscar"}, targets: []string{"office", "lab"}}
+	items := []ImageEntry{{ID: "c1", Client: "office", ModelAnswers: []ModelAnswer{{Model: "m1", Answer: "A"}, {Model: "m2", Error: "timeout"}}}}
+	a.auditCapture(opts, true, items, nil)
+	a.auditCapture(opts, false, nil, errors.New("No connected clients"))
+	opts.targets = []string{"office"}
+	a.auditCapture(opts, true, []ImageEntry{{ID: "c2", ModelAnswers: []ModelAnswer{{Model: "m1", Error: "HTTP 500"}}}}, nil)
+
+	got := a.audit.query(AuditQuery{}, 0)
+	if len(got) != 3 {
+		t.Fatalf("entries = %+v", got)
+	}
+	if e := got[2]; e.Action != "analyze" || e.Outcome != auditPartial || len(e.Models) != 2 || e.Captures[0] != "c1" || e.RequestID != "r1" || e.Actor != "oscar" {
+		t.Fatalf("partial = %+v", e)
+	}
+	if e := got[1]; e.Action != "capture" || e.Outcome != auditError || e.Detail != "No connected clients" {
+		t.Fatalf("error = %+v", e)
+	}
+	if e := got[0]; e.Outcome != auditError {
+		t.Fatalf("all models failed = %+v", e)
+	}
+}
+
+func TestAuditPageAndExport(t *testing.T) {
+	a := &App{audit: openAuditLog(filepath.Join(t.TempDir(), "audit.jsonl"))}
+	a.recordAudit(auditActor{Name: "ada", IP: "10.0.0.2"}, auditEntry{Action: "analyze", Clients: []string{"office"}, Models: []string{"m1"}, Outcome: auditOK, Detail: "a,b"})
+	a.recordAudit(auditActor{Name: "vic", IP: "10.0.0.3"}, auditEntry{Action: "capture", Clients: []string{"lab"}, Outcome: auditOK})
+
+	w := httptest.NewRecorder()
+	a.handleAudit(w, httptest.NewRequest(http.MethodGet, "/audit?actor=ada", nil))
+	body := w.Body.String()
+	if w.Code != 200 || !strings.Contains(body, "哈希链校验通过") || !strings.Contains(body, "office") || strings.Contains(body, "10.0.0.3") ||
+		!strings.Contains(body, "/api/v1/audit/export?actor=ada&amp;format=csv") {
+		t.Fatalf("page = %d\n%s", w.Code, body)
+	}
+
+	w = httptest.NewRecorder()
+	a.handleAPIAuditExport(w, httptest.NewRequest(http.MethodGet, "/api/v1/audit/export?format=csv", nil))
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil || len(rows) != 3 || rows[1][2] != "ada" || rows[1][8] != "a,b" || rows[2][4] != "capture" {
+		t.Fatalf("csv = %v, %v", rows, err)
+	}
+	if !strings.Contains(w.Header().Get("Content-Disposition"), ".csv") {
+		t.Fatalf("disposition = %q", w.Header().Get("Content-Disposition"))
+	}
+
+	// 完整导出的 JSONL 与原日志一致，可离线校验
+	w = httptest.NewRecorder()
+	a.handleAPIAuditExport(w, httptest.NewRequest(http.MethodGet, "/api/v1/audit/export", nil))
+	orig, _ := os.ReadFile(a.audit.path)
+	if w.Body.String() != string(orig) {
+		t.Fatalf("jsonl export differs:\n%s\n%s", w.Body, orig)
+	}
+}
+
+func TestAuditAppendFailureKeepsChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	l := openAuditLog(path)
+	if err := l.append(auditEntry{Action: "login", Outcome: auditOK}); err != nil {
+		t.Fatal(err)
+	}
+	orig, _ := os.ReadFile(path)
+	// 日志路径暂时不可写：写入失败的记录不推进链尾
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(path, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.append(auditEntry{Action: "chat", Outcome: auditOK}); err == nil {
+		t.Fatal("append to a directory succeeded")
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, orig, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.append(auditEntry{Action: "capture", Outcome: auditOK}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := l.verify(); n != 2 || err != nil {
+		t.Fatalf("verify = %d, %v", n, err)
+	}
+	if got := l.query(AuditQuery{}, 1); len(got) != 1 || got[0].Action != "capture" || got[0].Seq != 2 {
+		t.Fatalf("latest = %+v", got)
+	}
+}
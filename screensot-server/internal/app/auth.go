@@ -0,0 +1,751 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// HTTPAuthConfig HTTP 页面与接口的登录认证；未启用时所有页面与接口对可访问 HTTP 端口的人开放
+type HTTPAuthConfig struct {
+	Enabled bool `json:"enabled"`
+	// 用户与 API 令牌文件，相对路径相对于 config.json 所在目录，默认 data_dir/users.json
+	UsersFile string `json:"users_file"`
+	// 会话有效期（小时），默认 12
+	SessionHours int `json:"session_hours"`
+	// 仅通过 HTTPS 发送会话 Cookie（经 HTTPS 反向代理部署时开启）
+	SecureCookie bool `json:"secure_cookie"`
+}
+
+// role 角色：viewer 查看历史，operator 触发截屏识别与修改数据，admin 管理客户端、用户与存储
+type role int
+
+const (
+	roleViewer role = iota + 1
+	roleOperator
+	roleAdmin
+)
+
+var roleNames = map[role]string{roleViewer: "viewer", roleOperator: "operator", roleAdmin: "admin"}
+
+func (r role) String() string { return roleNames[r] }
+
+func parseRole(s string) (role, error) {
+	for r, name := range roleNames {
+		if strings.EqualFold(strings.TrimSpace(s), name) {
+			return r, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown role %q (viewer/operator/admin)", s)
+}
+
+// User 本地用户；密码以 bcrypt 哈希保存
+type User struct {
+	Username     string `json:"username"`
+	PasswordHash string `json:"password_hash"`
+	Role         string `json:"role"`
+}
+
+// APIToken 脚本使用的 API 令牌（Authorization: Bearer <令牌>）；只保存哈希
+type APIToken struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Hash      string    `json:"hash"`
+	Role      string    `json:"role"`
+	CreatedBy string    `json:"created_by"`
+	Created   time.Time `json:"created"`
+}
+
+// bcryptCost 测试中调低以加快运行
+var bcryptCost = bcrypt.DefaultCost
+
+func hashPassword(pw string) (string, error) {
+	b, err := bcrypt.GenerateFromPassword([]byte(pw), bcryptCost)
+	return string(b), err
+}
+
+// userStore users.json：{"users": [...], "tokens": [...]}。
+// 运行中的服务器与 add-user 子命令共用同一文件：每次读写前按修改时间检查文件是否被外部改动，
+// 改动过则重新读取，避免 add-user 新建的用户无法登录、或被服务器下一次保存覆盖
+type userStore struct {
+	path string
+	mu   sync.RWMutex
+	// 最近一次读取或写入后文件的修改时间与大小
+	modTime time.Time
+	size    int64
+	Users   []User     `json:"users"`
+	Tokens  []APIToken `json:"tokens"`
+}
+
+func openUserStore(path string) (*userStore, error) {
+	s := &userStore{path: path}
+	if err := s.reloadLocked(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// reloadLocked 文件自上次读写后有变化时重新读取；文件不存在时保留内存中的内容
+func (s *userStore) reloadLocked() error {
+	fi, err := os.Stat(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if fi.ModTime().Equal(s.modTime) && fi.Size() == s.size {
+		return nil
+	}
+	b, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	var f struct {
+		Users  []User     `json:"users"`
+		Tokens []APIToken `json:"tokens"`
+	}
+	if err := json.Unmarshal(b, &f); err != nil {
+		return fmt.Errorf("decode %s: %w", s.path, err)
+	}
+	s.Users, s.Tokens = f.Users, f.Tokens
+	s.modTime, s.size = fi.ModTime(), fi.Size()
+	return nil
+}
+
+// refresh 读取前检查外部改动；读取失败时沿用内存中的内容
+func (s *userStore) refresh() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reloadLocked(); err != nil {
+		slog.Warn("reload users", "users_file", s.path, "err", err)
+	}
+}
+
+func (s *userStore) saveLocked() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	// 临时文件名唯一，服务器与 add-user 同时保存时不会互相覆盖临时文件
+	f, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), s.path)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	if fi, err := os.Stat(s.path); err == nil {
+		s.modTime, s.size = fi.ModTime(), fi.Size()
+	}
+	return nil
+}
+
+// setUser 新建或修改用户；password 为空时保留原密码
+func (s *userStore) setUser(username, password string, r role) error {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return errors.New("username is required")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reloadLocked(); err != nil {
+		return err
+	}
+	i := s.userLocked(username)
+	if i < 0 {
+		if password == "" {
+			return errors.New("password is required for new users")
+		}
+		s.Users = append(s.Users, User{Username: username})
+		i = len(s.Users) - 1
+	}
+	if password != "" {
+		h, err := hashPassword(password)
+		if err != nil {
+			return err
+		}
+		s.Users[i].PasswordHash = h
+	}
+	s.Users[i].Role = r.String()
+	return s.saveLocked()
+}
+
+func (s *userStore) deleteUser(username string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reloadLocked(); err != nil {
+		slog.Warn("reload users", "users_file", s.path, "err", err)
+		return false
+	}
+	i := s.userLocked(username)
+	if i < 0 {
+		return false
+	}
+	s.Users = append(s.Users[:i], s.Users[i+1:]...)
+	_ = s.saveLocked()
+	return true
+}
+
+func (s *userStore) userLocked(username string) int {
+	for i, u := range s.Users {
+		if u.Username == username {
+			return i
+		}
+	}
+	return -1
+}
+
+// dummyHash 用户不存在时也做一次 bcrypt 比较，避免按响应时间枚举用户名
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+// verify 校验用户名与密码
+func (s *userStore) verify(username, password string) (User, bool) {
+	s.refresh()
+	s.mu.RLock()
+	i := s.userLocked(username)
+	var u User
+	if i >= 0 {
+		u = s.Users[i]
+	}
+	s.mu.RUnlock()
+	if i < 0 {
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return User{}, false
+	}
+	return u, bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) == nil
+}
+
+// addToken 创建 API 令牌，返回仅此一次可见的明文
+func (s *userStore) addToken(name string, r role, createdBy string) (APIToken, string, error) {
+	token := "sst_" + randomHex(24)
+	t := APIToken{ID: newID(), Name: strings.TrimSpace(name), Hash: hashToken(token), Role: r.String(), CreatedBy: createdBy, Created: time.Now()}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reloadLocked(); err != nil {
+		return APIToken{}, "", err
+	}
+	s.Tokens = append(s.Tokens, t)
+	return t, token, s.saveLocked()
+}
+
+func (s *userStore) deleteToken(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reloadLocked(); err != nil {
+		slog.Warn("reload users", "users_file", s.path, "err", err)
+		return false
+	}
+	for i, t := range s.Tokens {
+		if t.ID == id {
+			s.Tokens = append(s.Tokens[:i], s.Tokens[i+1:]...)
+			_ = s.saveLocked()
+			return true
+		}
+	}
+	return false
+}
+
+func (s *userStore) lookupToken(token string) (APIToken, bool) {
+	h := hashToken(token)
+	s.refresh()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, t := range s.Tokens {
+		if subtle.ConstantTimeCompare([]byte(t.Hash), []byte(h)) == 1 {
+			return t, true
+		}
+	}
+	return APIToken{}, false
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// session 登录会话，仅保存在内存中，重启后需重新登录
+type session struct {
+	user    string
+	role    role
+	csrf    string
+	expires time.Time
+}
+
+// principal 当前请求的身份
+type principal struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+	// session 或 token
+	Via string `json:"via"`
+}
+
+type principalKey struct{}
+
+// principalFrom 取请求身份；未启用认证时为 nil
+func principalFrom(ctx context.Context) *principal {
+	p, _ := ctx.Value(principalKey{}).(*principal)
+	return p
+}
+
+const (
+	sessionCookie = "sst_session"
+	csrfCookie    = "sst_csrf"
+	csrfHeader    = "X-CSRF-Token"
+)
+
+// httpAuth 用户、会话与登录限流
+type httpAuth struct {
+	cfg      HTTPAuthConfig
+	users    *userStore
+	mu       sync.Mutex
+	sessions map[string]*session
+	limiter  *ipLimiter
+}
+
+// newHTTPAuth 未启用时返回 nil；用户文件无法读取时拒绝所有登录
+func newHTTPAuth(cfg HTTPAuthConfig) *httpAuth {
+	if !cfg.Enabled {
+		return nil
+	}
+	users, err := openUserStore(cfg.UsersFile)
+	if err != nil {
+		slog.Warn("load users", "err", err)
+		users = &userStore{path: cfg.UsersFile}
+	}
+	if len(users.Users) == 0 {
+		slog.Warn("http auth enabled but no users; run `server add-user` first", "users_file", cfg.UsersFile)
+	}
+	return &httpAuth{cfg: cfg, users: users, sessions: map[string]*session{}, limiter: newIPLimiter(0, 0, 0)}
+}
+
+func (h *httpAuth) sessionTTL() time.Duration {
+	if h.cfg.SessionHours <= 0 {
+		return 12 * time.Hour
+	}
+	return time.Duration(h.cfg.SessionHours) * time.Hour
+}
+
+// newSession 建立会话，顺带清理已过期的会话（过期会话在查找时也会删除，但不再访问的会话只能在此回收）
+func (h *httpAuth) newSession(u User) (string, *session) {
+	r, _ := parseRole(u.Role)
+	id := randomHex(32)
+	now := time.Now()
+	s := &session{user: u.Username, role: r, csrf: randomHex(16), expires: now.Add(h.sessionTTL())}
+	h.mu.Lock()
+	for k, old := range h.sessions {
+		if now.After(old.expires) {
+			delete(h.sessions, k)
+		}
+	}
+	h.sessions[id] = s
+	h.mu.Unlock()
+	return id, s
+}
+
+func (h *httpAuth) session(id string) *session {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.sessions[id]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(s.expires) {
+		delete(h.sessions, id)
+		return nil
+	}
+	return s
+}
+
+// dropUserSessions 用户被删除或降级后使其会话失效
+func (h *httpAuth) dropUserSessions(username string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for id, s := range h.sessions {
+		if s.user == username {
+			delete(h.sessions, id)
+		}
+	}
+}
+
+// identify 按 Bearer 令牌或会话 Cookie 识别请求；会话请求一并返回 session 供 CSRF 校验
+func (h *httpAuth) identify(r *http.Request) (*principal, role, *session) {
+	if v := r.Header.Get("Authorization"); strings.HasPrefix(v, "Bearer ") {
+		t, ok := h.users.lookupToken(strings.TrimSpace(strings.TrimPrefix(v, "Bearer ")))
+		if !ok {
+			return nil, 0, nil
+		}
+		rl, _ := parseRole(t.Role)
+		return &principal{Name: "token:" + t.Name, Role: t.Role, Via: "token"}, rl, nil
+	}
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil, 0, nil
+	}
+	s := h.session(c.Value)
+	if s == nil {
+		return nil, 0, nil
+	}
+	return &principal{Name: s.user, Role: s.role.String(), Via: "session"}, s.role, s
+}
+
+func isSafeMethod(m string) bool {
+	return m == http.MethodGet || m == http.MethodHead || m == http.MethodOptions
+}
+
+// require 包装处理函数：要求已登录且角色不低于 min；会话请求的非只读方法须携带 CSRF 令牌
+func (a *App) require(min role, h http.HandlerFunc) http.HandlerFunc {
+	return a.requireRW(min, min, h)
+}
+
+// requireRW 只读方法（GET/HEAD）要求 read 角色，其余方法要求 write 角色
+func (a *App) requireRW(read, write role, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.auth == nil {
+			h(w, r)
+			return
+		}
+		p, rl, s := a.auth.identify(r)
+		if p == nil {
+			denyHTTP(w, r, http.StatusUnauthorized, "login required")
+			return
+		}
+		need := write
+		if isSafeMethod(r.Method) {
+			need = read
+		}
+		if rl < need {
+			denyHTTP(w, r, http.StatusForbidden, fmt.Sprintf("role %s required", need))
+			return
+		}
+		if s != nil && !isSafeMethod(r.Method) {
+			got := r.Header.Get(csrfHeader)
+			if got == "" {
+				got = r.FormValue("csrf_token")
+			}
+			if subtle.ConstantTimeCompare([]byte(got), []byte(s.csrf)) != 1 {
+				denyHTTP(w, r, http.StatusForbidden, "invalid CSRF token")
+				return
+			}
+		}
+		h(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	}
+}
+
+// denyHTTP 接口返回 JSON 错误；页面未登录时跳转登录页
+func denyHTTP(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	if strings.HasPrefix(r.URL.Path, "/api/") || r.URL.Path == "/events" {
+		writeJSONError(w, status, msg)
+		return
+	}
+	if status == http.StatusUnauthorized {
+		http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+		return
+	}
+	http.Error(w, msg, status)
+}
+
+// safeNext 只允许站内跳转；其他地址返回空，登录后改跳 landingPage
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return ""
+	}
+	return next
+}
+
+// landingPage 未指定跳转地址时登录后的页面：operator 以上进入截屏识别，viewer 进入其有权查看的历史搜索（关闭存储时为用量统计）
+func (a *App) landingPage(r role) string {
+	switch {
+	case r >= roleOperator:
+		return "/one?mode=capture"
+	case a.captures != nil:
+		return "/search"
+	}
+	return "/usage"
+}
+
+// sameOrigin POST 登录时 Origin（缺失时取 Referer）须与 Host 一致，防止跨站提交登录表单（登录 CSRF）；
+// 二者都缺失时放行（非浏览器客户端，浏览器提交表单总会带 Origin）
+func sameOrigin(r *http.Request) bool {
+	src := r.Header.Get("Origin")
+	if src == "" || src == "null" {
+		src = r.Referer()
+	}
+	if src == "" {
+		return r.Header.Get("Origin") == ""
+	}
+	u, err := url.Parse(src)
+	return err == nil && u.Host != "" && strings.EqualFold(u.Host, r.Host)
+}
+
+// LoginPageData 登录页模板数据
+type LoginPageData struct {
+	Next  string
+	Error string
+}
+
+// handleLogin GET 显示登录页；POST 校验用户名密码并建立会话（同一 IP 连续失败将被暂时封禁）
+func (a *App) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if a.auth == nil {
+		http.Redirect(w, r, "/one?mode=capture", http.StatusSeeOther)
+		return
+	}
+	data := LoginPageData{Next: safeNext(r.FormValue("next"))}
+	if r.Method == http.MethodPost {
+		ip := requestIP(r)
+		username := r.FormValue("username")
+		if !sameOrigin(r) {
+			a.recordAudit(auditActor{Name: username, IP: ip}, auditEntry{Action: "login", Outcome: auditRefused, Detail: "跨站提交（Origin/Referer 与 Host 不符）"})
+			http.Error(w, "cross-origin login rejected", http.StatusForbidden)
+			return
+		}
+		if !a.auth.limiter.allowed(ip) {
+			a.recordAudit(auditActor{Name: username, IP: ip}, auditEntry{Action: "login", Outcome: auditRefused, Detail: "IP 已被暂时封禁"})
+			data.Error = "登录失败次数过多，请稍后再试"
+		} else if u, ok := a.auth.users.verify(username, r.FormValue("password")); !ok {
+			slog.Warn("login failed", "username", username, "ip", ip)
+			a.recordAudit(auditActor{Name: username, IP: ip}, auditEntry{Action: "login", Outcome: auditError, Detail: "用户名或密码错误"})
+			if a.auth.limiter.fail(ip) {
+				slog.Warn("too many login failures, ip banned", "ip", ip)
+			}
+			data.Error = "用户名或密码错误"
+		} else {
+			a.recordAudit(auditActor{Name: u.Username, IP: ip}, auditEntry{Action: "login", Outcome: auditOK})
+			id, s := a.auth.newSession(u)
+			a.setAuthCookies(w, id, s.csrf, s.expires)
+			next := data.Next
+			if next == "" {
+				next = a.landingPage(s.role)
+			}
+			http.Redirect(w, r, next, http.StatusSeeOther)
+			return
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+	tmpl, err := template.New("login").Parse(string(loginTemplate))
+	if err != nil {
+		http.Error(w, "Internal Server Error: unable to parse template", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_ = tmpl.Execute(w, data)
+}
+
+// handleLogout 结束会话。Cookie 为 SameSite=Strict，跨站链接不会携带会话，GET 注销无 CSRF 风险
+func (a *App) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if a.auth != nil {
+		if c, err := r.Cookie(sessionCookie); err == nil {
+			a.auth.mu.Lock()
+			delete(a.auth.sessions, c.Value)
+			a.auth.mu.Unlock()
+		}
+		a.setAuthCookies(w, "", "", time.Unix(0, 0))
+	}
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// setAuthCookies 会话 Cookie 为 HttpOnly；CSRF Cookie 供页面脚本读取后放入 X-CSRF-Token 请求头
+func (a *App) setAuthCookies(w http.ResponseWriter, id, csrf string, expires time.Time) {
+	for _, c := range []*http.Cookie{
+		{Name: sessionCookie, Value: id, HttpOnly: true},
+		{Name: csrfCookie, Value: csrf},
+	} {
+		c.Path = "/"
+		c.Expires = expires
+		c.SameSite = http.SameSiteStrictMode
+		c.Secure = a.auth.cfg.SecureCookie
+		http.SetCookie(w, c)
+	}
+}
+
+// handleAPIMe 返回当前身份，页面据此显示用户与隐藏无权限的按钮
+func (a *App) handleAPIMe(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"auth": a.auth != nil, "principal": principalFrom(r.Context())})
+}
+
+// handleAPIUsers 管理用户：GET 列表（不含密码哈希）；POST {"username","password","role"} 新建或修改；DELETE ?username=
+func (a *App) handleAPIUsers(w http.ResponseWriter, r *http.Request) {
+	if a.auth == nil {
+		writeJSONError(w, http.StatusNotFound, "http auth disabled")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		a.auth.users.mu.RLock()
+		out := make([]map[string]string, 0, len(a.auth.users.Users))
+		for _, u := range a.auth.users.Users {
+			out = append(out, map[string]string{"username": u.Username, "role": u.Role})
+		}
+		a.auth.users.mu.RUnlock()
+		sort.Slice(out, func(i, j int) bool { return out[i]["username"] < out[j]["username"] })
+		writeJSON(w, http.StatusOK, map[string]interface{}{"users": out})
+	case http.MethodPost:
+		var req struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+			Role     string `json:"role"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+		rl, err := parseRole(req.Role)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := a.auth.users.setUser(req.Username, req.Password, rl); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		// 角色或密码变更后需重新登录
+		a.auth.dropUserSessions(req.Username)
+		a.auditRequest(r, "users.set", auditOK, fmt.Sprintf("%s (%s)", req.Username, rl))
+		writeJSON(w, http.StatusOK, map[string]string{"username": req.Username, "role": rl.String()})
+	case http.MethodDelete:
+		name := r.URL.Query().Get("username")
+		if p := principalFrom(r.Context()); p != nil && p.Name == name {
+			writeJSONError(w, http.StatusBadRequest, "cannot delete yourself")
+			return
+		}
+		if !a.auth.users.deleteUser(name) {
+			writeJSONError(w, http.StatusNotFound, "user not found")
+			return
+		}
+		a.auth.dropUserSessions(name)
+		a.auditRequest(r, "users.delete", auditOK, name)
+		writeJSON(w, http.StatusOK, map[string]string{"deleted": name})
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// handleAPITokens 管理 API 令牌：GET 列表（不含哈希）；POST {"name","role"} 创建并返回明文（仅此一次）；DELETE ?id= 吊销
+func (a *App) handleAPITokens(w http.ResponseWriter, r *http.Request) {
+	if a.auth == nil {
+		writeJSONError(w, http.StatusNotFound, "http auth disabled")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		a.auth.users.mu.RLock()
+		out := make([]APIToken, 0, len(a.auth.users.Tokens))
+		for _, t := range a.auth.users.Tokens {
+			t.Hash = ""
+			out = append(out, t)
+		}
+		a.auth.users.mu.RUnlock()
+		writeJSON(w, http.StatusOK, map[string]interface{}{"tokens": out})
+	case http.MethodPost:
+		var req struct {
+			Name string `json:"name"`
+			Role string `json:"role"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+			writeJSONError(w, http.StatusBadRequest, "name is required")
+			return
+		}
+		rl, err := parseRole(req.Role)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		by := ""
+		if p := principalFrom(r.Context()); p != nil {
+			by = p.Name
+		}
+		t, token, err := a.auth.users.addToken(req.Name, rl, by)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		t.Hash = ""
+		a.auditRequest(r, "tokens.create", auditOK, fmt.Sprintf("%s %s (%s)", t.ID, t.Name, rl))
+		writeJSON(w, http.StatusOK, map[string]interface{}{"token": token, "info": t})
+	case http.MethodDelete:
+		if !a.auth.users.deleteToken(r.URL.Query().Get("id")) {
+			writeJSONError(w, http.StatusNotFound, "token not found")
+			return
+		}
+		a.auditRequest(r, "tokens.delete", auditOK, r.URL.Query().Get("id"))
+		writeJSON(w, http.StatusOK, map[string]string{"deleted": r.URL.Query().Get("id")})
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// requestIP 请求来源 IP（不信任 X-Forwarded-For）
+func requestIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// AddUser 实现 add-user 子命令：在配置的用户文件中新建或修改用户；未给出 -password 时从标准输入读取一行
+func AddUser(args []string) error {
+	flags := flag.NewFlagSet("add-user", flag.ContinueOnError)
+	username := flags.String("username", "", "用户名")
+	password := flags.String("password", "", "密码（留空则从标准输入读取）")
+	roleName := flags.String("role", "viewer", "角色：viewer / operator / admin")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	rl, err := parseRole(*roleName)
+	if err != nil {
+		return err
+	}
+	pw := *password
+	if pw == "" {
+		fmt.Fprint(os.Stderr, "password: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("read password: %w", err)
+		}
+		pw = strings.TrimRight(line, "\r\n")
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	users, err := openUserStore(cfg.HTTPAuth.UsersFile)
+	if err != nil {
+		return err
+	}
+	if err := users.setUser(*username, pw, rl); err != nil {
+		return err
+	}
+	fmt.Printf("user %q (%s) saved to %s\n", *username, rl, cfg.HTTPAuth.UsersFile)
+	return nil
+}
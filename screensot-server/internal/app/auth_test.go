@@ -0,0 +1,260 @@
+package app
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newAuthTestApp 启用认证并创建三种角色的用户
+func newAuthTestApp(t *testing.T) (*App, *http.ServeMux) {
+	t.Helper()
+	bcryptCost = bcrypt.MinCost
+	a := &App{auth: newHTTPAuth(HTTPAuthConfig{Enabled: true, UsersFile: filepath.Join(t.TempDir(), "users.json")})}
+	for name, r := range map[string]role{"vic": roleViewer, "oscar": roleOperator, "ada": roleAdmin} {
+		if err := a.auth.users.setUser(name, name+"-pw", r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", a.handleLogin)
+	mux.HandleFunc("/api/v1/me", a.require(roleViewer, a.handleAPIMe))
+	mux.HandleFunc("/api/v1/users", a.require(roleAdmin, a.handleAPIUsers))
+	mux.HandleFunc("/api/v1/tokens", a.require(roleAdmin, a.handleAPITokens))
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }
+	mux.HandleFunc("/page", a.require(roleViewer, ok))
+	mux.HandleFunc("/api/v1/op", a.requireRW(roleViewer, roleOperator, ok))
+	return a, mux
+}
+
+// login 登录并返回会话 Cookie 与 CSRF 令牌
+func login(t *testing.T, mux http.Handler, user, pw string) (*http.Cookie, string) {
+	t.Helper()
+	form := url.Values{"username": {user}, "password": {pw}, "next": {"/usage"}}
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/usage" {
+		t.Fatalf("login %s = %d %q", user, rec.Code, rec.Header().Get("Location"))
+	}
+	var sess *http.Cookie
+	var csrf string
+	for _, c := range rec.Result().Cookies() {
+		switch c.Name {
+		case sessionCookie:
+			sess = c
+			if !c.HttpOnly || c.SameSite != http.SameSiteStrictMode {
+				t.Fatalf("session cookie = %+v", c)
+			}
+		case csrfCookie:
+			csrf = c.Value
+		}
+	}
+	if sess == nil || csrf == "" {
+		t.Fatal("missing auth cookies")
+	}
+	return sess, csrf
+}
+
+func do(mux http.Handler, method, path string, setup func(*http.Request)) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader("{}"))
+	if setup != nil {
+		setup(req)
+	}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHTTPAuthRolesAndCSRF(t *testing.T) {
+	_, mux := newAuthTestApp(t)
+
+	if rec := do(mux, http.MethodGet, "/api/v1/me", nil); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("anonymous api = %d", rec.Code)
+	}
+	if rec := do(mux, http.MethodGet, "/page?x=1", nil); rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/login?next=%2Fpage%3Fx%3D1" {
+		t.Fatalf("anonymous page = %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+
+	vic, vicCSRF := login(t, mux, "vic", "vic-pw")
+	asVic := func(r *http.Request) { r.AddCookie(vic); r.Header.Set(csrfHeader, vicCSRF) }
+	if rec := do(mux, http.MethodGet, "/api/v1/me", asVic); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"role":"viewer"`) {
+		t.Fatalf("me = %d %s", rec.Code, rec.Body)
+	}
+	if rec := do(mux, http.MethodGet, "/api/v1/op", asVic); rec.Code != http.StatusNoContent {
+		t.Fatalf("viewer read = %d", rec.Code)
+	}
+	if rec := do(mux, http.MethodPost, "/api/v1/op", asVic); rec.Code != http.StatusForbidden {
+		t.Fatalf("viewer write = %d", rec.Code)
+	}
+	if rec := do(mux, http.MethodGet, "/api/v1/users", asVic); rec.Code != http.StatusForbidden {
+		t.Fatalf("viewer admin api = %d", rec.Code)
+	}
+
+	oscar, oscarCSRF := login(t, mux, "oscar", "oscar-pw")
+	if rec := do(mux, http.MethodPost, "/api/v1/op", func(r *http.Request) { r.AddCookie(oscar) }); rec.Code != http.StatusForbidden {
+		t.Fatalf("write without csrf = %d", rec.Code)
+	}
+	if rec := do(mux, http.MethodPost, "/api/v1/op", func(r *http.Request) { r.AddCookie(oscar); r.Header.Set(csrfHeader, vicCSRF) }); rec.Code != http.StatusForbidden {
+		t.Fatalf("write with foreign csrf = %d", rec.Code)
+	}
+	if rec := do(mux, http.MethodPost, "/api/v1/op", func(r *http.Request) { r.AddCookie(oscar); r.Header.Set(csrfHeader, oscarCSRF) }); rec.Code != http.StatusNoContent {
+		t.Fatalf("write with csrf = %d", rec.Code)
+	}
+}
+
+func TestHTTPAuthLoginFailuresBanIP(t *testing.T) {
+	_, mux := newAuthTestApp(t)
+	post := func(pw string) int {
+		form := url.Values{"username": {"ada"}, "password": {pw}}
+		return do(mux, http.MethodPost, "/login", func(r *http.Request) {
+			r.Body = io.NopCloser(strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}).Code
+	}
+	for i := 0; i < 5; i++ {
+		if code := post("wrong"); code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d = %d", i, code)
+		}
+	}
+	// 已封禁：正确密码也被拒绝
+	if code := post("ada-pw"); code != http.StatusUnauthorized {
+		t.Fatalf("banned login = %d", code)
+	}
+}
+
+func TestHTTPAuthLoginOriginAndSessionSweep(t *testing.T) {
+	a, mux := newAuthTestApp(t)
+	post := func(origin, referer string) int {
+		form := url.Values{"username": {"ada"}, "password": {"ada-pw"}}
+		return do(mux, http.MethodPost, "/login", func(r *http.Request) {
+			r.Body = io.NopCloser(strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if origin != "" {
+				r.Header.Set("Origin", origin)
+			}
+			if referer != "" {
+				r.Header.Set("Referer", referer)
+			}
+		}).Code
+	}
+	for _, c := range []struct {
+		origin, referer string
+		want            int
+	}{
+		{"https://evil.example", "", http.StatusForbidden},
+		{"", "https://evil.example/login", http.StatusForbidden},
+		{"null", "", http.StatusForbidden},
+		{"http://example.com", "", http.StatusSeeOther},
+		{"", "http://example.com/login?next=/usage", http.StatusSeeOther},
+		{"", "", http.StatusSeeOther},
+	} {
+		if got := post(c.origin, c.referer); got != c.want {
+			t.Errorf("origin %q referer %q = %d, want %d", c.origin, c.referer, got, c.want)
+		}
+	}
+
+	// 新建会话时清理已过期的会话
+	a.auth.mu.Lock()
+	for _, s := range a.auth.sessions {
+		s.expires = time.Now().Add(-time.Minute)
+	}
+	a.auth.mu.Unlock()
+	a.auth.newSession(User{Username: "ada", Role: "admin"})
+	if n := len(a.auth.sessions); n != 1 {
+		t.Fatalf("sessions after sweep = %d", n)
+	}
+}
+
+func TestHTTPAuthAPITokens(t *testing.T) {
+	a, mux := newAuthTestApp(t)
+	_, token, err := a.auth.users.addToken("ci", roleOperator, "ada")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bearer := func(tok string) func(*http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+tok) }
+	}
+	// 令牌请求不经 Cookie，无需 CSRF
+	if rec := do(mux, http.MethodPost, "/api/v1/op", bearer(token)); rec.Code != http.StatusNoContent {
+		t.Fatalf("token write = %d", rec.Code)
+	}
+	if rec := do(mux, http.MethodGet, "/api/v1/tokens", bearer(token)); rec.Code != http.StatusForbidden {
+		t.Fatalf("operator token admin api = %d", rec.Code)
+	}
+	if rec := do(mux, http.MethodGet, "/api/v1/me", bearer("sst_bogus")); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("bogus token = %d", rec.Code)
+	}
+
+	id := a.auth.users.Tokens[0].ID
+	if !a.auth.users.deleteToken(id) {
+		t.Fatal("delete token failed")
+	}
+	if rec := do(mux, http.MethodPost, "/api/v1/op", bearer(token)); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("deleted token = %d", rec.Code)
+	}
+
+	// 重新打开用户文件：令牌仅以哈希保存
+	reopened, err := openUserStore(a.auth.users.path)
+	if err != nil || len(reopened.Users) != 3 || len(reopened.Tokens) != 0 {
+		t.Fatalf("reopen = %+v, %v", reopened, err)
+	}
+}
+
+func TestSafeNext(t *testing.T) {
+	for in, want := range map[string]string{
+		"/chat?id=1":           "/chat?id=1",
+		"//evil.example":       "",
+		"/\\evil.example":      "",
+		"https://evil.example": "",
+		"":                     "",
+	} {
+		if got := safeNext(in); got != want {
+			t.Errorf("safeNext(%q) = %q", in, got)
+		}
+	}
+}
+
+// 未指定跳转地址时按角色进入有权查看的页面，viewer 不会被送往需要 operator 的截屏页
+func TestLoginLandingPage(t *testing.T) {
+	_, mux := newAuthTestApp(t)
+	for user, want := range map[string]string{"vic": "/usage", "oscar": "/one?mode=capture", "ada": "/one?mode=capture"} {
+		form := url.Values{"username": {user}, "password": {user + "-pw"}}
+		rec := do(mux, http.MethodPost, "/login", func(r *http.Request) {
+			r.Body = io.NopCloser(strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		})
+		if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != want {
+			t.Errorf("login %s = %d %q, want %q", user, rec.Code, rec.Header().Get("Location"), want)
+		}
+	}
+}
+
+// add-user 在服务器运行期间修改用户文件：新用户可直接登录，服务器之后的保存不会覆盖该用户
+func TestUserStoreReloadsExternalChanges(t *testing.T) {
+	a, mux := newAuthTestApp(t)
+	cli, err := openUserStore(a.auth.users.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.setUser("carol", "carol-pw", roleViewer); err != nil {
+		t.Fatal(err)
+	}
+	login(t, mux, "carol", "carol-pw")
+
+	if err := a.auth.users.setUser("dave", "dave-pw", roleViewer); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := openUserStore(a.auth.users.path)
+	if err != nil || reopened.userLocked("carol") < 0 || reopened.userLocked("dave") < 0 {
+		t.Fatalf("reopen = %+v, %v", reopened, err)
+	}
+}
@@ -0,0 +1,461 @@
+package app
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BankConfig 本地题库：识别/转写出的题目先与题库模糊匹配，高置信度命中直接展示“题库命中”
+type BankConfig struct {
+	Enabled bool `json:"enabled"`
+	// 命中阈值（0~1 的二元组相似度），默认 0.85
+	Threshold float64 `json:"threshold"`
+	// alongside（默认）：命中结果与模型答案并列展示；instead：两阶段识别时转写稿命中即不再调用推理模型
+	Mode string `json:"mode"`
+}
+
+func (c BankConfig) threshold() float64 {
+	if c.Threshold <= 0 || c.Threshold > 1 {
+		return 0.85
+	}
+	return c.Threshold
+}
+
+// BankEntry 题库中的一道题
+type BankEntry struct {
+	ID       string   `json:"id"`
+	Question string   `json:"question"`
+	Answer   string   `json:"answer"`
+	Tags     []string `json:"tags,omitempty"`
+	// 来源：import、manual、confirmed（由识别结果确认入库）
+	Source  string    `json:"source,omitempty"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+}
+
+// BankHit 题库匹配结果
+type BankHit struct {
+	Entry BankEntry `json:"entry"`
+	// 0~1 相似度
+	Score float64 `json:"score"`
+}
+
+// questionBank 保存在 data_dir/bank.json 的题库；启动时载入内存，修改后整体重写
+type questionBank struct {
+	mu      sync.RWMutex
+	path    string
+	entries []BankEntry
+	// 与 entries 一一对应的归一化文本与二元组，匹配时免重复计算
+	keys  []string
+	grams []map[string]bool
+}
+
+// openQuestionBank 载入题库；文件不存在时从空题库开始
+func openQuestionBank(path string) *questionBank {
+	b := &questionBank{path: path}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Warn("open question bank", "err", err)
+		}
+		return b
+	}
+	var entries []BankEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		slog.Warn("open question bank", "err", err)
+		return b
+	}
+	for _, e := range entries {
+		b.appendLocked(e)
+	}
+	return b
+}
+
+func (b *questionBank) appendLocked(e BankEntry) {
+	key := questionKey(e.Question)
+	b.entries = append(b.entries, e)
+	b.keys = append(b.keys, key)
+	b.grams = append(b.grams, bigrams(key))
+}
+
+func (b *questionBank) saveLocked() error {
+	if b.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(b.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(b.path), 0o755); err != nil {
+		return err
+	}
+	tmp := b.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, b.path)
+}
+
+func (b *questionBank) indexLocked(id string) int {
+	for i, e := range b.entries {
+		if e.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// upsert 新增题目；归一化后相同的题目已存在时更新其答案。返回保存后的条目与是否新增
+func (b *questionBank) upsert(e BankEntry) (BankEntry, bool, error) {
+	e.Question, e.Answer = strings.TrimSpace(e.Question), strings.TrimSpace(e.Answer)
+	if questionKey(e.Question) == "" || e.Answer == "" {
+		return BankEntry{}, false, errors.New("question and answer are required")
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	key := questionKey(e.Question)
+	for i, k := range b.keys {
+		if k == key {
+			b.entries[i].Answer = e.Answer
+			if len(e.Tags) > 0 {
+				b.entries[i].Tags = e.Tags
+			}
+			b.entries[i].Updated = now
+			return b.entries[i], false, b.saveLocked()
+		}
+	}
+	e.ID = newID()
+	e.Created, e.Updated = now, now
+	b.appendLocked(e)
+	return e, true, b.saveLocked()
+}
+
+// importEntries 批量导入，只在最后写一次文件；返回新增与更新的数量
+func (b *questionBank) importEntries(entries []BankEntry) (added, updated int, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	for _, e := range entries {
+		e.Question, e.Answer = strings.TrimSpace(e.Question), strings.TrimSpace(e.Answer)
+		key := questionKey(e.Question)
+		if key == "" || e.Answer == "" {
+			continue
+		}
+		if e.Source == "" {
+			e.Source = "import"
+		}
+		found := false
+		for i, k := range b.keys {
+			if k == key {
+				b.entries[i].Answer, b.entries[i].Updated = e.Answer, now
+				updated++
+				found = true
+				break
+			}
+		}
+		if !found {
+			e.ID, e.Created, e.Updated = newID(), now, now
+			b.appendLocked(e)
+			added++
+		}
+	}
+	return added, updated, b.saveLocked()
+}
+
+// update 修改题目内容；id 不存在时返回 errBlobNotFound
+func (b *questionBank) update(id string, e BankEntry) (BankEntry, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	i := b.indexLocked(id)
+	if i < 0 {
+		return BankEntry{}, errBlobNotFound
+	}
+	cur := &b.entries[i]
+	if q := strings.TrimSpace(e.Question); q != "" {
+		cur.Question = q
+		b.keys[i] = questionKey(q)
+		b.grams[i] = bigrams(b.keys[i])
+	}
+	if a := strings.TrimSpace(e.Answer); a != "" {
+		cur.Answer = a
+	}
+	if e.Tags != nil {
+		cur.Tags = e.Tags
+	}
+	cur.Updated = time.Now()
+	return *cur, b.saveLocked()
+}
+
+func (b *questionBank) remove(id string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	i := b.indexLocked(id)
+	if i < 0 {
+		return errBlobNotFound
+	}
+	b.entries = append(b.entries[:i], b.entries[i+1:]...)
+	b.keys = append(b.keys[:i], b.keys[i+1:]...)
+	b.grams = append(b.grams[:i], b.grams[i+1:]...)
+	return b.saveLocked()
+}
+
+// list 返回题目或答案包含 q 的条目（q 为空时返回全部），新条目在前
+func (b *questionBank) list(q string, limit int) []BankEntry {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	key := questionKey(q)
+	out := []BankEntry{}
+	for i := len(b.entries) - 1; i >= 0 && (limit <= 0 || len(out) < limit); i-- {
+		e := b.entries[i]
+		if key == "" || strings.Contains(b.keys[i], key) || strings.Contains(questionKey(e.Answer), key) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// match 返回与题目文本最相似的条目；相似度为归一化文本二元组的 Dice 系数，过短的题目需完全一致
+func (b *questionBank) match(text string) (BankHit, bool) {
+	key := questionKey(text)
+	if key == "" {
+		return BankHit{}, false
+	}
+	g := bigrams(key)
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	var best BankHit
+	found := false
+	for i, k := range b.keys {
+		var score float64
+		switch {
+		case k == key:
+			score = 1
+		case len(g) < 3 || len(b.grams[i]) < 3:
+			continue
+		default:
+			common := 0
+			for x := range g {
+				if b.grams[i][x] {
+					common++
+				}
+			}
+			score = 2 * float64(common) / float64(len(g)+len(b.grams[i]))
+		}
+		if !found || score > best.Score {
+			best, found = BankHit{Entry: b.entries[i], Score: score}, true
+		}
+	}
+	return best, found
+}
+
+// bankMatch 按配置阈值匹配题库；未启用或未命中返回 nil
+func (a *App) bankMatch(text string) *BankHit {
+	if a.bank == nil || !a.conf().Bank.Enabled {
+		return nil
+	}
+	hit, ok := a.bank.match(text)
+	if !ok || hit.Score < a.conf().Bank.threshold() {
+		return nil
+	}
+	return &hit
+}
+
+// bankReplacesModels 两阶段识别的转写稿命中题库时是否跳过推理模型
+func (a *App) bankReplacesModels() bool {
+	return strings.EqualFold(a.conf().Bank.Mode, "instead")
+}
+
+// parseBankCSV 解析 CSV：首行为表头，需包含 question、answer 列，可选 tags 列（以 | 分隔）
+func parseBankCSV(r io.Reader) ([]BankEntry, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	col := map[string]int{}
+	for i, h := range rows[0] {
+		// Excel 导出的 CSV 可能带 UTF-8 BOM
+		col[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))] = i
+	}
+	qi, ok1 := col["question"]
+	ai, ok2 := col["answer"]
+	if !ok1 || !ok2 {
+		return nil, errors.New("CSV header must contain question and answer columns")
+	}
+	ti, hasTags := col["tags"]
+	var out []BankEntry
+	for _, row := range rows[1:] {
+		if qi >= len(row) || ai >= len(row) {
+			continue
+		}
+		e := BankEntry{Question: row[qi], Answer: row[ai]}
+		if hasTags && ti < len(row) && strings.TrimSpace(row[ti]) != "" {
+			e.Tags = strings.Split(row[ti], "|")
+		}
+		out = append(out, e)
+	}
+	return out, nil
+}
+
+// handleAPIBank 题库增删改查：GET ?q=&limit= 列表；POST 新增；PUT ?id= 修改；DELETE ?id= 删除
+func (a *App) handleAPIBank(w http.ResponseWriter, r *http.Request) {
+	if a.bank == nil {
+		writeJSONError(w, http.StatusNotFound, "question bank disabled")
+		return
+	}
+	id := r.URL.Query().Get("id")
+	switch r.Method {
+	case http.MethodGet:
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		writeJSON(w, http.StatusOK, map[string]interface{}{"entries": a.bank.list(r.URL.Query().Get("q"), limit)})
+	case http.MethodPost:
+		var e BankEntry
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+		e.Source = "manual"
+		saved, added, err := a.bank.upsert(e)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		status := http.StatusOK
+		if added {
+			status = http.StatusCreated
+		}
+		writeJSON(w, status, saved)
+	case http.MethodPut:
+		var e BankEntry
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+		saved, err := a.bank.update(id, e)
+		if errors.Is(err, errBlobNotFound) {
+			writeJSONError(w, http.StatusNotFound, "entry not found")
+			return
+		} else if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, saved)
+	case http.MethodDelete:
+		if err := a.bank.remove(id); errors.Is(err, errBlobNotFound) {
+			writeJSONError(w, http.StatusNotFound, "entry not found")
+			return
+		} else if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// handleAPIBankImport POST 批量导入：JSON 数组，或 CSV（Content-Type 为 text/csv 或 ?format=csv）
+func (a *App) handleAPIBankImport(w http.ResponseWriter, r *http.Request) {
+	if a.bank == nil {
+		writeJSONError(w, http.StatusNotFound, "question bank disabled")
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var entries []BankEntry
+	if r.URL.Query().Get("format") == "csv" || strings.HasPrefix(r.Header.Get("Content-Type"), "text/csv") {
+		entries, err = parseBankCSV(bytes.NewReader(body))
+	} else {
+		err = json.Unmarshal(body, &entries)
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "parse import: "+err.Error())
+		return
+	}
+	added, updated, err := a.bank.importEntries(entries)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"added": added, "updated": updated})
+}
+
+// handleAPIBankConfirm POST {"capture_id","model"} 将某截图中某模型（或多数答案）的识别结果确认入库；
+// 也可直接给出 question / answer 覆盖识别结果
+func (a *App) handleAPIBankConfirm(w http.ResponseWriter, r *http.Request) {
+	if a.bank == nil {
+		writeJSONError(w, http.StatusNotFound, "question bank disabled")
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var req struct {
+		CaptureID string `json:"capture_id"`
+		Model     string `json:"model"`
+		Question  string `json:"question"`
+		Answer    string `json:"answer"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if req.CaptureID != "" {
+		c, _, status, err := a.loadCapture(req.CaptureID)
+		if err != nil {
+			writeJSONError(w, status, err.Error())
+			return
+		}
+		q, ans := confirmedAnswer(c, req.Model)
+		if req.Question == "" {
+			req.Question = q
+		}
+		if req.Answer == "" {
+			req.Answer = ans
+		}
+	}
+	saved, _, err := a.bank.upsert(BankEntry{Question: req.Question, Answer: req.Answer, Source: "confirmed"})
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, saved)
+}
+
+// confirmedAnswer 取截图中指定模型的答案；未指定模型时取多数答案组的首个模型，无多数时取首个有效答案
+func confirmedAnswer(c Capture, model string) (string, string) {
+	if model == "" && c.Consensus != nil && c.Consensus.Majority != "" && len(c.Consensus.Groups) > 0 {
+		model = c.Consensus.Groups[0].Models[0]
+	}
+	for _, ans := range c.ModelAnswers {
+		if ans.Error != "" || (model != "" && ans.Model != model) {
+			continue
+		}
+		return ans.Question, ans.Answer
+	}
+	return "", ""
+}
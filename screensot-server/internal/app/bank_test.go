@@ -0,0 +1,141 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestQuestionBankMatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bank.json")
+	b := openQuestionBank(path)
+	if _, added, err := b.upsert(BankEntry{Question: "TCP 三次握手中，第二次握手发送的报文是？", Answer: "SYN+ACK"}); err != nil || !added {
+		t.Fatalf("upsert: %v %v", added, err)
+	}
+	// 归一化后相同的题目只更新答案
+	if _, added, _ := b.upsert(BankEntry{Question: "TCP三次握手中,第二次握手发送的报文是?", Answer: "SYN ACK"}); added {
+		t.Fatal("duplicate question should update")
+	}
+	hit, ok := b.match("tcp 三次握手中第二次握手发送的报文是什么")
+	if !ok || hit.Score < 0.85 || hit.Entry.Answer != "SYN ACK" {
+		t.Fatalf("hit = %+v", hit)
+	}
+	if hit, _ := b.match("HTTP 状态码 404 表示什么"); hit.Score > 0.5 {
+		t.Fatalf("unrelated question scored %v", hit.Score)
+	}
+
+	// 重新载入后内容一致
+	if got := openQuestionBank(path).list("", 0); len(got) != 1 || got[0].Answer != "SYN ACK" {
+		t.Fatalf("reloaded = %+v", got)
+	}
+}
+
+func TestBankImportAndCRUD(t *testing.T) {
+	a := &App{bank: openQuestionBank(filepath.Join(t.TempDir(), "bank.json"))}
+	do := func(method, target, body, ctype string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		if ctype != "" {
+			r.Header.Set("Content-Type", ctype)
+		}
+		w := httptest.NewRecorder()
+		switch {
+		case strings.HasPrefix(target, "/api/v1/bank/import"):
+			a.handleAPIBankImport(w, r)
+		default:
+			a.handleAPIBank(w, r)
+		}
+		return w
+	}
+	csvBody := "\ufeffquestion,answer,tags\n1+1=?,2,math|easy\n\"首都是哪里，北京还是上海\",北京,\n"
+	if w := do(http.MethodPost, "/api/v1/bank/import", csvBody, "text/csv"); w.Code != 200 || !strings.Contains(w.Body.String(), `"added":2`) {
+		t.Fatalf("csv import: %d %s", w.Code, w.Body.String())
+	}
+	if w := do(http.MethodPost, "/api/v1/bank/import", `[{"question":"1+1=?","answer":"二"},{"question":"2+2=?","answer":"4"}]`, "application/json"); !strings.Contains(w.Body.String(), `"added":1,"updated":1`) {
+		t.Fatalf("json import: %s", w.Body.String())
+	}
+	entries := a.bank.list("1+1", 0)
+	if len(entries) != 1 || entries[0].Answer != "二" || len(entries[0].Tags) != 2 {
+		t.Fatalf("entries = %+v", entries)
+	}
+	id := entries[0].ID
+	if w := do(http.MethodPut, "/api/v1/bank?id="+id, `{"answer":"2"}`, ""); w.Code != 200 {
+		t.Fatalf("put: %d %s", w.Code, w.Body.String())
+	}
+	if w := do(http.MethodDelete, "/api/v1/bank?id="+id, "", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("delete: %d", w.Code)
+	}
+	if w := do(http.MethodDelete, "/api/v1/bank?id="+id, "", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("second delete: %d", w.Code)
+	}
+	if w := do(http.MethodGet, "/api/v1/bank", "", ""); !strings.Contains(w.Body.String(), "北京") || strings.Contains(w.Body.String(), "1+1") {
+		t.Fatalf("list: %s", w.Body.String())
+	}
+}
+
+func TestBankConfirmFromCapture(t *testing.T) {
+	a := &App{
+		bank:     openQuestionBank(filepath.Join(t.TempDir(), "bank.json")),
+		captures: newCaptureStore(StorageConfig{Backend: "local", Dir: t.TempDir()}),
+	}
+	items := []ImageEntry{{Base64: "aW1n", ModelAnswers: []ModelAnswer{{Model: "m1", Question: "q", Answer: "B"}, {Model: "m2", Question: "q", Answer: "C"}}}}
+	a.saveCaptures(items, analyzeOptions{requestID: "r"})
+	w := httptest.NewRecorder()
+	a.handleAPIBankConfirm(w, httptest.NewRequest(http.MethodPost, "/api/v1/bank/confirm", strings.NewReader(`{"capture_id":"`+items[0].ID+`","model":"m2"}`)))
+	if w.Code != 200 {
+		t.Fatalf("confirm: %d %s", w.Code, w.Body.String())
+	}
+	if got := a.bank.list("", 0); len(got) != 1 || got[0].Answer != "C" || got[0].Source != "confirmed" {
+		t.Fatalf("bank = %+v", got)
+	}
+}
+
+func TestBankHitSkipsReasoning(t *testing.T) {
+	var reasonCalls int32
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model string `json:"model"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if req.Model == "vl" {
+			fmt.Fprint(w, `{"choices":[{"message":{"content":"TCP 三次握手中，第二次握手发送的报文是？"}}]}`)
+			return
+		}
+		atomic.AddInt32(&reasonCalls, 1)
+		fmt.Fprint(w, `{"choices":[{"message":{"content":"{\"question\":\"q\",\"answer\":\"x\"}"}}]}`)
+	}))
+	defer up.Close()
+
+	a := &App{
+		cfg: Config{
+			Models:             []string{"vl"},
+			SiliconflowBaseURL: up.URL,
+			SiliconflowAPIKey:  "k",
+			Pipeline:           PipelineConfig{ReasonModels: []string{"text"}},
+			Bank:               BankConfig{Enabled: true, Mode: "instead"},
+		},
+		bank: openQuestionBank(""),
+	}
+	_, _, _ = a.bank.upsert(BankEntry{Question: "TCP 三次握手中，第二次握手发送的报文是？", Answer: "SYN+ACK"})
+	opts := analyzeOptions{pipeline: a.pipelineFor(url.Values{"pipeline": {"1"}}, nil)}
+	items := a.analyzeImages(context.Background(), []screenshot{{Base64: "aW1n"}}, opts)
+	if items[0].BankHit == nil || items[0].BankHit.Entry.Answer != "SYN+ACK" {
+		t.Fatalf("bank hit = %+v", items[0].BankHit)
+	}
+	if reasonCalls != 0 || len(items[0].ModelAnswers) != 0 {
+		t.Fatalf("reasoning should be skipped: calls=%d answers=%+v", reasonCalls, items[0].ModelAnswers)
+	}
+
+	// alongside 模式：命中与模型答案并列
+	a.cfg.Bank.Mode = ""
+	items = a.analyzeImages(context.Background(), []screenshot{{Base64: "aW1n"}}, opts)
+	if items[0].BankHit == nil || len(items[0].ModelAnswers) != 1 {
+		t.Fatalf("item = %+v", items[0])
+	}
+}
@@ -0,0 +1,254 @@
+package app
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheConfig 识别结果缓存配置
+type CacheConfig struct {
+	// 后端：memory、disk；留空或 off 表示关闭
+	Backend string `json:"backend"`
+	// 过期时间（秒），默认 3600
+	TTLSeconds int `json:"ttl_seconds"`
+	// 最多缓存条目数，默认 256
+	MaxEntries int `json:"max_entries"`
+	// disk 后端目录，相对路径相对于 config.json 所在目录，默认 cache
+	Dir string `json:"dir"`
+}
+
+func (c CacheConfig) ttl() time.Duration {
+	if c.TTLSeconds <= 0 {
+		return time.Hour
+	}
+	return time.Duration(c.TTLSeconds) * time.Second
+}
+
+func (c CacheConfig) maxEntries() int {
+	if c.MaxEntries <= 0 {
+		return 256
+	}
+	return c.MaxEntries
+}
+
+// answerCache 识别结果缓存后端
+type answerCache interface {
+	Get(key string) (ModelAnswer, bool)
+	Put(key string, ans ModelAnswer)
+}
+
+// newAnswerCache 按配置创建缓存后端；关闭或配置无效时返回 nil
+func newAnswerCache(c CacheConfig) answerCache {
+	switch strings.ToLower(strings.TrimSpace(c.Backend)) {
+	case "memory":
+		return newMemoryCache(c.ttl(), c.maxEntries())
+	case "disk":
+		dc, err := newDiskCache(c.Dir, c.ttl(), c.maxEntries())
+		if err != nil {
+			slog.Warn("disk cache disabled", "err", err)
+			return nil
+		}
+		return dc
+	case "", "off", "none":
+		return nil
+	default:
+		slog.Warn("unknown cache backend, cache disabled", "backend", c.Backend)
+		return nil
+	}
+}
+
+// callVisionCached 在 callVision 之前查询缓存；仅缓存成功的结果，命中时标记 Cached
+func (a *App) callVisionCached(ctx context.Context, vr visionRequest, noCache bool) ModelAnswer {
+	if a.cache == nil {
+		return a.callVisionScheduled(ctx, vr)
+	}
+	key := a.cacheKey(vr)
+	if !noCache {
+		ans, ok := a.cache.Get(key)
+		a.metrics.observeCacheLookup(ok)
+		if ok {
+			ans.Cached = true
+			return ans
+		}
+	}
+	ans := a.callVisionScheduled(ctx, vr)
+	if ans.Error == "" {
+		a.cache.Put(key, ans)
+	}
+	return ans
+}
+
+// cacheKey = SHA-256(图片字节的 SHA-256 | 模型 | 网关与请求参数 | 预处理参数 | 提示词版本 | 自定义提示词与文本输入)
+func (a *App) cacheKey(vr visionRequest) string {
+	img, err := base64.StdEncoding.DecodeString(vr.image)
+	if err != nil {
+		img = []byte(vr.image)
+	}
+	imgSum := sha256.Sum256(img)
+	preJSON, _ := json.Marshal(vr.pre)
+	_, provider := a.conf().providerFor(vr.model)
+	h := sha256.New()
+	fmt.Fprintf(h, "%x|%s|%s|%v|%d|%s|%s", imgSum, vr.model, strings.TrimRight(provider.BaseURL, "/"), visionTemperature, visionMaxTokens, preJSON, promptVersion)
+	// 两阶段识别的转写/推理请求；默认识别请求不追加，已有缓存保持有效
+	if vr.system != "" || vr.prompt != "" || vr.text != "" || vr.raw {
+		fmt.Fprintf(h, "|%q|%q|%q|%v", vr.system, vr.prompt, vr.text, vr.raw)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// memoryCache 进程内 LRU + TTL 缓存
+type memoryCache struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	max   int
+	ll    *list.List // 前端为最近使用
+	items map[string]*list.Element
+	now   func() time.Time
+}
+
+type memoryEntry struct {
+	key      string
+	ans      ModelAnswer
+	storedAt time.Time
+}
+
+func newMemoryCache(ttl time.Duration, max int) *memoryCache {
+	return &memoryCache{ttl: ttl, max: max, ll: list.New(), items: map[string]*list.Element{}, now: time.Now}
+}
+
+func (c *memoryCache) Get(key string) (ModelAnswer, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return ModelAnswer{}, false
+	}
+	ent := el.Value.(*memoryEntry)
+	if c.now().Sub(ent.storedAt) > c.ttl {
+		c.ll.Remove(el)
+		delete(c.items, key)
+		return ModelAnswer{}, false
+	}
+	c.ll.MoveToFront(el)
+	return ent.ans, true
+}
+
+func (c *memoryCache) Put(key string, ans ModelAnswer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		el.Value = &memoryEntry{key: key, ans: ans, storedAt: c.now()}
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&memoryEntry{key: key, ans: ans, storedAt: c.now()})
+	for c.ll.Len() > c.max {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*memoryEntry).key)
+	}
+}
+
+// diskCache 每个条目一个 JSON 文件；文件修改时间作为最近使用时间，超出上限时淘汰最旧的
+type diskCache struct {
+	mu  sync.Mutex
+	dir string
+	ttl time.Duration
+	max int
+	now func() time.Time
+}
+
+type diskEntry struct {
+	StoredAt time.Time   `json:"stored_at"`
+	Answer   ModelAnswer `json:"answer"`
+}
+
+func newDiskCache(dir string, ttl time.Duration, max int) (*diskCache, error) {
+	if dir == "" {
+		dir = "cache"
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &diskCache{dir: dir, ttl: ttl, max: max, now: time.Now}, nil
+}
+
+func (c *diskCache) path(key string) string { return filepath.Join(c.dir, key+".json") }
+
+func (c *diskCache) Get(key string) (ModelAnswer, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return ModelAnswer{}, false
+	}
+	var ent diskEntry
+	if err := json.Unmarshal(b, &ent); err != nil || c.now().Sub(ent.StoredAt) > c.ttl {
+		_ = os.Remove(c.path(key))
+		return ModelAnswer{}, false
+	}
+	now := c.now()
+	_ = os.Chtimes(c.path(key), now, now)
+	return ent.Answer, true
+}
+
+func (c *diskCache) Put(key string, ans ModelAnswer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, err := json.Marshal(diskEntry{StoredAt: c.now(), Answer: ans})
+	if err != nil {
+		return
+	}
+	tmp := c.path(key) + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		slog.Warn("write cache", "err", err)
+		return
+	}
+	if err := os.Rename(tmp, c.path(key)); err != nil {
+		slog.Warn("write cache", "err", err)
+		return
+	}
+	now := c.now()
+	_ = os.Chtimes(c.path(key), now, now)
+	c.evict()
+}
+
+// evict 条目数超过上限时按修改时间从旧到新删除
+func (c *diskCache) evict() {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return
+	}
+	type fileAge struct {
+		name string
+		mod  time.Time
+	}
+	var files []fileAge
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		if info, err := e.Info(); err == nil {
+			files = append(files, fileAge{e.Name(), info.ModTime()})
+		}
+	}
+	if len(files) <= c.max {
+		return
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].mod.Before(files[j].mod) })
+	for _, f := range files[:len(files)-c.max] {
+		_ = os.Remove(filepath.Join(c.dir, f.name))
+	}
+}
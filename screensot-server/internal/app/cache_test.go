@@ -0,0 +1,73 @@
+package app
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryCacheLRUAndTTL(t *testing.T) {
+	now := time.Unix(1000, 0)
+	c := newMemoryCache(time.Minute, 2)
+	c.now = func() time.Time { return now }
+
+	c.Put("a", ModelAnswer{Answer: "A"})
+	c.Put("b", ModelAnswer{Answer: "B"})
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("a should be cached")
+	}
+	c.Put("c", ModelAnswer{Answer: "C"}) // 淘汰最久未用的 b
+	if _, ok := c.Get("b"); ok {
+		t.Fatal("b should be evicted")
+	}
+	if ans, ok := c.Get("c"); !ok || ans.Answer != "C" {
+		t.Fatalf("c = %+v, %v", ans, ok)
+	}
+	now = now.Add(2 * time.Minute)
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("a should be expired")
+	}
+}
+
+func TestDiskCache(t *testing.T) {
+	now := time.Now()
+	c, err := newDiskCache(t.TempDir(), time.Minute, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.now = func() time.Time { return now }
+
+	c.Put("a", ModelAnswer{Model: "m", Answer: "A"})
+	now = now.Add(time.Second)
+	c.Put("b", ModelAnswer{Answer: "B"})
+	now = now.Add(time.Second)
+	if ans, ok := c.Get("a"); !ok || ans.Answer != "A" || ans.Model != "m" {
+		t.Fatalf("a = %+v, %v", ans, ok)
+	}
+	now = now.Add(time.Second)
+	c.Put("c", ModelAnswer{Answer: "C"})
+	if _, ok := c.Get("b"); ok {
+		t.Fatal("b should be evicted")
+	}
+	now = now.Add(2 * time.Minute)
+	if _, ok := c.Get("c"); ok {
+		t.Fatal("c should be expired")
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	a := &App{cfg: Config{SiliconflowBaseURL: "https://api.example.com/"}}
+	k1 := a.cacheKey(visionRequest{model: "m1", image: "aW1n"})
+	if k1 != a.cacheKey(visionRequest{model: "m1", image: "aW1n"}) {
+		t.Fatal("key must be stable")
+	}
+	if k1 == a.cacheKey(visionRequest{model: "m2", image: "aW1n"}) || k1 == a.cacheKey(visionRequest{model: "m1", image: "aW1nMg=="}) {
+		t.Fatal("key must depend on model and image")
+	}
+	if k1 == a.cacheKey(visionRequest{model: "m1", image: "aW1n", pre: &PreprocessConfig{MaxDimension: 1024}}) {
+		t.Fatal("key must depend on preprocessing")
+	}
+	r1 := a.cacheKey(visionRequest{model: "m1", text: "题目一"})
+	if r1 == a.cacheKey(visionRequest{model: "m1", text: "题目二"}) || r1 == a.cacheKey(visionRequest{model: "m1", text: "题目一", prompt: "p"}) {
+		t.Fatal("key must depend on text input and prompt")
+	}
+}
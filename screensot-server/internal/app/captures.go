@@ -0,0 +1,295 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Capture 持久化的单张截图及其识别结果；追问对话随之保存
+type Capture struct {
+	ID        string    `json:"id"`
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id"`
+	Client    string    `json:"client"`
+	// 会话名（/one?session=），用于按场景归类与检索
+	Session string `json:"session,omitempty"`
+	// 用户标签
+	Tags []string `json:"tags,omitempty"`
+	// 置顶的截图不受保留策略清理
+	Pinned bool `json:"pinned,omitempty"`
+	// 图片字节数，用于按总大小清理
+	ImageBytes   int64         `json:"image_bytes,omitempty"`
+	ModelAnswers []ModelAnswer `json:"model_answers"`
+	Consensus    *Consensus    `json:"consensus,omitempty"`
+	Transcript   *ModelAnswer  `json:"transcript,omitempty"`
+	// 追问对话，按时间顺序
+	Thread []ChatMessage `json:"thread,omitempty"`
+}
+
+// captureStore 在 blobStore 上保存截图（images/<id>.png）与元数据（captures/<id>.json）
+type captureStore struct {
+	// 串行化元数据的读-改-写
+	mu    sync.Mutex
+	blobs blobStore
+	// 已上传供签名访问的图片 key（uploads/<sha256>）及最近一次签名时间，避免重复上传；
+	// 签名过期后由 sweepUploads 删除对象并移出，因此只保留有效期内的条目
+	uploadMu sync.Mutex
+	uploaded map[string]time.Time
+}
+
+// newCaptureStore 按存储配置创建；关闭存储时返回 nil
+func newCaptureStore(c StorageConfig) *captureStore {
+	blobs := newBlobStore(c)
+	if blobs == nil {
+		return nil
+	}
+	return &captureStore{blobs: blobs, uploaded: map[string]time.Time{}}
+}
+
+func captureKey(id string) string { return "captures/" + id + ".json" }
+func imageKey(id string) string   { return "images/" + id + ".png" }
+
+// validID 校验 newID 生成的 ID，防止拼接 key 时越权访问
+func validID(id string) bool {
+	if len(id) != 16 {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= '0' && r <= '9' || r >= 'a' && r <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// save 先写图片再写元数据，元数据存在即表示截图完整可用
+func (s *captureStore) save(c Capture, imageB64 string) error {
+	img, err := base64.StdEncoding.DecodeString(imageB64)
+	if err != nil {
+		return fmt.Errorf("decode image: %w", err)
+	}
+	c.ImageBytes = int64(len(img))
+	if err := s.blobs.Put(imageKey(c.ID), img); err != nil {
+		return err
+	}
+	return s.put(c)
+}
+
+func (s *captureStore) put(c Capture) error {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return s.blobs.Put(captureKey(c.ID), b)
+}
+
+// get 读取元数据；不存在时返回 errBlobNotFound
+func (s *captureStore) get(id string) (Capture, error) {
+	if !validID(id) {
+		return Capture{}, errBlobNotFound
+	}
+	b, err := s.blobs.Get(captureKey(id))
+	if err != nil {
+		return Capture{}, err
+	}
+	return decodeCapture(id, b)
+}
+
+func decodeCapture(id string, b []byte) (Capture, error) {
+	var c Capture
+	if err := json.Unmarshal(b, &c); err != nil {
+		return Capture{}, fmt.Errorf("decode capture %s: %w", id, err)
+	}
+	return c, nil
+}
+
+// image 读取截图原图字节
+func (s *captureStore) image(id string) ([]byte, error) {
+	if !validID(id) {
+		return nil, errBlobNotFound
+	}
+	return s.blobs.Get(imageKey(id))
+}
+
+// update 原子地修改元数据
+func (s *captureStore) update(id string, fn func(*Capture)) (Capture, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, err := s.get(id)
+	if err != nil {
+		return Capture{}, err
+	}
+	fn(&c)
+	return c, s.put(c)
+}
+
+// presignImage 开启 presign_images 且存储支持签名时，将发送给模型的图片上传为 uploads/<sha256> 并附上限时地址；
+// 失败时保留 data URI。签名过期后的 uploads/ 对象由后台清理删除（见 sweepUploads）。
+func (a *App) presignImage(img preparedImage) preparedImage {
+	if a.captures == nil || !a.conf().Storage.PresignImages || img.Base64 == "" {
+		return img
+	}
+	p, ok := a.captures.blobs.(presigner)
+	if !ok {
+		return img
+	}
+	raw, err := base64.StdEncoding.DecodeString(img.Base64)
+	if err != nil {
+		return img
+	}
+	sum := sha256.Sum256(raw)
+	key := "uploads/" + hex.EncodeToString(sum[:])
+	if err := a.captures.upload(key, raw, time.Now()); err != nil {
+		slog.Warn("upload image for presign", "err", err)
+		return img
+	}
+	u, err := p.PresignGet(key, a.conf().Storage.presignTTL())
+	if err != nil {
+		slog.Warn("presign image", "err", err)
+		return img
+	}
+	img.URL = u
+	return img
+}
+
+// upload 上传尚未上传的图片，并记录签名时间；同一图片再次签名时顺延其过期时间
+func (s *captureStore) upload(key string, raw []byte, now time.Time) error {
+	s.uploadMu.Lock()
+	defer s.uploadMu.Unlock()
+	if _, done := s.uploaded[key]; !done {
+		if err := s.blobs.Put(key, raw); err != nil {
+			return err
+		}
+	}
+	s.uploaded[key] = now
+	return nil
+}
+
+// sweepUploads 删除签名已过期的 uploads/ 对象；不在记录中的对象（如重启前上传的）也一并删除。返回删除的对象数
+func (s *captureStore) sweepUploads(ttl time.Duration, now time.Time) (int, error) {
+	keys, err := s.blobs.List("uploads/")
+	if err != nil {
+		return 0, err
+	}
+	s.uploadMu.Lock()
+	defer s.uploadMu.Unlock()
+	n := 0
+	for _, k := range keys {
+		if at, ok := s.uploaded[k]; ok && now.Sub(at) < ttl {
+			continue
+		}
+		if err := s.blobs.Delete(k); err != nil {
+			return n, err
+		}
+		delete(s.uploaded, k)
+		n++
+	}
+	// 已被外部删除的对象不再出现在列表中，同样移出记录
+	for k, at := range s.uploaded {
+		if now.Sub(at) >= ttl {
+			delete(s.uploaded, k)
+		}
+	}
+	return n, nil
+}
+
+// saveCaptures 持久化本次识别的每张截图并加入检索索引，回填 ImageEntry.ID 供页面链接
+func (a *App) saveCaptures(items []ImageEntry, opts analyzeOptions) {
+	if a.captures == nil {
+		return
+	}
+	now := time.Now()
+	for i := range items {
+		c := Capture{
+			ID:           newID(),
+			Time:         now,
+			RequestID:    opts.requestID,
+			Client:       items[i].Client,
+			Session:      opts.session,
+			Tags:         opts.tags,
+			ModelAnswers: items[i].ModelAnswers,
+			Consensus:    items[i].Consensus,
+			Transcript:   items[i].Transcript,
+		}
+		if err := a.captures.save(c, items[i].Base64); err != nil {
+			slog.Warn("save capture", "err", err)
+			continue
+		}
+		items[i].ID = c.ID
+		items[i].ImageURL = imageURL(c.ID)
+		if a.index != nil {
+			a.index.add(c)
+		}
+	}
+}
+
+// handleAPICapture GET ?id= 返回截图元数据；PATCH ?id= {"tags":[...],"pinned":true} 修改标签与置顶
+func (a *App) handleAPICapture(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	switch r.Method {
+	case http.MethodGet:
+		c, _, status, err := a.loadCapture(id)
+		if err != nil {
+			writeJSONError(w, status, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, c)
+	case http.MethodPatch:
+		if a.captures == nil {
+			writeJSONError(w, http.StatusNotFound, "capture storage disabled")
+			return
+		}
+		var req struct {
+			Tags   *[]string `json:"tags"`
+			Pinned *bool     `json:"pinned"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+		c, err := a.captures.update(id, func(c *Capture) {
+			if req.Tags != nil {
+				c.Tags = cleanTags(*req.Tags)
+			}
+			if req.Pinned != nil {
+				c.Pinned = *req.Pinned
+			}
+		})
+		if errors.Is(err, errBlobNotFound) {
+			writeJSONError(w, http.StatusNotFound, "capture not found")
+			return
+		} else if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if a.index != nil {
+			a.index.add(c)
+		}
+		writeJSON(w, http.StatusOK, c)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// cleanTags 去除空白与重复标签
+func cleanTags(tags []string) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, t := range tags {
+		t = strings.TrimSpace(t)
+		if t != "" && !seen[t] {
+			seen[t] = true
+			out = append(out, t)
+		}
+	}
+	return out
+}
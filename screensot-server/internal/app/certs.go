@@ -0,0 +1,147 @@
+package app
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// GenCerts 实现 gen-certs 子命令：在目录中创建（或复用已有的）本地 CA，并签发服务端与客户端证书。
+//
+//	server gen-certs -dir certs -hosts localhost,127.0.0.1 -clients office-pc,laptop
+func GenCerts(args []string) error {
+	flags := flag.NewFlagSet("gen-certs", flag.ContinueOnError)
+	dir := flags.String("dir", "certs", "输出目录")
+	hosts := flags.String("hosts", "localhost,127.0.0.1", "服务端证书的域名/IP（逗号分隔），客户端连接地址须在其中")
+	clients := flags.String("clients", "", "客户端证书 CN（逗号分隔），与 tcp.clients 登记表的键对应")
+	days := flags.Int("days", 825, "服务端与客户端证书有效期（天）")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(*dir, 0o755); err != nil {
+		return err
+	}
+	ca, caKey, err := loadOrCreateCA(*dir)
+	if err != nil {
+		return err
+	}
+	validFor := time.Duration(*days) * 24 * time.Hour
+	server := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "screensot-server"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, h := range splitCSV(*hosts) {
+		if ip := net.ParseIP(h); ip != nil {
+			server.IPAddresses = append(server.IPAddresses, ip)
+		} else {
+			server.DNSNames = append(server.DNSNames, h)
+		}
+	}
+	if err := issueCert(*dir, "server", server, ca, caKey, validFor); err != nil {
+		return err
+	}
+	for _, name := range splitCSV(*clients) {
+		if strings.ContainsAny(name, `/\`) {
+			return fmt.Errorf("invalid client name %q", name)
+		}
+		client := &x509.Certificate{
+			Subject:     pkix.Name{CommonName: name},
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}
+		if err := issueCert(*dir, "client-"+name, client, ca, caKey, validFor); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("certificates written to %s\n", *dir)
+	return nil
+}
+
+// loadOrCreateCA 复用目录中的 ca.pem / ca-key.pem，不存在时创建有效期 10 年的 CA
+func loadOrCreateCA(dir string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	certPath, keyPath := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca-key.pem")
+	if pair, err := tls.LoadX509KeyPair(certPath, keyPath); err == nil {
+		ca, err := x509.ParseCertificate(pair.Certificate[0])
+		if err != nil {
+			return nil, nil, err
+		}
+		key, ok := pair.PrivateKey.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, nil, fmt.Errorf("%s: unsupported CA key type", keyPath)
+		}
+		fmt.Printf("using existing CA %s\n", certPath)
+		return ca, key, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, fmt.Errorf("load CA: %w", err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          randomSerial(),
+		Subject:               pkix.Name{CommonName: "screensot local CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := writePEM(certPath, keyPath, der, key); err != nil {
+		return nil, nil, err
+	}
+	ca, err := x509.ParseCertificate(der)
+	return ca, key, err
+}
+
+// issueCert 用 CA 签发证书，写入 <name>.pem 与 <name>-key.pem
+func issueCert(dir, name string, tmpl, ca *x509.Certificate, caKey *ecdsa.PrivateKey, validFor time.Duration) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	tmpl.SerialNumber = randomSerial()
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(validFor)
+	tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return fmt.Errorf("issue %s: %w", name, err)
+	}
+	return writePEM(filepath.Join(dir, name+".pem"), filepath.Join(dir, name+"-key.pem"), der, key)
+}
+
+// writePEM 写证书（0644）与私钥（0600）
+func writePEM(certPath, keyPath string, der []byte, key *ecdsa.PrivateKey) error {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		return err
+	}
+	return os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+}
+
+func randomSerial() *big.Int {
+	n, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	return n
+}
@@ -0,0 +1,262 @@
+package app
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ChatMessage 追问对话中的一条消息
+type ChatMessage struct {
+	// user 或 assistant
+	Role    string    `json:"role"`
+	Content string    `json:"content"`
+	Time    time.Time `json:"time"`
+	// assistant 消息的作答模型
+	Model string  `json:"model,omitempty"`
+	Usage *Usage  `json:"usage,omitempty"`
+	Cost  float64 `json:"cost,omitempty"`
+	Error string  `json:"error,omitempty"`
+}
+
+// 追问超时：流式回复整体时长上限
+const chatTimeout = 2 * time.Minute
+
+func chatSystemNote() string {
+	return "首轮回复之后用户可能继续追问（如解释原因、讲解步骤），追问时请用自然语言详细解答，不再要求输出 JSON。"
+}
+
+// chatContext 构造追问上下文：原系统提示词 + 原图与识别提示词 + 该模型（或首个成功模型）的首轮回复 + 历史追问 + 新问题。
+// 失败的历史回复及其问题不计入上下文。
+func chatContext(c Capture, img preparedImage, model, message string) []map[string]interface{} {
+	msgs := []map[string]interface{}{
+		{"role": "system", "content": systemPrompt() + "\n" + chatSystemNote()},
+		{"role": "user", "content": imageContent(promptText(), img)},
+	}
+	if prev := previousReply(c, model); prev != "" {
+		msgs = append(msgs, map[string]interface{}{"role": "assistant", "content": prev})
+	}
+	for i := 0; i+1 < len(c.Thread); i++ {
+		q, r := c.Thread[i], c.Thread[i+1]
+		if q.Role != "user" || r.Role != "assistant" {
+			continue
+		}
+		if r.Error == "" {
+			msgs = append(msgs,
+				map[string]interface{}{"role": "user", "content": q.Content},
+				map[string]interface{}{"role": "assistant", "content": r.Content})
+		}
+		i++
+	}
+	return append(msgs, map[string]interface{}{"role": "user", "content": message})
+}
+
+// previousReply 首轮回复：优先取所选模型的成功答案，否则取首个成功答案
+func previousReply(c Capture, model string) string {
+	first := ""
+	for _, ans := range c.ModelAnswers {
+		if ans.Error != "" || ans.Raw == "" {
+			continue
+		}
+		if ans.Model == model || ans.requestedModel() == model {
+			return ans.Raw
+		}
+		if first == "" {
+			first = ans.Raw
+		}
+	}
+	return first
+}
+
+// chatModels 追问可选的模型：本次作答的模型在前，其后为全局模型列表
+func (a *App) chatModels(c Capture) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, ans := range c.ModelAnswers {
+		if ans.Model != "" && !seen[ans.Model] {
+			seen[ans.Model] = true
+			out = append(out, ans.Model)
+		}
+	}
+	for _, m := range a.conf().Models {
+		if !seen[m] {
+			seen[m] = true
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// ChatPageData 追问页模板数据
+type ChatPageData struct {
+	Capture Capture
+	Base64  string
+	Models  []string
+}
+
+// handleChat 渲染某张截图的追问页
+func (a *App) handleChat(w http.ResponseWriter, r *http.Request) {
+	c, img, status, err := a.loadCapture(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	tmpl, err := template.New("chat").Funcs(templateFuncs).Parse(string(chatTemplate))
+	if err != nil {
+		http.Error(w, "Internal Server Error: unable to parse template", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	data := ChatPageData{Capture: c, Base64: base64.StdEncoding.EncodeToString(img), Models: a.chatModels(c)}
+	if err := tmpl.Execute(w, data); err != nil {
+		http.Error(w, "Internal Server Error: unable to execute template", http.StatusInternalServerError)
+	}
+}
+
+// handleAPIChat GET 返回截图及对话记录；POST {"model","message"} 发送追问，以 SSE 流式返回回复
+// （delta 事件为累计文本，done 事件为完整消息），完成后与问题一并写入对话记录。
+func (a *App) handleAPIChat(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		c, _, status, err := a.loadCapture(r.URL.Query().Get("id"))
+		if err != nil {
+			writeJSONError(w, status, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, c)
+		return
+	case http.MethodPost:
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req struct {
+		Model   string `json:"model"`
+		Message string `json:"message"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	req.Message = strings.TrimSpace(req.Message)
+	if req.Message == "" {
+		writeJSONError(w, http.StatusBadRequest, "message is required")
+		return
+	}
+	c, img, status, err := a.loadCapture(r.URL.Query().Get("id"))
+	if err != nil {
+		writeJSONError(w, status, err.Error())
+		return
+	}
+	if req.Model == "" {
+		if models := a.chatModels(c); len(models) > 0 {
+			req.Model = models[0]
+		} else {
+			writeJSONError(w, http.StatusBadRequest, "model is required")
+			return
+		}
+	}
+	if reason := a.budgetExceeded(); reason != "" {
+		writeJSONError(w, http.StatusTooManyRequests, "预算超出，拒绝追问："+reason)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	clearWriteDeadline(w)
+
+	question := ChatMessage{Role: "user", Content: req.Message, Time: time.Now()}
+	reply := a.chatReply(r.Context(), c, img, req.Model, req.Message, func(text string) {
+		writeChatSSE(w, "delta", map[string]string{"text": text})
+		flusher.Flush()
+	})
+	if _, err := a.captures.update(c.ID, func(c *Capture) { c.Thread = append(c.Thread, question, reply) }); err != nil {
+		reply.Error = fmt.Sprintf("保存对话失败: %v", err)
+	}
+	e := auditEntry{Action: "chat", Clients: []string{c.Client}, Models: []string{req.Model}, Captures: []string{c.ID}, Outcome: auditOK}
+	if reply.Error != "" {
+		e.Outcome, e.Detail = auditError, reply.Error
+	}
+	a.recordAudit(actorFrom(r), e)
+	writeChatSSE(w, "done", reply)
+	flusher.Flush()
+}
+
+// chatReply 经调度器向模型发送追问（始终流式接收），记录用量
+func (a *App) chatReply(ctx context.Context, c Capture, img []byte, model, message string, onDelta func(string)) ChatMessage {
+	reply := ChatMessage{Role: "assistant", Model: model}
+	ctx, cancel := context.WithTimeout(ctx, chatTimeout)
+	defer cancel()
+
+	prepared, err := preprocessImage(base64.StdEncoding.EncodeToString(img), a.conf().preprocessFor(model, nil))
+	if err != nil {
+		reply.Error = fmt.Sprintf("图片预处理失败: %v", err)
+		reply.Time = time.Now()
+		return reply
+	}
+	prepared = a.presignImage(prepared)
+	if a.sched != nil {
+		provider, _ := a.conf().providerFor(model)
+		release, err := a.sched.acquire(ctx, "chat:"+c.ID, provider, nil)
+		if err != nil {
+			reply.Error = fmt.Sprintf("排队等待超时: %v", err)
+			reply.Time = time.Now()
+			return reply
+		}
+		defer func() {
+			used := 0
+			if reply.Usage != nil {
+				used = reply.Usage.PromptTokens + reply.Usage.CompletionTokens
+			}
+			release(used)
+		}()
+	}
+	content, usage, err := a.chatCompletion(ctx, model, chatContext(c, prepared, model, message), true, onDelta)
+	reply.Content = strings.TrimSpace(content)
+	reply.Time = time.Now()
+	if usage != nil {
+		reply.Usage = usage
+		reply.Cost = a.conf().ModelOptions[model].cost(*usage)
+		a.recordUsage(c.RequestID, c.Client, ModelAnswer{Model: model, Usage: usage, Cost: reply.Cost})
+	}
+	if err != nil {
+		reply.Error = err.Error()
+	}
+	return reply
+}
+
+// loadCapture 读取截图元数据与原图；失败时返回对应的 HTTP 状态码
+func (a *App) loadCapture(id string) (Capture, []byte, int, error) {
+	if a.captures == nil {
+		return Capture{}, nil, http.StatusNotFound, errors.New("capture storage disabled")
+	}
+	c, err := a.captures.get(id)
+	if err == nil {
+		var img []byte
+		if img, err = a.captures.image(id); err == nil {
+			return c, img, http.StatusOK, nil
+		}
+	}
+	if errors.Is(err, errBlobNotFound) {
+		return Capture{}, nil, http.StatusNotFound, errors.New("capture not found")
+	}
+	return Capture{}, nil, http.StatusInternalServerError, err
+}
+
+// writeChatSSE 写出一条追问 SSE 事件
+func writeChatSSE(w http.ResponseWriter, event string, v interface{}) {
+	b, _ := json.Marshal(v)
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b)
+}
@@ -0,0 +1,110 @@
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCaptureStore(t *testing.T) {
+	s := newCaptureStore(StorageConfig{Backend: "local", Dir: t.TempDir()})
+	c := Capture{ID: newID(), Client: "c", ModelAnswers: []ModelAnswer{{Model: "m", Answer: "B"}}}
+	if err := s.save(c, "aW1n"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.get(c.ID)
+	if err != nil || got.ModelAnswers[0].Answer != "B" {
+		t.Fatalf("get = %+v, %v", got, err)
+	}
+	if img, err := s.image(c.ID); err != nil || string(img) != "img" {
+		t.Fatalf("image = %q, %v", img, err)
+	}
+	if _, err := s.update(c.ID, func(c *Capture) { c.Thread = append(c.Thread, ChatMessage{Role: "user", Content: "why"}) }); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.get(c.ID); len(got.Thread) != 1 {
+		t.Fatalf("thread = %+v", got.Thread)
+	}
+	if _, err := s.get("../../etc/passwd"); err != errBlobNotFound {
+		t.Fatalf("invalid id err = %v", err)
+	}
+	if newCaptureStore(StorageConfig{Backend: "off"}) != nil {
+		t.Fatal("off backend should disable storage")
+	}
+}
+
+func TestChatFollowUp(t *testing.T) {
+	var lastMessages []map[string]interface{}
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Messages []map[string]interface{} `json:"messages"`
+			Stream   bool                     `json:"stream"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		lastMessages = req.Messages
+		if !req.Stream {
+			http.Error(w, "chat must stream", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"因为 \"}}]}\n\n")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"2 不是奇数\"}}],\"usage\":{\"prompt_tokens\":50,\"completion_tokens\":5}}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer up.Close()
+
+	a := &App{
+		cfg:      Config{Models: []string{"m"}, SiliconflowBaseURL: up.URL, SiliconflowAPIKey: "k"},
+		captures: newCaptureStore(StorageConfig{Backend: "local", Dir: t.TempDir()}),
+	}
+	items := []ImageEntry{{Client: "c", Base64: "aW1n", ModelAnswers: []ModelAnswer{{Model: "m", Question: "q", Answer: "A", Raw: `{"question":"q","answer":"A"}`}}}}
+	a.saveCaptures(items, analyzeOptions{requestID: "r1"})
+	id := items[0].ID
+	if id == "" {
+		t.Fatal("capture should be saved")
+	}
+
+	post := func(msg string) string {
+		w := httptest.NewRecorder()
+		a.handleAPIChat(w, httptest.NewRequest(http.MethodPost, "/api/v1/chat?id="+id, strings.NewReader(`{"message":"`+msg+`"}`)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", w.Code, w.Body.String())
+		}
+		var done string
+		sc := bufio.NewScanner(w.Body)
+		for sc.Scan() {
+			if strings.HasPrefix(sc.Text(), "data: ") {
+				done = strings.TrimPrefix(sc.Text(), "data: ")
+			}
+		}
+		return done
+	}
+	out := post("为什么 B 不对")
+	var reply ChatMessage
+	if err := json.Unmarshal([]byte(out), &reply); err != nil || reply.Content != "因为 2 不是奇数" || reply.Model != "m" {
+		t.Fatalf("reply = %s (%v)", out, err)
+	}
+	// 上下文：system、原图、首轮回复、追问
+	if len(lastMessages) != 4 || lastMessages[2]["role"] != "assistant" || lastMessages[2]["content"] != `{"question":"q","answer":"A"}` {
+		t.Fatalf("messages = %+v", lastMessages)
+	}
+
+	post("再讲一遍")
+	if len(lastMessages) != 6 || lastMessages[4]["content"] != "因为 2 不是奇数" {
+		t.Fatalf("second round messages = %+v", lastMessages)
+	}
+	c, err := a.captures.get(id)
+	if err != nil || len(c.Thread) != 4 || c.Thread[3].Usage == nil {
+		t.Fatalf("thread = %+v, %v", c.Thread, err)
+	}
+
+	w := httptest.NewRecorder()
+	a.handleChat(w, httptest.NewRequest(http.MethodGet, "/chat?id="+id, nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "再讲一遍") {
+		t.Fatalf("chat page: %d\n%s", w.Code, w.Body.String())
+	}
+}
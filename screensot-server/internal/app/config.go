@@ -1,10 +1,16 @@
 package app
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 )
 
@@ -18,6 +24,117 @@ type Config struct {
 	SiliconflowAPIKey string `json:"siliconflow_api_key"`
 	// HTML 模板路径
 	TemplatePath string `json:"template_path"`
+	// 以 SSE 流式接收模型输出，并实时推送到结果页
+	Stream bool `json:"stream"`
+	// 识别结果缓存
+	Cache CacheConfig `json:"cache"`
+	// 全局图片预处理（发送给模型前），可被 profile 与 model_options 覆盖
+	Preprocess *PreprocessConfig `json:"preprocess"`
+	// 按模型名配置的专属选项
+	ModelOptions map[string]ModelOptions `json:"model_options"`
+	// 命名识别方案，通过 /one?profile=name 选择
+	Profiles map[string]Profile `json:"profiles"`
+	// 数据目录（用量账本等），相对路径相对于 config.json 所在目录，默认 data
+	DataDir string `json:"data_dir"`
+	// 费用/用量预算
+	Budget BudgetConfig `json:"budget"`
+	// OpenAI 兼容的模型提供方，模型通过 model_options.provider 关联；
+	// 未关联的模型使用 siliconflow（缺省取 siliconflow_base_url / siliconflow_api_key）
+	Providers map[string]ProviderConfig `json:"providers"`
+	// 全局上游调用调度
+	Scheduler SchedulerConfig `json:"scheduler"`
+	// 回退链：主模型 -> 依次尝试的备用模型，主模型报错、超时或输出无法解析时启用
+	Fallbacks map[string][]string `json:"fallbacks"`
+	// 两阶段识别（先转写再推理）
+	Pipeline PipelineConfig `json:"pipeline"`
+	// 超大/超宽截图的分块识别
+	Tiling TilingConfig `json:"tiling"`
+	// 截图与识别结果的持久化存储
+	Storage StorageConfig `json:"storage"`
+	// 裁判模型核验
+	Verify VerifyConfig `json:"verify"`
+	// 本地题库（data_dir/bank.json）
+	Bank BankConfig `json:"bank"`
+	// 截屏通道 TLS 与客户端登记
+	TCP TCPConfig `json:"tcp"`
+	// HTTP 页面与接口的登录认证与角色
+	HTTPAuth HTTPAuthConfig `json:"http_auth"`
+	// 监听地址、HTTP 超时、请求体上限与优雅关闭
+	Server ServerConfig `json:"server"`
+	// 日志级别与格式
+	Log LogConfig `json:"log"`
+
+	// 实际读取的配置文件路径
+	path string
+}
+
+// 未在 model_options 指定 provider 的模型所用的提供方
+const defaultProvider = "siliconflow"
+
+// ProviderConfig OpenAI 兼容的模型提供方及其限流；限流字段为 0 表示不限
+type ProviderConfig struct {
+	BaseURL string `json:"base_url"`
+	// API Key；本地模型等无需鉴权的提供方可留空
+	APIKey string `json:"api_key"`
+	// 同时进行的上游调用上限
+	MaxConcurrency int `json:"max_concurrency"`
+	// 每分钟请求数 / token 数上限
+	RPM int `json:"rpm"`
+	TPM int `json:"tpm"`
+}
+
+// providerFor 解析模型所属的提供方名称与配置
+func (c Config) providerFor(model string) (string, ProviderConfig) {
+	name := c.ModelOptions[model].Provider
+	if name == "" {
+		name = defaultProvider
+	}
+	p := c.Providers[name]
+	if name == defaultProvider {
+		if p.BaseURL == "" {
+			p.BaseURL = c.SiliconflowBaseURL
+		}
+		if p.APIKey == "" {
+			p.APIKey = c.SiliconflowAPIKey
+		}
+	}
+	return name, p
+}
+
+// ModelOptions 单个模型的专属配置
+type ModelOptions struct {
+	// 所属提供方（providers 中的名称），默认 siliconflow
+	Provider string `json:"provider"`
+	// 发送给该模型前的图片预处理，优先于 profile 与全局配置
+	Preprocess *PreprocessConfig `json:"preprocess"`
+	// 单价：每百万 prompt / completion token 的费用（币种自定，与 budget 一致即可）
+	PromptPrice     float64 `json:"prompt_price"`
+	CompletionPrice float64 `json:"completion_price"`
+}
+
+// Profile 命名的识别方案
+type Profile struct {
+	// 覆盖模型列表；为空时沿用全局 models
+	Models []string `json:"models"`
+	// 图片预处理，优先于全局配置
+	Preprocess *PreprocessConfig `json:"preprocess"`
+	// 两阶段识别配置，整体覆盖全局 pipeline
+	Pipeline *PipelineConfig `json:"pipeline"`
+	// 分块识别配置，整体覆盖全局 tiling
+	Tiling *TilingConfig `json:"tiling"`
+	// 核验配置，整体覆盖全局 verify
+	Verify *VerifyConfig `json:"verify"`
+}
+
+// preprocessFor 解析某模型实际使用的预处理：model_options > profile > 全局
+func (c Config) preprocessFor(model string, p *Profile) *PreprocessConfig {
+	if mo, ok := c.ModelOptions[model]; ok && mo.Preprocess != nil {
+		return mo.Preprocess
+	}
+	if p != nil && p.Preprocess != nil {
+		return p.Preprocess
+	}
+	return c.Preprocess
 }
 
 func defaultConfig() Config {
@@ -25,22 +142,61 @@ func defaultConfig() Config {
 		Models:             []string{"Qwen/Qwen3-VL-32B-Instruct"},
 		SiliconflowBaseURL: "https://api.siliconflow.cn",
 		TemplatePath:       "web/result.html",
+		DataDir:            "data",
 	}
 }
 
+// loadConfigFile 严格解析配置文件：拒绝未知字段与多余内容，错误信息带行列号
 func loadConfigFile(path string) (Config, error) {
-	f, err := os.Open(path)
+	data, err := os.ReadFile(path)
 	if err != nil {
 		return Config{}, err
 	}
-	defer f.Close()
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
 	var c Config
-	if err := json.NewDecoder(f).Decode(&c); err != nil {
-		return Config{}, err
+	if err := dec.Decode(&c); err != nil {
+		return Config{}, configSyntaxError(path, data, dec.InputOffset(), err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		line, col := lineCol(data, dec.InputOffset())
+		return Config{}, fmt.Errorf("%s:%d:%d: unexpected content after the top-level object", path, line, col)
 	}
 	return c, nil
 }
 
+// configSyntaxError 为 JSON 解析错误补上文件位置（行:列）
+func configSyntaxError(path string, data []byte, offset int64, err error) error {
+	var syn *json.SyntaxError
+	var typ *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syn):
+		// Offset 为已读取的字节数，出错字符是其中最后一个
+		offset = syn.Offset - 1
+	case errors.As(err, &typ):
+		offset = typ.Offset
+		err = fmt.Errorf("%s: expected %s, got JSON %s", typ.Field, typ.Type, typ.Value)
+	case errors.Is(err, io.EOF):
+		return fmt.Errorf("%s: empty file", path)
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// 定位到该键首次出现处
+		key := strings.TrimPrefix(err.Error(), "json: unknown field ")
+		if m := regexp.MustCompile(regexp.QuoteMeta(key) + `\s*:`).FindIndex(data); m != nil {
+			offset = int64(m[0])
+		}
+	}
+	line, col := lineCol(data, offset)
+	return fmt.Errorf("%s:%d:%d: %v", path, line, col, strings.TrimPrefix(err.Error(), "json: "))
+}
+
+func lineCol(data []byte, offset int64) (line, col int) {
+	offset = min(max(offset, 0), int64(len(data)))
+	before := data[:offset]
+	line = bytes.Count(before, []byte("\n")) + 1
+	col = int(offset) - bytes.LastIndexByte(before, '\n')
+	return line, col
+}
+
 // mergeEnv 覆盖来自环境变量的配置
 func mergeEnv(c Config) Config {
 	if env := strings.TrimSpace(os.Getenv("VISION_MODELS")); env != "" {
@@ -52,43 +208,201 @@ func mergeEnv(c Config) Config {
 	if env := strings.TrimSpace(os.Getenv("TEMPLATE_PATH")); env != "" {
 		c.TemplatePath = env
 	}
+	if env := strings.TrimSpace(os.Getenv("VISION_STREAM")); env != "" {
+		c.Stream = env == "1" || strings.EqualFold(env, "true")
+	}
+	if env := strings.TrimSpace(os.Getenv("LOG_LEVEL")); env != "" {
+		c.Log.Level = env
+	}
+	if env := strings.TrimSpace(os.Getenv("LOG_FORMAT")); env != "" {
+		c.Log.Format = env
+	}
 	return c
 }
 
-// loadConfig 先读取 JSON 文件（默认 ./config.json，可用 SERVER_CONFIG 指定），再用环境变量覆盖
-func loadConfig() Config {
+// loadConfig 启动时加载配置（默认 ./config.json，可用 SERVER_CONFIG 指定）并按其设置日志
+func loadConfig() (Config, error) {
 	path := resolveConfigPath()
+	c, err := readConfig(path)
+	if err != nil {
+		return Config{}, err
+	}
+	// 启动日志：打印实际使用的配置路径与关键项（API Key 打码）
+	setupLogging(c.Log)
+	slog.Info("using config", "path", path, "models", c.Models, "base_url", c.SiliconflowBaseURL, "key", maskSecret(c.SiliconflowAPIKey), "template", c.TemplatePath, "stream", c.Stream, "cache", c.Cache.Backend)
+	return c, nil
+}
+
+// readConfig 读取并校验配置：默认值 <- JSON 文件 <- 环境变量；文件不存在时只用默认值与环境变量
+func readConfig(path string) (Config, error) {
 	c := defaultConfig()
 	if b, err := os.Stat(path); err == nil && !b.IsDir() {
-		if fileCfg, err2 := loadConfigFile(path); err2 == nil {
-			// 合并：文件覆盖默认
-			if len(fileCfg.Models) > 0 {
-				c.Models = fileCfg.Models
-			}
-			if fileCfg.SiliconflowBaseURL != "" {
-				c.SiliconflowBaseURL = fileCfg.SiliconflowBaseURL
-			}
-			if fileCfg.SiliconflowAPIKey != "" {
-				c.SiliconflowAPIKey = fileCfg.SiliconflowAPIKey
-			}
-			if fileCfg.TemplatePath != "" {
-				c.TemplatePath = fileCfg.TemplatePath
-			}
-		} else {
-			fmt.Fprintf(os.Stderr, "warn: read config file failed: %v\n", err2)
+		fileCfg, err := loadConfigFile(path)
+		if err != nil {
+			return Config{}, err
+		}
+		// 合并：文件覆盖默认
+		if len(fileCfg.Models) > 0 {
+			c.Models = fileCfg.Models
 		}
+		if fileCfg.SiliconflowBaseURL != "" {
+			c.SiliconflowBaseURL = fileCfg.SiliconflowBaseURL
+		}
+		if fileCfg.SiliconflowAPIKey != "" {
+			c.SiliconflowAPIKey = fileCfg.SiliconflowAPIKey
+		}
+		if fileCfg.TemplatePath != "" {
+			c.TemplatePath = fileCfg.TemplatePath
+		}
+		c.Stream = fileCfg.Stream
+		c.Cache = fileCfg.Cache
+		c.Preprocess = fileCfg.Preprocess
+		c.ModelOptions = fileCfg.ModelOptions
+		c.Profiles = fileCfg.Profiles
+		if fileCfg.DataDir != "" {
+			c.DataDir = fileCfg.DataDir
+		}
+		c.Budget = fileCfg.Budget
+		c.Providers = fileCfg.Providers
+		c.Scheduler = fileCfg.Scheduler
+		c.Fallbacks = fileCfg.Fallbacks
+		c.Pipeline = fileCfg.Pipeline
+		c.Tiling = fileCfg.Tiling
+		c.Storage = fileCfg.Storage
+		c.Verify = fileCfg.Verify
+		c.Bank = fileCfg.Bank
+		c.TCP = fileCfg.TCP
+		c.HTTPAuth = fileCfg.HTTPAuth
+		c.Server = fileCfg.Server
+		c.Log = fileCfg.Log
 	}
 	c = mergeEnv(c)
 	// 若模板为相对路径，则相对于配置文件所在目录进行解析，便于二进制在仓库根或其他目录运行
 	if !filepath.IsAbs(c.TemplatePath) {
 		c.TemplatePath = filepath.Join(filepath.Dir(path), c.TemplatePath)
 	}
-	// 启动日志：打印实际使用的配置路径与关键项（API Key 打码）
-	masked := c.SiliconflowAPIKey
-	if len(masked) > 8 {
-		masked = masked[:4] + "***" + masked[len(masked)-3:]
+	if !filepath.IsAbs(c.DataDir) {
+		c.DataDir = filepath.Join(filepath.Dir(path), c.DataDir)
+	}
+	if c.Cache.Dir == "" {
+		c.Cache.Dir = "cache"
+	}
+	if !filepath.IsAbs(c.Cache.Dir) {
+		c.Cache.Dir = filepath.Join(filepath.Dir(path), c.Cache.Dir)
+	}
+	// 开启保存却未配置任何保留限制时默认保留 30 天，避免磁盘无限增长；max_age_days 设为负数表示永久保留
+	if r := &c.Storage.Retention; !c.Storage.storageOff() && r.MaxAgeDays == 0 && r.MaxTotalMB == 0 && r.MaxPerClient == 0 && r.MaxPerSession == 0 {
+		r.MaxAgeDays = defaultRetentionDays
+	}
+	if c.Storage.Dir == "" {
+		c.Storage.Dir = filepath.Join(c.DataDir, "captures")
+	} else if !filepath.IsAbs(c.Storage.Dir) {
+		c.Storage.Dir = filepath.Join(filepath.Dir(path), c.Storage.Dir)
+	}
+	if c.HTTPAuth.UsersFile == "" {
+		c.HTTPAuth.UsersFile = filepath.Join(c.DataDir, "users.json")
+	}
+	for _, p := range []*string{&c.HTTPAuth.UsersFile, &c.TCP.TLS.CertFile, &c.TCP.TLS.KeyFile, &c.TCP.TLS.ClientCAFile} {
+		if *p != "" && !filepath.IsAbs(*p) {
+			*p = filepath.Join(filepath.Dir(path), *p)
+		}
+	}
+	// unix socket 的相对路径同样相对于 config.json 所在目录
+	for _, p := range []*string{&c.Server.HTTPAddr, &c.Server.TCPAddr} {
+		if sock, ok := strings.CutPrefix(*p, "unix:"); ok && !filepath.IsAbs(sock) {
+			*p = "unix:" + filepath.Join(filepath.Dir(path), sock)
+		}
+	}
+	c.path = path
+	if err := c.validate(); err != nil {
+		return Config{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+// validate 检查取值与相互引用，一次返回全部问题
+func (c Config) validate() error {
+	var problems []string
+	bad := func(format string, args ...interface{}) { problems = append(problems, fmt.Sprintf(format, args...)) }
+	oneOf := func(field, v string, allowed ...string) {
+		for _, s := range allowed {
+			if strings.EqualFold(strings.TrimSpace(v), s) {
+				return
+			}
+		}
+		bad("%s: unknown value %q (allowed: %s)", field, v, strings.Join(allowed[1:], ", "))
+	}
+	if len(c.Models) == 0 {
+		bad("models: at least one model is required")
+	}
+	for name, p := range c.Providers {
+		if strings.TrimSpace(p.BaseURL) == "" && name != defaultProvider {
+			bad("providers.%s.base_url: required", name)
+		}
+	}
+	for model, mo := range c.ModelOptions {
+		if _, ok := c.Providers[mo.Provider]; mo.Provider != "" && mo.Provider != defaultProvider && !ok {
+			bad("model_options.%s.provider: %q is not defined in providers", model, mo.Provider)
+		}
+	}
+	oneOf("storage.backend", c.Storage.Backend, "", "local", "s3", "qiniu", "kodo", "off", "none")
+	oneOf("cache.backend", c.Cache.Backend, "", "memory", "disk", "off", "none")
+	oneOf("bank.mode", c.Bank.Mode, "", "alongside", "instead")
+	oneOf("log.format", c.Log.Format, "", "text", "json")
+	var level slog.Level
+	if c.Log.Level != "" && level.UnmarshalText([]byte(strings.TrimSpace(c.Log.Level))) != nil {
+		bad("log.level: unknown value %q (allowed: debug, info, warn, error)", c.Log.Level)
+	}
+	if c.Bank.Threshold < 0 || c.Bank.Threshold > 1 {
+		bad("bank.threshold: must be between 0 and 1, got %v", c.Bank.Threshold)
+	}
+	if c.TCP.TLS.Enabled && (c.TCP.TLS.CertFile == "" || c.TCP.TLS.KeyFile == "") {
+		bad("tcp.tls: cert_file and key_file are required when enabled")
+	}
+	// 外部模板存在时须能解析；不存在时使用内置模板
+	if b, err := os.ReadFile(c.TemplatePath); err == nil && len(b) > 0 {
+		if _, err := template.New("result").Funcs(templateFuncs).Parse(string(b)); err != nil {
+			bad("template_path: %v", err)
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid config:\n  - %s", strings.Join(problems, "\n  - "))
+}
+
+// maskSecret 密钥只保留首尾少量字符；过短的整体打码
+func maskSecret(s string) string {
+	if s == "" {
+		return ""
+	}
+	if len(s) > 8 {
+		return s[:4] + "***" + s[len(s)-3:]
+	}
+	return "***"
+}
+
+// masked 返回密钥打码后的配置副本，供诊断页展示
+func (c Config) masked() Config {
+	c.SiliconflowAPIKey = maskSecret(c.SiliconflowAPIKey)
+	if c.Providers != nil {
+		providers := make(map[string]ProviderConfig, len(c.Providers))
+		for name, p := range c.Providers {
+			p.APIKey = maskSecret(p.APIKey)
+			providers[name] = p
+		}
+		c.Providers = providers
+	}
+	c.Storage.AccessKey = maskSecret(c.Storage.AccessKey)
+	c.Storage.SecretKey = maskSecret(c.Storage.SecretKey)
+	if c.TCP.Auth.Tokens != nil {
+		tokens := make([]ClientToken, len(c.TCP.Auth.Tokens))
+		for i, t := range c.TCP.Auth.Tokens {
+			t.Hash = maskSecret(t.Hash)
+			tokens[i] = t
+		}
+		c.TCP.Auth.Tokens = tokens
 	}
-	fmt.Fprintf(os.Stderr, "using config: %s\nmodels=%v baseURL=%s key=%s template=%s\n", path, c.Models, c.SiliconflowBaseURL, masked, c.TemplatePath)
 	return c
 }
 
@@ -0,0 +1,184 @@
+package app
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Consensus 汇总同一张图片下多个模型答案的一致性
+type Consensus struct {
+	// 多数答案（取票数最多一组的首个原文）；并列第一时为空
+	Majority string `json:"majority"`
+	// 多数组占有效答案的比例（0~1）
+	Agreement float64 `json:"agreement"`
+	// 参与比较的有效答案数（排除报错与空答案）
+	Valid int `json:"valid"`
+	// 存在两组及以上不同答案
+	Disagreement bool `json:"disagreement"`
+	// 按票数降序的答案分组
+	Groups []AnswerGroup `json:"groups"`
+}
+
+// AnswerGroup 归一化后等价的一组答案
+type AnswerGroup struct {
+	Key    string   `json:"key"`
+	Answer string   `json:"answer"`
+	Models []string `json:"models"`
+}
+
+// buildConsensus 归一化各模型答案并分组，计算一致度与多数答案。
+func buildConsensus(answers []ModelAnswer) *Consensus {
+	var groups []AnswerGroup
+	index := map[string]int{}
+	valid := 0
+	for _, ma := range answers {
+		if ma.Error != "" {
+			continue
+		}
+		key := normalizeAnswer(ma.Answer)
+		if key == "" {
+			continue
+		}
+		valid++
+		if i, ok := index[key]; ok {
+			groups[i].Models = append(groups[i].Models, ma.Model)
+			continue
+		}
+		index[key] = len(groups)
+		groups = append(groups, AnswerGroup{Key: key, Answer: strings.TrimSpace(ma.Answer), Models: []string{ma.Model}})
+	}
+	if valid == 0 {
+		return nil
+	}
+	// 票数降序，同票保持出现顺序
+	sort.SliceStable(groups, func(i, j int) bool { return len(groups[i].Models) > len(groups[j].Models) })
+
+	c := &Consensus{
+		Agreement:    float64(len(groups[0].Models)) / float64(valid),
+		Valid:        valid,
+		Disagreement: len(groups) > 1,
+		Groups:       groups,
+	}
+	if len(groups) == 1 || len(groups[0].Models) > len(groups[1].Models) {
+		c.Majority = groups[0].Answer
+	}
+	return c
+}
+
+// 常见答案前缀，比较前去除
+var answerPrefixes = []string{"正确答案", "参考答案", "答案", "选项", "答", "选", "answer"}
+
+// normalizeAnswer 将答案归一化为可比较的 key：
+// 选项字母 -> "opt:AC"；数字 -> "num:3.5"；其余文本去空白与尾部标点 -> "txt:..."。
+func normalizeAnswer(s string) string {
+	s = strings.TrimSpace(foldWidth(s))
+	lower := strings.ToLower(s)
+	for _, p := range answerPrefixes {
+		if strings.HasPrefix(lower, p) {
+			s = strings.TrimLeft(s[len(p):], " :：是为")
+			break
+		}
+	}
+	s = strings.TrimRightFunc(s, func(r rune) bool { return unicode.IsPunct(r) || unicode.IsSpace(r) })
+	s = strings.TrimLeft(s, "(（[【 ")
+	if s == "" {
+		return ""
+	}
+	if opts, ok := optionLetters(s); ok {
+		return "opt:" + opts
+	}
+	num := s
+	if thousandsSep.MatchString(s) {
+		num = strings.ReplaceAll(s, ",", "")
+	}
+	if n, err := strconv.ParseFloat(num, 64); err == nil {
+		return "num:" + strconv.FormatFloat(n, 'g', -1, 64)
+	}
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if !unicode.IsSpace(r) {
+			b.WriteRune(r)
+		}
+	}
+	return "txt:" + b.String()
+}
+
+// optionLetters 识别 "B"、"A、C"、"A,C"、"AC"、"B. 北京" 等选项写法，返回排序去重后的字母。
+func optionLetters(s string) (string, bool) {
+	rs := []rune(s)
+	if out, ok := pureOptionLetters(rs); ok {
+		return out, true
+	}
+	// "B. 北京" / "B）xxx"：单个选项字母后跟分隔符与选项内容
+	if len(rs) > 2 && isOptionRune(unicode.ToUpper(rs[0])) && strings.ContainsRune(".．、)）:：", rs[1]) {
+		return string(unicode.ToUpper(rs[0])), true
+	}
+	return "", false
+}
+
+// pureOptionLetters 整串仅由选项字母与分隔符组成、且每个字母至多出现一次时返回排序去重后的字母
+// （"A、C"、"A C"、"ACD"）。连写的片段若是由 A~H 组成的英文单词（"BAD"、"ACE"、"CAFE"）则不视为选项
+func pureOptionLetters(rs []rune) (string, bool) {
+	seen := map[rune]bool{}
+	letters := 0
+	var run []rune
+	isWord := func() bool {
+		w := optionWords[strings.ToLower(string(run))]
+		run = run[:0]
+		return w
+	}
+	for _, r := range rs {
+		switch {
+		case strings.ContainsRune(" ,，、/和及;；", r):
+			if isWord() {
+				return "", false
+			}
+			continue
+		case isOptionRune(r) || (len(rs) == 1 && isOptionRune(unicode.ToUpper(r))):
+			seen[unicode.ToUpper(r)] = true
+			letters++
+			run = append(run, r)
+		default:
+			return "", false
+		}
+	}
+	if isWord() || letters == 0 || letters != len(seen) {
+		return "", false
+	}
+	out := make([]rune, 0, len(seen))
+	for r := range seen {
+		out = append(out, r)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return string(out), true
+}
+
+func isOptionRune(r rune) bool { return r >= 'A' && r <= 'H' }
+
+// optionWords 仅由 A~H 组成且无重复字母的常见英文单词，连写时按文本而非选项组合比较
+var optionWords = func() map[string]bool {
+	m := map[string]bool{}
+	for _, w := range strings.Fields("ace aged bad bade badge bag bead bed beg cab cad cafe chef dab each ache face fad fade fed gab had head hag bach beach chafe") {
+		m[w] = true
+	}
+	return m
+}()
+
+// thousandsSep 千分位写法（"1,000"、"12,345.6"）；其他逗号（如 "1,2"）视为答案的一部分予以保留
+var thousandsSep = regexp.MustCompile(`^[-+]?\d{1,3}(,\d{3})+(\.\d+)?$`)
+
+// foldWidth 全角 ASCII 与全角空格转半角
+func foldWidth(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '　':
+			return ' '
+		case r >= '！' && r <= '～':
+			return r - 0xFEE0
+		}
+		return r
+	}, s)
+}
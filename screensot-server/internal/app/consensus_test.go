@@ -0,0 +1,71 @@
+package app
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeAnswer(t *testing.T) {
+	cases := []struct{ a, b string }{
+		{"B", "答案：B"},
+		{"B", "b"},
+		{"B", "Ｂ．"},
+		{"B", "B. 北京"},
+		{"A、C", "CA"},
+		{"A, C", "答案是 A C"},
+		{"3.50", "3.5"},
+		{"1,000", "1000"},
+		{"12,345.5", "12345.50"},
+		{"A、B、C、D", "D,C,B,A"},
+		{"ABD", "A、B、D"},
+		{"答案：ABD", "A,B,D"},
+		{"ABCD", "A B C D"},
+		{"光合 作用。", "光合作用"},
+		{"(B)", "B"},
+	}
+	for _, c := range cases {
+		if x, y := normalizeAnswer(c.a), normalizeAnswer(c.b); x != y {
+			t.Errorf("normalize(%q)=%q, normalize(%q)=%q", c.a, x, c.b, y)
+		}
+	}
+	if normalizeAnswer("B") == normalizeAnswer("C") {
+		t.Fatal("B and C must differ")
+	}
+	// 大小写单词都不是选项组合，字母相同顺序不同的单词不能视为一致
+	for _, w := range []string{"bad", "BAD", "ACE", "CAFE"} {
+		if got := normalizeAnswer(w); !strings.HasPrefix(got, "txt:") {
+			t.Errorf("normalize(%q) = %q, want text", w, got)
+		}
+	}
+	for _, c := range []struct{ a, b string }{{"BAD", "DAB"}, {"ACE", "A、C、E"}, {"CAFE", "A C E F"}, {"1,2", "12"}, {"1,2", "2,1"}} {
+		if x, y := normalizeAnswer(c.a), normalizeAnswer(c.b); x == y {
+			t.Errorf("normalize(%q) = normalize(%q) = %q", c.a, c.b, x)
+		}
+	}
+}
+
+func TestBuildConsensus(t *testing.T) {
+	got := buildConsensus([]ModelAnswer{
+		{Model: "m1", Answer: "B"},
+		{Model: "m2", Answer: "答案：b。"},
+		{Model: "m3", Answer: "C"},
+		{Model: "m4", Error: "HTTP 500"},
+	})
+	if got == nil {
+		t.Fatal("nil consensus")
+	}
+	if got.Valid != 3 || !got.Disagreement || got.Majority != "B" || len(got.Groups) != 2 {
+		t.Fatalf("unexpected consensus: %+v", got)
+	}
+	if got.Agreement < 0.66 || got.Agreement > 0.67 {
+		t.Fatalf("agreement = %v", got.Agreement)
+	}
+
+	tie := buildConsensus([]ModelAnswer{{Model: "m1", Answer: "A"}, {Model: "m2", Answer: "B"}})
+	if tie.Majority != "" || !tie.Disagreement {
+		t.Fatalf("tie: %+v", tie)
+	}
+	if buildConsensus([]ModelAnswer{{Model: "m1", Error: "x"}}) != nil {
+		t.Fatal("expected nil without valid answers")
+	}
+}
@@ -6,3 +6,33 @@ import _ "embed"
 //
 //go:embed templates/result_default.html
 var defaultTemplate []byte
+
+// 用量统计页面模板
+//
+//go:embed templates/usage.html
+var usageTemplate []byte
+
+// 追问页模板
+//
+//go:embed templates/chat.html
+var chatTemplate []byte
+
+// 历史搜索页模板
+//
+//go:embed templates/search.html
+var searchTemplate []byte
+
+// 登录页模板
+//
+//go:embed templates/login.html
+var loginTemplate []byte
+
+// 审计日志页模板
+//
+//go:embed templates/audit.html
+var auditTemplate []byte
+
+// 诊断页模板
+//
+//go:embed templates/status.html
+var statusTemplate []byte
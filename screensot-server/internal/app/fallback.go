@@ -0,0 +1,90 @@
+package app
+
+import (
+	"context"
+	"slices"
+)
+
+// FallbackAttempt 回退链中被跳过的一次尝试
+type FallbackAttempt struct {
+	Model  string `json:"model"`
+	Reason string `json:"reason"`
+}
+
+// fallbackChain 返回以 model 为首的回退链（去重，含自身）
+func (c Config) fallbackChain(model string) []string {
+	chain := []string{model}
+	seen := map[string]bool{model: true}
+	for _, m := range c.Fallbacks[model] {
+		if m != "" && !seen[m] {
+			seen[m] = true
+			chain = append(chain, m)
+		}
+	}
+	return chain
+}
+
+// requestedModel 回退链首个模型；未发生回退时即 Model
+func (m ModelAnswer) requestedModel() string {
+	if m.Requested != "" {
+		return m.Requested
+	}
+	return m.Model
+}
+
+// callWithFallback 依次尝试回退链中的模型，直到得到成功且可解析的答案。
+// 链上最后一个模型的结果无论成败都会返回；每次实际调用都计入用量。
+func (a *App) callWithFallback(ctx context.Context, vr visionRequest, opts analyzeOptions, client string) ModelAnswer {
+	chain := a.conf().fallbackChain(vr.model)
+	if len(opts.downgrade) > 0 {
+		chain = restrictChain(chain, opts.downgrade)
+	}
+	var skipped []FallbackAttempt
+	var ans ModelAnswer
+	for i, m := range chain {
+		attempt := vr
+		attempt.model = m
+		attempt.pre = a.conf().preprocessFor(m, opts.profile)
+		if attempt.tiled && attempt.pre != nil && attempt.pre.Crop != nil {
+			pre := *attempt.pre
+			pre.Crop = nil
+			attempt.pre = &pre
+		}
+		ans = a.callVisionCached(ctx, attempt, opts.noCache)
+		if !ans.Cached {
+			a.recordUsage(opts.requestID, client, ans)
+		}
+		reason := fallbackReason(ans)
+		if reason == "" || i == len(chain)-1 || ctx.Err() != nil {
+			break
+		}
+		skipped = append(skipped, FallbackAttempt{Model: m, Reason: reason})
+	}
+	if len(skipped) > 0 {
+		ans.Requested = vr.model
+		ans.Skipped = skipped
+	}
+	return ans
+}
+
+// restrictChain 预算降级时只保留允许的模型；链首为请求的模型，始终保留
+func restrictChain(chain, allowed []string) []string {
+	out := chain[:1:1]
+	for _, m := range chain[1:] {
+		if slices.Contains(allowed, m) {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// fallbackReason 需要回退时返回原因，否则为空
+func fallbackReason(ans ModelAnswer) string {
+	switch {
+	case ans.Error != "":
+		return ans.Error
+	case ans.Unparsed:
+		return "输出无法解析为 JSON 题目/答案"
+	}
+	return ""
+}
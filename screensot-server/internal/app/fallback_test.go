@@ -0,0 +1,55 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCallWithFallback(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model string `json:"model"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		switch req.Model {
+		case "broken":
+			http.Error(w, "upstream down", http.StatusBadGateway)
+		case "chatty":
+			fmt.Fprint(w, `{"choices":[{"message":{"content":"我看不清这张图"}}]}`)
+		default:
+			fmt.Fprint(w, `{"choices":[{"message":{"content":"{\"question\":\"1+1\",\"answer\":\"2\"}"}}],"usage":{"prompt_tokens":5,"completion_tokens":1}}`)
+		}
+	}))
+	defer up.Close()
+
+	a := &App{cfg: Config{
+		SiliconflowBaseURL: up.URL,
+		SiliconflowAPIKey:  "k",
+		Fallbacks:          map[string][]string{"broken": {"chatty", "good"}},
+	}}
+	ans := a.callWithFallback(context.Background(), visionRequest{model: "broken", image: "aW1n"}, analyzeOptions{}, "c")
+	if ans.Model != "good" || ans.Requested != "broken" || ans.Answer != "2" {
+		t.Fatalf("answer = %+v", ans)
+	}
+	if len(ans.Skipped) != 2 || ans.Skipped[0].Model != "broken" || ans.Skipped[1].Model != "chatty" {
+		t.Fatalf("skipped = %+v", ans.Skipped)
+	}
+
+	// 链尾仍失败时返回其结果
+	a.cfg.Fallbacks = map[string][]string{"broken": {"chatty"}}
+	ans = a.callWithFallback(context.Background(), visionRequest{model: "broken", image: "aW1n"}, analyzeOptions{}, "c")
+	if ans.Model != "chatty" || !ans.Unparsed || len(ans.Skipped) != 1 {
+		t.Fatalf("answer = %+v", ans)
+	}
+
+	// 预算降级时回退链只保留 downgrade_models 中的模型
+	a.cfg.Fallbacks = map[string][]string{"broken": {"expensive", "chatty"}}
+	ans = a.callWithFallback(context.Background(), visionRequest{model: "broken", image: "aW1n"}, analyzeOptions{downgrade: []string{"broken", "chatty"}}, "c")
+	if ans.Model != "chatty" || len(ans.Skipped) != 1 || ans.Skipped[0].Model != "broken" {
+		t.Fatalf("downgraded answer = %+v", ans)
+	}
+}
@@ -0,0 +1,237 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// healthCheck 一项就绪检查或子系统状态
+type healthCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// handleHealthz 进程存活即返回 200，供进程管理器探活；不检查依赖
+func (a *App) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleReadyz 监听已就绪、存储可写且至少一个模型可调用时返回 200，否则 503；关闭过程中返回 503 以便负载均衡摘除
+func (a *App) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	checks := a.readinessChecks()
+	status, code := "ok", http.StatusOK
+	for _, c := range checks {
+		if !c.OK {
+			status, code = "unavailable", http.StatusServiceUnavailable
+		}
+	}
+	writeJSON(w, code, map[string]interface{}{"status": status, "checks": checks})
+}
+
+func (a *App) readinessChecks() []healthCheck {
+	listeners := healthCheck{Name: "listeners", OK: a.httpUp.Load() && a.tcpUp.Load()}
+	if !listeners.OK {
+		listeners.Detail = fmt.Sprintf("http=%v tcp=%v", a.httpUp.Load(), a.tcpUp.Load())
+	}
+	return []healthCheck{listeners, a.storageCheck(), a.providerCheck()}
+}
+
+// storageCheck 数据目录（账本、题库、审计日志）与本地截图目录须可写；对象存储只检查已配置，避免每次探测都产生写请求
+func (a *App) storageCheck() healthCheck {
+	c := healthCheck{Name: "storage", OK: true}
+	dirs := []string{a.conf().DataDir}
+	if _, ok := a.captureBlobs().(*localStore); ok {
+		dirs = append(dirs, a.conf().Storage.Dir)
+	}
+	for _, dir := range dirs {
+		if err := probeWritable(dir); err != nil {
+			c.OK, c.Detail = false, err.Error()
+			return c
+		}
+	}
+	return c
+}
+
+// captureBlobs 截图存储后端；关闭存储时为 nil
+func (a *App) captureBlobs() blobStore {
+	if a.captures == nil {
+		return nil
+	}
+	return a.captures.blobs
+}
+
+// probeWritable 在目录中创建并删除临时文件；目录不存在时按首次写入的行为创建
+func probeWritable(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".readyz-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// providerCheck 至少一个配置的模型有可用的提供方：配置了 base_url，且有 API Key（siliconflow 必需，其他提供方如本地模型可不设）
+func (a *App) providerCheck() healthCheck {
+	c := healthCheck{Name: "providers"}
+	cfg := a.conf()
+	var missing []string
+	for _, model := range cfg.Models {
+		name, p := cfg.providerFor(model)
+		if strings.TrimSpace(p.BaseURL) != "" && (strings.TrimSpace(p.APIKey) != "" || name != defaultProvider) {
+			c.OK = true
+			continue
+		}
+		missing = append(missing, model)
+	}
+	if len(cfg.Models) == 0 {
+		c.Detail = "no models configured"
+	} else if len(missing) > 0 {
+		c.Detail = "no base_url or api key for " + strings.Join(missing, ", ")
+	}
+	return c
+}
+
+// StatusPageData 诊断页数据（/debug/status）
+type StatusPageData struct {
+	Version    string        `json:"version"`
+	GoVersion  string        `json:"go_version"`
+	Revision   string        `json:"revision,omitempty"`
+	BuildTime  string        `json:"build_time,omitempty"`
+	Modified   bool          `json:"modified,omitempty"`
+	StartedAt  time.Time     `json:"started_at"`
+	Uptime     string        `json:"uptime"`
+	Goroutines int           `json:"goroutines"`
+	HeapMB     float64       `json:"heap_mb"`
+	ConfigPath string        `json:"config_path"`
+	Ready      []healthCheck `json:"ready"`
+	Subsystems []healthCheck `json:"subsystems"`
+	// 打码后的实际生效配置
+	Config Config `json:"config"`
+	// 页面展示用的缩进 JSON
+	ConfigJSON string `json:"-"`
+}
+
+// statusData 汇总版本、运行时与各子系统状态；配置中的密钥打码
+func (a *App) statusData() StatusPageData {
+	d := StatusPageData{
+		Version:    "(devel)",
+		GoVersion:  runtime.Version(),
+		StartedAt:  a.startedAt,
+		Goroutines: runtime.NumGoroutine(),
+		ConfigPath: a.conf().path,
+		Ready:      a.readinessChecks(),
+		Subsystems: a.subsystems(),
+		Config:     a.conf().masked(),
+	}
+	if !a.startedAt.IsZero() {
+		d.Uptime = time.Since(a.startedAt).Round(time.Second).String()
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if bi.Main.Version != "" {
+			d.Version = bi.Main.Version
+		}
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				d.Revision = s.Value
+			case "vcs.time":
+				d.BuildTime = s.Value
+			case "vcs.modified":
+				d.Modified = s.Value == "true"
+			}
+		}
+	}
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	d.HeapMB = float64(ms.HeapAlloc) / (1 << 20)
+	b, _ := json.MarshalIndent(d.Config, "", "  ")
+	d.ConfigJSON = string(b)
+	return d
+}
+
+// subsystems 各子系统的启用情况与当前状态；OK 为 false 表示已启用但不可用或状态异常
+func (a *App) subsystems() []healthCheck {
+	var out []healthCheck
+	cfg := a.conf()
+	add := func(name string, ok bool, format string, args ...interface{}) {
+		out = append(out, healthCheck{Name: name, OK: ok, Detail: fmt.Sprintf(format, args...)})
+	}
+	add("http", a.httpUp.Load(), "addr %s", cfg.Server.httpAddr())
+	add("tcp", a.tcpUp.Load(), "addr %s, tls %v, token auth %v, %d clients connected",
+		cfg.Server.tcpAddr(), cfg.TCP.TLS.Enabled, cfg.TCP.Auth.enabled(), len(a.connectedClients()))
+
+	switch blobs := a.captureBlobs().(type) {
+	case nil:
+		add("storage", cfg.Storage.storageOff(), "disabled (backend %q)", cfg.Storage.Backend)
+	case *localStore:
+		add("storage", true, "local %s", blobs.dir)
+	default:
+		add("storage", true, "%s bucket %s", cfg.Storage.Backend, cfg.Storage.Bucket)
+	}
+	if r := cfg.Storage.Retention; a.captures != nil {
+		add("retention", r.enabled(), "max_age_days %d, max_total_mb %d, max_per_client %d, max_per_session %d", r.MaxAgeDays, r.MaxTotalMB, r.MaxPerClient, r.MaxPerSession)
+	}
+	if a.index != nil {
+		a.index.mu.RLock()
+		add("search", true, "%d captures indexed", len(a.index.docs))
+		a.index.mu.RUnlock()
+	}
+	if a.cache == nil {
+		add("cache", true, "disabled")
+	} else {
+		add("cache", true, "%s, hit ratio %.2f", cfg.Cache.Backend, a.cacheHitRatio())
+	}
+	if a.usage != nil {
+		add("usage", true, "%d records", a.usage.records())
+	}
+	if a.bank != nil {
+		a.bank.mu.RLock()
+		add("bank", true, "%d entries, enabled %v", len(a.bank.entries), cfg.Bank.Enabled)
+		a.bank.mu.RUnlock()
+	}
+	if a.audit != nil {
+		n, err := a.audit.verify()
+		if err != nil {
+			add("audit", false, "%d entries, chain broken: %v", n, err)
+		} else {
+			add("audit", true, "%d entries, chain ok", n)
+		}
+	}
+	add("http_auth", true, "enabled %v", a.auth != nil)
+	waiting, running := a.sched.depth()
+	add("scheduler", true, "%d running, %d waiting, max concurrency %d", running, waiting, cfg.Scheduler.maxConcurrency())
+	a.jobsMu.Lock()
+	jobs := len(a.jobs)
+	a.jobsMu.Unlock()
+	add("jobs", true, "%d background jobs tracked", jobs)
+	return out
+}
+
+// handleDebugStatus 渲染诊断页；?format=json 返回 JSON
+func (a *App) handleDebugStatus(w http.ResponseWriter, r *http.Request) {
+	d := a.statusData()
+	if r.URL.Query().Get("format") == "json" {
+		writeJSON(w, http.StatusOK, d)
+		return
+	}
+	tmpl, err := template.New("status").Funcs(templateFuncs).Parse(string(statusTemplate))
+	if err != nil {
+		http.Error(w, "Internal Server Error: unable to parse template", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := tmpl.Execute(w, d); err != nil {
+		http.Error(w, "Internal Server Error: unable to execute template", http.StatusInternalServerError)
+	}
+}
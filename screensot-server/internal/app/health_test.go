@@ -0,0 +1,91 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadyz(t *testing.T) {
+	dir := t.TempDir()
+	a := &App{state: &state{}, cfg: Config{Models: []string{"m1"}, SiliconflowBaseURL: "http://gw", DataDir: dir, Storage: StorageConfig{Backend: "local", Dir: filepath.Join(dir, "captures")}}}
+	a.captures = newCaptureStore(a.cfg.Storage)
+	ready := func() (int, map[string]bool) {
+		rec := httptest.NewRecorder()
+		a.handleReadyz(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		var body struct{ Checks []healthCheck }
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		ok := map[string]bool{}
+		for _, c := range body.Checks {
+			ok[c.Name] = c.OK
+		}
+		return rec.Code, ok
+	}
+
+	// 监听未就绪、siliconflow 未配置 Key
+	if code, ok := ready(); code != http.StatusServiceUnavailable || ok["listeners"] || ok["providers"] || !ok["storage"] {
+		t.Fatalf("initial: %d %v", code, ok)
+	}
+	a.httpUp.Store(true)
+	a.tcpUp.Store(true)
+	a.cfg.SiliconflowAPIKey = "sk-123456789"
+	if code, ok := ready(); code != http.StatusOK {
+		t.Fatalf("ready: %d %v", code, ok)
+	}
+	// 无需 Key 的本地提供方同样可用
+	a.cfg.SiliconflowAPIKey = ""
+	a.cfg.Models = []string{"local"}
+	a.cfg.ModelOptions = map[string]ModelOptions{"local": {Provider: "ollama"}}
+	a.cfg.Providers = map[string]ProviderConfig{"ollama": {BaseURL: "http://127.0.0.1:11434"}}
+	if code, ok := ready(); code != http.StatusOK {
+		t.Fatalf("keyless provider: %d %v", code, ok)
+	}
+
+	if os.Getuid() != 0 {
+		if err := os.Chmod(dir, 0o500); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Chmod(dir, 0o700)
+		if code, ok := ready(); code != http.StatusServiceUnavailable || ok["storage"] {
+			t.Fatalf("read-only data dir: %d %v", code, ok)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	a.handleHealthz(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("healthz = %d", rec.Code)
+	}
+}
+
+func TestDebugStatusMasksSecrets(t *testing.T) {
+	secrets := []string{"sk-siliconflow-secret", "sk-provider-secret", "s3-secret-key-value"}
+	a := &App{state: &state{}, metrics: newMetrics(), cfg: Config{
+		SiliconflowAPIKey: secrets[0],
+		Providers:         map[string]ProviderConfig{"p": {BaseURL: "http://p", APIKey: secrets[1]}},
+		Storage:           StorageConfig{SecretKey: secrets[2]},
+		DataDir:           t.TempDir(),
+	}}
+	for _, q := range []string{"", "?format=json"} {
+		rec := httptest.NewRecorder()
+		a.handleDebugStatus(rec, httptest.NewRequest(http.MethodGet, "/debug/status"+q, nil))
+		body := rec.Body.String()
+		if rec.Code != http.StatusOK || !strings.Contains(body, "goroutine") || !strings.Contains(body, "sk-s***ret") {
+			t.Fatalf("%q: %d\n%s", q, rec.Code, body)
+		}
+		for _, s := range secrets {
+			if strings.Contains(body, s) {
+				t.Errorf("%q: secret %q leaked", q, s)
+			}
+		}
+	}
+	if a.cfg.Providers["p"].APIKey != secrets[1] {
+		t.Fatal("masking modified the live config")
+	}
+}
@@ -2,90 +2,279 @@ package app
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"net/http"
 	"os"
-	"sync"
+	"strings"
 	"time"
 )
 
-// startHTTPServer 注册路由并启动 HTTP 服务
-func (a *App) startHTTPServer() {
-	http.HandleFunc("/one", a.handleOne)
-	if err := http.ListenAndServe(":8848", nil); err != nil {
-		fmt.Printf("Failed to start server: %v\n", err)
-	}
+// routes 注册全部路由；启用认证时每个处理函数按角色校验：
+// viewer 查看结果与历史，operator 触发截屏识别、追问与修改题库/截图，admin 管理客户端、用户、令牌、存储清理并查看审计日志
+func (a *App) routes(mux *http.ServeMux) {
+	// 探活与就绪检查供进程管理器与负载均衡使用，不要求登录
+	mux.HandleFunc("/healthz", a.handleHealthz)
+	mux.HandleFunc("/readyz", a.handleReadyz)
+	mux.HandleFunc("/login", a.handleLogin)
+	mux.HandleFunc("/logout", a.handleLogout)
+	mux.HandleFunc("/api/v1/me", a.require(roleViewer, a.handleAPIMe))
+
+	mux.HandleFunc("/one", a.require(roleOperator, a.handleOne))
+	mux.HandleFunc("/api/v1/one", a.require(roleOperator, a.handleAPIOne))
+	mux.HandleFunc("/events", a.require(roleViewer, a.handleEvents))
+	mux.HandleFunc("/result", a.require(roleViewer, a.handleResult))
+	mux.HandleFunc("/usage", a.require(roleViewer, a.handleUsage))
+	mux.HandleFunc("/api/v1/usage", a.require(roleViewer, a.handleAPIUsage))
+	mux.HandleFunc("/chat", a.require(roleViewer, a.handleChat))
+	mux.HandleFunc("/api/v1/chat", a.requireRW(roleViewer, roleOperator, a.handleAPIChat))
+	mux.HandleFunc("/api/v1/bank", a.requireRW(roleViewer, roleOperator, a.handleAPIBank))
+	mux.HandleFunc("/api/v1/bank/import", a.require(roleOperator, a.handleAPIBankImport))
+	mux.HandleFunc("/api/v1/bank/confirm", a.require(roleOperator, a.handleAPIBankConfirm))
+	mux.HandleFunc("/api/v1/capture", a.requireRW(roleViewer, roleOperator, a.handleAPICapture))
+	mux.HandleFunc("/images/", a.require(roleViewer, a.handleImage))
+	mux.HandleFunc("/search", a.require(roleViewer, a.handleSearch))
+	mux.HandleFunc("/metrics", a.require(roleViewer, a.handleMetrics))
+	mux.HandleFunc("/api/v1/search", a.require(roleViewer, a.handleAPISearch))
+
+	mux.HandleFunc("/api/v1/clients", a.require(roleAdmin, a.handleAPIClients))
+	mux.HandleFunc("/api/v1/clients/revoke", a.require(roleAdmin, a.handleAPIRevokeToken))
+	mux.HandleFunc("/api/v1/captures/gc", a.require(roleAdmin, a.handleAPIGC))
+	mux.HandleFunc("/api/v1/users", a.require(roleAdmin, a.handleAPIUsers))
+	mux.HandleFunc("/api/v1/tokens", a.require(roleAdmin, a.handleAPITokens))
+	mux.HandleFunc("/audit", a.require(roleAdmin, a.handleAudit))
+	mux.HandleFunc("/api/v1/audit", a.require(roleAdmin, a.handleAPIAudit))
+	mux.HandleFunc("/api/v1/audit/export", a.require(roleAdmin, a.handleAPIAuditExport))
+	mux.HandleFunc("/debug/status", a.require(roleAdmin, a.handleDebugStatus))
 }
 
 func (a *App) handleOne(w http.ResponseWriter, r *http.Request) {
 	// 诊断：打印配置摘要，确认运行期可见 key/baseURL/模板路径
-	fmt.Fprintf(os.Stderr, "handleOne: models=%v baseURL=%s keylen=%d tpl=%s\n", a.cfg.Models, a.cfg.SiliconflowBaseURL, len(a.cfg.SiliconflowAPIKey), a.cfg.TemplatePath)
+	logger(r.Context()).Debug("handleOne", "models", a.conf().Models, "base_url", a.conf().SiliconflowBaseURL, "key_len", len(a.conf().SiliconflowAPIKey), "template", a.conf().TemplatePath, "stream", a.conf().Stream)
 
-	// 支持两种模式：mode=capture 仅截屏；mode=analyze 截屏并识别（默认）
-	mode := r.URL.Query().Get("mode")
-	analyze := (mode == "" || mode == "analyze")
+	// 流式模式：截屏后立即返回页面，识别在后台进行，页面经 /events 实时接收模型输出
+	if isAnalyzeMode(r) && a.conf().Stream {
+		opts, err := a.analyzeOptionsFor(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		shots, targets, err := a.collectScreenshots(r.Context())
+		opts.targets = targets
+		if err != nil {
+			a.auditCapture(opts, true, nil, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		job := a.startAnalysisJob(shots, opts)
+		transcriber := ""
+		if opts.pipeline != nil {
+			transcriber = a.transcribeModel(opts)
+		}
+		a.renderPage(w, PageData{Items: job.placeholders(a.modelsFor(opts), transcriber), JobID: job.id, Profile: r.URL.Query().Get("profile"), Session: opts.session, Notice: opts.notice})
+		return
+	}
 
-	a.clientsMutex.Lock()
-	numClients := len(a.clients)
-	a.clientsMutex.Unlock()
-	if numClients == 0 {
-		http.Error(w, "No connected clients", http.StatusBadRequest)
+	opts, err := a.analyzeOptionsFor(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	analyses, err := a.runOne(r, opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.renderPage(w, PageData{Items: analyses, Profile: r.URL.Query().Get("profile"), Session: opts.session, Notice: opts.notice})
+}
 
-	a.sendCaptureCommandToClients()
+// handleAPIOne 与 /one 相同的截屏/识别流程，以 JSON 返回结果
+func (a *App) handleAPIOne(w http.ResponseWriter, r *http.Request) {
+	opts, err := a.analyzeOptionsFor(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	analyses, err := a.runOne(r, opts)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	resp := map[string]interface{}{"items": analyses, "request_id": opts.requestID}
+	if opts.notice != "" {
+		resp["notice"] = opts.notice
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
 
-	// 等待所有客户端的响应
-	var allResponses []string
-	var mu sync.Mutex
-	var wg sync.WaitGroup
-
-	wg.Add(numClients)
-	for i := 0; i < numClients; i++ {
-		go func() {
-			defer wg.Done()
-			select {
-			case responseStr := <-a.responseCollector:
-				mu.Lock()
-				allResponses = append(allResponses, responseStr)
-				mu.Unlock()
-			case <-time.After(10 * time.Second):
-				fmt.Println("Timeout waiting for client response")
-			}
-		}()
-	}
-	wg.Wait()
+// handleResult 渲染已完成的后台识别任务结果（流式页面结束后跳转至此）
+func (a *App) handleResult(w http.ResponseWriter, r *http.Request) {
+	job := a.getJob(r.URL.Query().Get("job"))
+	if job == nil {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+	items, done := job.result()
+	if !done {
+		http.Error(w, "job still running", http.StatusConflict)
+		return
+	}
+	a.renderItems(w, items)
+}
+
+// isAnalyzeMode 支持两种模式：mode=capture 仅截屏；mode=analyze 截屏并识别（默认）
+func isAnalyzeMode(r *http.Request) bool {
+	mode := r.URL.Query().Get("mode")
+	return mode == "" || mode == "analyze"
+}
+
+// analyzeOptionsFor 解析识别相关的查询参数：nocache=1 跳过缓存；profile=name 选择识别方案。
+// 识别模式下同时检查预算，超出时拒绝或降级模型。
+func (a *App) analyzeOptionsFor(r *http.Request) (analyzeOptions, error) {
+	q := r.URL.Query()
+	opts := analyzeOptions{
+		requestID: requestIDFrom(r.Context()),
+		actor:     actorFrom(r),
+		noCache:   q.Get("nocache") == "1" || q.Get("nocache") == "true",
+		session:   strings.TrimSpace(q.Get("session")),
+		tags:      cleanTags(splitCSV(q.Get("tags"))),
+	}
+	if opts.requestID == "" {
+		opts.requestID = newID()
+	}
+	if name := q.Get("profile"); name != "" {
+		p, ok := a.conf().Profiles[name]
+		if !ok {
+			return opts, fmt.Errorf("unknown profile %q", name)
+		}
+		opts.profile = &p
+	}
+	opts.pipeline = a.pipelineFor(q, opts.profile)
+	opts.tiling = a.tilingFor(q, opts.profile)
+	opts.verify = a.verifyFor(q, opts.profile)
+	if opts.tiling != nil && opts.tiling.Mode == tileModeTranscribe && opts.pipeline == nil {
+		// 分块转写模式：逐块转写后由推理模型作答，转写/推理模型沿用 pipeline 配置
+		pl := a.conf().Pipeline
+		if p := opts.profile; p != nil && p.Pipeline != nil {
+			pl = *p.Pipeline
+		}
+		pl.Enabled = true
+		opts.pipeline = &pl
+	}
+	if isAnalyzeMode(r) {
+		if err := a.applyBudget(&opts); err != nil {
+			a.recordAudit(opts.actor, auditEntry{Action: "analyze", Models: a.modelsFor(opts), RequestID: opts.requestID, Outcome: auditRefused, Detail: err.Error()})
+			return opts, err
+		}
+	}
+	return opts, nil
+}
+
+// runOne 下发截屏命令、收集各客户端截图，并按 mode 决定是否识别
+func (a *App) runOne(r *http.Request, opts analyzeOptions) ([]ImageEntry, error) {
+	allResponses, targets, err := a.collectScreenshots(r.Context())
+	opts.targets = targets
+	if err != nil {
+		a.auditCapture(opts, isAnalyzeMode(r), nil, err)
+		return nil, err
+	}
 
 	// 根据模式决定是否进行识别
-	var analyses []ImageEntry
-	if analyze {
+	if isAnalyzeMode(r) {
 		ctx, cancel := context.WithTimeout(r.Context(), 60*time.Second)
 		defer cancel()
-		analyses = a.analyzeImages(ctx, allResponses)
-		// 识别后缓存为“最近一次已识别”
-		a.setLastAnalyses(analyses)
-	} else {
-		// 仅截屏模式：合并“新截图的 Base64”与“上一次识别结果的 ModelAnswers”，保留既有识别
-		last := a.getLastAnalyses()
-		analyses = make([]ImageEntry, len(allResponses))
-		for i := range allResponses {
-			analyses[i] = ImageEntry{Base64: allResponses[i]}
-			if i < len(last) && len(last[i].ModelAnswers) > 0 {
-				analyses[i].ModelAnswers = append([]ModelAnswer(nil), last[i].ModelAnswers...)
-			}
+		analyses := a.analyzeImages(ctx, allResponses, opts)
+		a.finishAnalyses(analyses, opts)
+		return analyses, nil
+	}
+	a.auditCapture(opts, false, nil, nil)
+	return a.mergeLastAnalyses(allResponses), nil
+}
+
+// collectScreenshots 向所有客户端下发截屏命令并等待响应（最多等待 10s），同时返回命令下发到的客户端
+func (a *App) collectScreenshots(ctx context.Context) ([]screenshot, []string, error) {
+	id := requestIDFrom(ctx)
+	if id == "" {
+		id = newID()
+	}
+	id, targets, waiter := a.sendCaptureCommandToClients(ctx, id)
+	defer a.finishCapture(id)
+	if len(targets) == 0 {
+		return nil, nil, errors.New("No connected clients")
+	}
+
+	// 等待所有客户端的响应
+	var allResponses []screenshot
+	timeout := time.NewTimer(10 * time.Second)
+	defer timeout.Stop()
+	for len(allResponses) < len(targets) {
+		select {
+		case shot := <-waiter:
+			allResponses = append(allResponses, shot)
+		case <-timeout.C:
+			logger(ctx).Warn("timeout waiting for client screenshot", "received", len(allResponses), "expected", len(targets))
+			return allResponses, targets, nil
+		case <-ctx.Done():
+			return allResponses, targets, nil
+		}
+	}
+	return allResponses, targets, nil
+}
+
+// finishAnalyses 识别完成后做跨模型一致性比对，持久化截图，并缓存为“最近一次已识别”
+func (a *App) finishAnalyses(analyses []ImageEntry, opts analyzeOptions) {
+	for i := range analyses {
+		analyses[i].Consensus = buildConsensus(analyses[i].ModelAnswers)
+	}
+	a.saveCaptures(analyses, opts)
+	a.setLastAnalyses(analyses)
+	a.auditCapture(opts, true, analyses, nil)
+}
+
+// mergeLastAnalyses 仅截屏模式：合并“新截图的 Base64”与“上一次识别结果的 ModelAnswers”，保留既有识别
+func (a *App) mergeLastAnalyses(shots []screenshot) []ImageEntry {
+	last := a.getLastAnalyses()
+	analyses := make([]ImageEntry, len(shots))
+	// ImageURL 指向上一次的截图，不沿用，页面内联显示新截图
+	for i := range shots {
+		analyses[i] = ImageEntry{Client: shots[i].Client, Base64: shots[i].Base64}
+		if i < len(last) && len(last[i].ModelAnswers) > 0 {
+			analyses[i].ModelAnswers = append([]ModelAnswer(nil), last[i].ModelAnswers...)
+			analyses[i].Consensus = last[i].Consensus
+			analyses[i].Transcript = last[i].Transcript
+			analyses[i].ID = last[i].ID
 		}
 	}
+	return analyses
+}
+
+// PageData 结果页模板数据；JobID 非空表示识别仍在后台流式进行
+type PageData struct {
+	Items []ImageEntry
+	JobID string
+	// 当前识别方案名，页面按钮沿用
+	Profile string
+	// 当前会话名，页面按钮沿用
+	Session string
+	// 提示信息，如预算超出后的模型降级
+	Notice string
+}
 
-	// HTML 渲染：模板外置
-	type PageData struct{ Items []ImageEntry }
-	data := PageData{Items: analyses}
-	tplBytes, err := os.ReadFile(a.cfg.TemplatePath)
+// renderItems 使用外置模板（缺失时回退内置模板）渲染结果页
+func (a *App) renderItems(w http.ResponseWriter, analyses []ImageEntry) {
+	a.renderPage(w, PageData{Items: analyses})
+}
+
+// renderPage 渲染结果页（模板外置）
+func (a *App) renderPage(w http.ResponseWriter, data PageData) {
+	tplBytes, err := os.ReadFile(a.conf().TemplatePath)
 	if err != nil || len(tplBytes) == 0 {
 		// 不存在外部模板时回退到内置模板，确保单文件二进制可运行
 		tplBytes = defaultTemplate
 	}
-	tmpl, err := template.New("result").Parse(string(tplBytes))
+	tmpl, err := template.New("result").Funcs(templateFuncs).Parse(string(tplBytes))
 	if err != nil {
 		http.Error(w, "Internal Server Error: unable to parse template", http.StatusInternalServerError)
 		return
@@ -96,3 +285,27 @@ func (a *App) handleOne(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// templateFuncs 结果页模板可用的辅助函数
+var templateFuncs = template.FuncMap{
+	// percent 将 0~1 的比例格式化为百分比
+	"percent": func(f float64) string { return fmt.Sprintf("%.0f%%", f*100) },
+	// short 截断过长文本（如上游错误响应）
+	"short": func(s string) string { return truncate(s, 120) },
+	// money 费用保留 4 位小数
+	"money": func(f float64) string { return fmt.Sprintf("%.4f", f) },
+}
+
+// writeJSON 以 JSON 写出响应体
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(v)
+}
+
+// writeJSONError 以 {"error": "..."} 形式写出错误
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
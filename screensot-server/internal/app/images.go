@@ -0,0 +1,130 @@
+package app
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// thumbWidths 可用的缩略图宽度；请求宽度向上取整到其中之一，避免任意尺寸占满存储
+var thumbWidths = []int{160, 320, 480, 640, 960, 1280}
+
+func imageURL(id string) string { return "/images/" + id }
+
+func thumbKey(id string, w int) string { return fmt.Sprintf("thumbs/%s_%d.jpg", id, w) }
+
+// snapThumbWidth 取不小于 w 的最小可用宽度，超出时取最大宽度
+func snapThumbWidth(w int) int {
+	for _, t := range thumbWidths {
+		if w <= t {
+			return t
+		}
+	}
+	return thumbWidths[len(thumbWidths)-1]
+}
+
+// thumbnail 读取（或生成并保存）指定宽度的 JPEG 缩略图；原图不宽于 w 时返回原图
+func (s *captureStore) thumbnail(id string, w int) ([]byte, error) {
+	if !validID(id) {
+		return nil, errBlobNotFound
+	}
+	if b, err := s.blobs.Get(thumbKey(id, w)); err == nil {
+		return b, nil
+	}
+	orig, err := s.image(id)
+	if err != nil {
+		return nil, err
+	}
+	src, _, err := image.Decode(bytes.NewReader(orig))
+	if err != nil {
+		return nil, fmt.Errorf("decode image: %w", err)
+	}
+	b := src.Bounds()
+	if b.Dx() <= w {
+		return orig, nil
+	}
+	out, _, err := encodeImage(resizeArea(toNRGBA(src), w, b.Dy()*w/b.Dx()), 80)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.blobs.Put(thumbKey(id, w), out); err != nil {
+		slog.Warn("save thumbnail", "err", err)
+	}
+	return out, nil
+}
+
+// handleImage GET /images/{id}[?w=480] 返回截图原图或缩略图。
+// 同一 ID 的图片内容不会改变，ETag 由 ID 与宽度构成，浏览器可长期缓存。
+func (a *App) handleImage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if a.captures == nil {
+		http.Error(w, "capture storage disabled", http.StatusNotFound)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/images/")
+	if !validID(id) {
+		http.NotFound(w, r)
+		return
+	}
+	width := 0
+	if v := r.URL.Query().Get("w"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid width", http.StatusBadRequest)
+			return
+		}
+		width = snapThumbWidth(n)
+	}
+	etag := `"` + id + `"`
+	if width > 0 {
+		etag = fmt.Sprintf(`"%s-w%d"`, id, width)
+	}
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	if etagMatch(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	var b []byte
+	var err error
+	if width > 0 {
+		b, err = a.captures.thumbnail(id, width)
+	} else {
+		b, err = a.captures.image(id)
+	}
+	if errors.Is(err, errBlobNotFound) {
+		w.Header().Del("Cache-Control")
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		w.Header().Del("Cache-Control")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", http.DetectContentType(b))
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	if r.Method == http.MethodHead {
+		return
+	}
+	_, _ = w.Write(b)
+}
+
+// etagMatch 判断 If-None-Match 是否包含 etag（支持 * 与弱校验前缀 W/）
+func etagMatch(header, etag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == "*" || t == etag {
+			return true
+		}
+	}
+	return false
+}
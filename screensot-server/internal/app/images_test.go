@@ -0,0 +1,83 @@
+package app
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestImageEndpoint(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 1000, 500))
+	for i := range src.Pix {
+		src.Pix[i] = 0xff
+	}
+	src.SetNRGBA(10, 10, color.NRGBA{A: 0xff})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+	a := &App{captures: newCaptureStore(StorageConfig{Backend: "local", Dir: t.TempDir()})}
+	items := []ImageEntry{{Client: "pc", Base64: base64.StdEncoding.EncodeToString(buf.Bytes())}}
+	a.saveCaptures(items, analyzeOptions{requestID: "r"})
+	if items[0].ImageURL != "/images/"+items[0].ID {
+		t.Fatalf("image url = %q", items[0].ImageURL)
+	}
+
+	get := func(url, etag string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, url, nil)
+		if etag != "" {
+			r.Header.Set("If-None-Match", etag)
+		}
+		w := httptest.NewRecorder()
+		a.handleImage(w, r)
+		return w
+	}
+	w := get(items[0].ImageURL, "")
+	if w.Code != 200 || w.Header().Get("Content-Type") != "image/png" || !bytes.Equal(w.Body.Bytes(), buf.Bytes()) {
+		t.Fatalf("original: %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	etag := w.Header().Get("ETag")
+	if etag == "" || w.Header().Get("Cache-Control") == "" {
+		t.Fatalf("headers = %v", w.Header())
+	}
+	if w := get(items[0].ImageURL, etag); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Fatalf("conditional: %d", w.Code)
+	}
+
+	w = get(items[0].ImageURL+"?w=300", "")
+	if w.Code != 200 || w.Header().Get("Content-Type") != "image/jpeg" || w.Header().Get("ETag") == etag {
+		t.Fatalf("thumb: %d %v", w.Code, w.Header())
+	}
+	thumb, err := jpeg.Decode(bytes.NewReader(w.Body.Bytes()))
+	if err != nil || thumb.Bounds().Dx() != 320 || thumb.Bounds().Dy() != 160 {
+		t.Fatalf("thumb = %v, %v", thumb.Bounds(), err)
+	}
+	if _, err := a.captures.blobs.Get(thumbKey(items[0].ID, 320)); err != nil {
+		t.Fatalf("thumb not stored: %v", err)
+	}
+	// 原图不宽于请求宽度时返回原图
+	if w := get(items[0].ImageURL+"?w=5000", ""); w.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("wide thumb type = %q", w.Header().Get("Content-Type"))
+	}
+
+	if err := a.captures.remove(items[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.captures.blobs.Get(thumbKey(items[0].ID, 320)); err != errBlobNotFound {
+		t.Fatalf("thumb after remove: %v", err)
+	}
+	for _, u := range []string{items[0].ImageURL, "/images/../../etc/passwd", "/images/nothex"} {
+		if w := get(u, ""); w.Code != http.StatusNotFound {
+			t.Fatalf("%s: %d", u, w.Code)
+		}
+	}
+	if w := get("/images/"+newID()+"?w=abc", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("bad width: %d", w.Code)
+	}
+}
@@ -0,0 +1,264 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 已完成任务在内存中保留的时长，供 /result 与迟到的 /events 订阅读取
+const jobRetention = 10 * time.Minute
+
+// jobEvent 推送给浏览器的识别进度事件
+type jobEvent struct {
+	// queue：排队位置；delta：某图片某模型的累计输出；answer：单个模型完成；bank：题库命中；done：全部完成
+	Type  string `json:"type"`
+	Image int    `json:"image"`
+	Model string `json:"model,omitempty"`
+	Text  string `json:"text,omitempty"`
+	// queue 事件的排队位置，0 表示已开始调用
+	Position int          `json:"position"`
+	Answer   *ModelAnswer `json:"answer,omitempty"`
+	// bank 事件的题库命中
+	Bank *BankHit `json:"bank,omitempty"`
+}
+
+// analysisJob 一次后台识别任务：累积各模型的流式输出并广播给订阅者
+type analysisJob struct {
+	id      string
+	shots   []screenshot
+	mu      sync.Mutex
+	partial map[string]jobEvent // key: 图片序号|模型，保存最近一次 delta/answer，供新订阅者回放
+	subs    map[*jobSub]struct{}
+	done    bool
+	doneAt  time.Time
+	items   []ImageEntry
+}
+
+// startAnalysisJob 登记任务并在后台执行识别，完成后写入“最近一次已识别”
+func (a *App) startAnalysisJob(shots []screenshot, opts analyzeOptions) *analysisJob {
+	job := &analysisJob{
+		id:      newID(),
+		shots:   shots,
+		partial: map[string]jobEvent{},
+		subs:    map[*jobSub]struct{}{},
+	}
+	a.jobsMu.Lock()
+	if a.jobs == nil {
+		a.jobs = map[string]*analysisJob{}
+	}
+	for id, j := range a.jobs {
+		if j.expired() {
+			delete(a.jobs, id)
+		}
+	}
+	a.jobs[job.id] = job
+	a.jobsMu.Unlock()
+
+	a.jobsWG.Add(1)
+	go func() {
+		defer a.jobsWG.Done()
+		// 请求已返回页面，识别不再跟随请求上下文
+		ctx, cancel := context.WithTimeout(withRequestID(context.Background(), opts.requestID), 60*time.Second)
+		defer cancel()
+		opts.onDelta = func(image int, model, text string) {
+			job.publish(jobEvent{Type: "delta", Image: image, Model: model, Text: text})
+		}
+		opts.onQueue = func(image int, model string, position int) {
+			job.publish(jobEvent{Type: "queue", Image: image, Model: model, Position: position})
+		}
+		opts.onAnswer = func(image int, ans ModelAnswer) {
+			job.publish(jobEvent{Type: "answer", Image: image, Model: ans.requestedModel(), Answer: &ans})
+		}
+		opts.onBankHit = func(image int, hit BankHit) {
+			job.publish(jobEvent{Type: "bank", Image: image, Model: "#bank", Bank: &hit})
+		}
+		opts.onTranscript = func(image int, ans ModelAnswer) {
+			job.publish(jobEvent{Type: "answer", Image: image, Model: transcriptKey, Answer: &ans})
+		}
+		items := a.analyzeImages(ctx, shots, opts)
+		a.finishAnalyses(items, opts)
+		job.finish(items)
+	}()
+	return job
+}
+
+// getJob 按 ID 查找任务，不存在或已过期返回 nil
+func (a *App) getJob(id string) *analysisJob {
+	a.jobsMu.Lock()
+	defer a.jobsMu.Unlock()
+	job := a.jobs[id]
+	if job == nil || job.expired() {
+		return nil
+	}
+	return job
+}
+
+// placeholders 生成页面初始数据：每张图片每个模型一张“等待输出”的卡片；transcriber 非空时另有一张转写卡片
+func (j *analysisJob) placeholders(models []string, transcriber string) []ImageEntry {
+	items := make([]ImageEntry, len(j.shots))
+	for i := range j.shots {
+		items[i] = ImageEntry{Client: j.shots[i].Client, Base64: j.shots[i].Base64, ModelAnswers: make([]ModelAnswer, 0, len(models))}
+		if transcriber != "" {
+			items[i].Transcript = &ModelAnswer{Model: transcriber, Pending: true}
+		}
+		for _, m := range models {
+			items[i].ModelAnswers = append(items[i].ModelAnswers, ModelAnswer{Model: m, Pending: true})
+		}
+	}
+	return items
+}
+
+// jobSub 一个订阅者：publish 只登记有更新的卡片并唤醒，订阅者被唤醒后从 partial 取各卡片的最新事件。
+// 消费过慢时同一卡片的多条 delta 合并为最新一条，answer、bank 等最终事件不会丢失
+type jobSub struct {
+	// 有新事件时写入（容量 1，不阻塞发布者）；任务完成时关闭
+	notify chan struct{}
+	// 有更新尚未取走的卡片 key
+	dirty map[string]bool
+}
+
+func (j *analysisJob) publish(ev jobEvent) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	key := fmt.Sprintf("%d|%s", ev.Image, ev.Model)
+	j.partial[key] = ev
+	for s := range j.subs {
+		s.dirty[key] = true
+		select {
+		case s.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (j *analysisJob) finish(items []ImageEntry) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.done = true
+	j.doneAt = time.Now()
+	j.items = items
+	for s := range j.subs {
+		close(s.notify)
+		delete(j.subs, s)
+	}
+}
+
+// subscribe 返回当前已累积的事件快照与订阅者；任务已完成时订阅者为 nil
+func (j *analysisJob) subscribe() ([]jobEvent, *jobSub) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	snapshot := make([]jobEvent, 0, len(j.partial))
+	for _, ev := range j.partial {
+		snapshot = append(snapshot, ev)
+	}
+	if j.done {
+		return snapshot, nil
+	}
+	s := &jobSub{notify: make(chan struct{}, 1), dirty: map[string]bool{}}
+	j.subs[s] = struct{}{}
+	return snapshot, s
+}
+
+// take 取走订阅者尚未收到的各卡片最新事件（按卡片排序）
+func (j *analysisJob) take(s *jobSub) []jobEvent {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	keys := make([]string, 0, len(s.dirty))
+	for k := range s.dirty {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	evs := make([]jobEvent, 0, len(keys))
+	for _, k := range keys {
+		evs = append(evs, j.partial[k])
+		delete(s.dirty, k)
+	}
+	return evs
+}
+
+func (j *analysisJob) unsubscribe(s *jobSub) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, ok := j.subs[s]; ok {
+		delete(j.subs, s)
+		close(s.notify)
+	}
+}
+
+// result 返回识别结果与是否已完成
+func (j *analysisJob) result() ([]ImageEntry, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.items, j.done
+}
+
+func (j *analysisJob) expired() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.done && time.Since(j.doneAt) > jobRetention
+}
+
+// handleEvents 以 Server-Sent Events 推送任务进度：先回放快照，再实时转发，完成时发送 done
+func (a *App) handleEvents(w http.ResponseWriter, r *http.Request) {
+	job := a.getJob(r.URL.Query().Get("job"))
+	if job == nil {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	clearWriteDeadline(w)
+
+	snapshot, sub := job.subscribe()
+	for _, ev := range snapshot {
+		writeSSE(w, ev)
+	}
+	flusher.Flush()
+	if sub != nil {
+		defer job.unsubscribe(sub)
+	loop:
+		for {
+			select {
+			case _, ok := <-sub.notify:
+				// 任务完成时通道关闭，先发出最后一批更新
+				for _, ev := range job.take(sub) {
+					writeSSE(w, ev)
+				}
+				flusher.Flush()
+				if !ok {
+					break loop
+				}
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+	writeSSE(w, jobEvent{Type: "done"})
+	flusher.Flush()
+}
+
+// writeSSE 写出一条 SSE 事件，事件名即 ev.Type
+func writeSSE(w http.ResponseWriter, ev jobEvent) {
+	b, _ := json.Marshal(ev)
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, b)
+}
+
+// newID 生成 16 位十六进制随机 ID
+func newID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,36 @@
+package app
+
+import (
+	"fmt"
+	"testing"
+)
+
+// 订阅者未及时消费时合并同一卡片的 delta，最终的 answer 与 bank 事件不会丢失
+func TestJobSlowSubscriberKeepsFinalEvents(t *testing.T) {
+	j := &analysisJob{partial: map[string]jobEvent{}, subs: map[*jobSub]struct{}{}}
+	_, sub := j.subscribe()
+	for i := 0; i < 500; i++ {
+		j.publish(jobEvent{Type: "delta", Image: 0, Model: "m1", Text: fmt.Sprint(i)})
+		j.publish(jobEvent{Type: "delta", Image: 0, Model: "m2", Text: fmt.Sprint(i)})
+	}
+	j.publish(jobEvent{Type: "answer", Image: 0, Model: "m1", Answer: &ModelAnswer{Model: "m1", Answer: "B"}})
+	j.publish(jobEvent{Type: "bank", Image: 0, Model: "#bank", Bank: &BankHit{}})
+	j.finish(nil)
+
+	if _, ok := <-sub.notify; !ok {
+		t.Fatal("no notification before close")
+	}
+	got := map[string]jobEvent{}
+	for _, ev := range j.take(sub) {
+		got[ev.Model] = ev
+	}
+	if got["m1"].Type != "answer" || got["m2"].Text != "499" || got["#bank"].Type != "bank" || len(got) != 3 {
+		t.Fatalf("events = %+v", got)
+	}
+	if _, ok := <-sub.notify; ok {
+		t.Fatal("notify not closed after finish")
+	}
+	if evs := j.take(sub); len(evs) != 0 {
+		t.Fatalf("events taken twice: %+v", evs)
+	}
+}
@@ -0,0 +1,116 @@
+package app
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// LogConfig 日志级别与格式，输出到标准错误
+type LogConfig struct {
+	// debug / info（默认）/ warn / error；debug 下额外输出每个 HTTP 请求、截屏命令与模型调用
+	Level string `json:"level"`
+	// text（默认）或 json
+	Format string `json:"format"`
+}
+
+// setupLogging 按配置替换默认 logger；无法识别的级别按 info 处理
+func setupLogging(c LogConfig) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.TrimSpace(c.Level))); err != nil && c.Level != "" {
+		slog.Warn("unknown log level, using info", "level", c.Level)
+		level = slog.LevelInfo
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler = slog.NewTextHandler(os.Stderr, opts)
+	if strings.EqualFold(c.Format, "json") {
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(h))
+}
+
+type requestIDKey struct{}
+
+// withRequestID 将请求 ID 放入上下文，随识别任务、截屏命令与模型调用传递
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// logger 带请求 ID 的 logger
+func logger(ctx context.Context) *slog.Logger {
+	if id := requestIDFrom(ctx); id != "" {
+		return slog.With("request_id", id)
+	}
+	return slog.Default()
+}
+
+// validRequestID 只沿用长度合理、字符安全的 X-Request-ID
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// statusRecorder 记录响应状态码；保留 Flush 与 Unwrap 以免影响流式接口
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter { return s.ResponseWriter }
+
+// withRequestLog 为每个请求分配 ID（沿用合法的 X-Request-ID 请求头）并写入响应头；
+// 请求结束后按 debug 记录，5xx 按 warn 记录
+func withRequestLog(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		r = r.WithContext(withRequestID(r.Context(), id))
+		h.ServeHTTP(rec, r)
+		level := slog.LevelDebug
+		if rec.status >= 500 {
+			level = slog.LevelWarn
+		}
+		logger(r.Context()).Log(r.Context(), level, "http request", "method", r.Method, "path", r.URL.Path,
+			"status", rec.status, "duration", time.Since(start), "remote", requestIP(r))
+	})
+}
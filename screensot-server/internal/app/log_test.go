@@ -0,0 +1,80 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"screensot-server/internal/protocol"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	h := withRequestLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(http.Flusher); !ok {
+			t.Error("wrapped writer lost http.Flusher")
+		}
+		fmt.Fprint(w, requestIDFrom(r.Context()))
+	}))
+	for header, keep := range map[string]bool{"abc-123_x.y": true, "": false, "bad id": false, strings.Repeat("a", 65): false} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Request-ID", header)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		got := rec.Body.String()
+		if got == "" || rec.Header().Get("X-Request-ID") != got || (got == header) != keep {
+			t.Errorf("header %q: id %q, response header %q", header, got, rec.Header().Get("X-Request-ID"))
+		}
+	}
+}
+
+// 请求 ID 经截屏命令传给客户端并带回，同时透传给模型网关
+func TestRequestIDFlowsToClientsAndModels(t *testing.T) {
+	upstreamIDs := make(chan string, 4)
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamIDs <- r.Header.Get("X-Request-ID")
+		fmt.Fprint(w, `{"choices":[{"message":{"content":"{\"question\":\"1+1\",\"answer\":\"2\"}"}}]}`)
+	}))
+	defer up.Close()
+
+	a := &App{state: &state{clients: map[net.Conn]*tcpClient{}}}
+	a.cfg = Config{Models: []string{"m1"}, SiliconflowBaseURL: up.URL, SiliconflowAPIKey: "k"}
+	server, client := net.Pipe()
+	defer client.Close()
+	go a.handleTCPClient(server)
+	commandIDs := make(chan string, 1)
+	go func() {
+		b, err := protocol.ReadWithLengthPrefix(client)
+		if err != nil {
+			return
+		}
+		cmd, id := protocol.ParseCommand(string(b))
+		commandIDs <- cmd + " " + id
+		resp, _ := json.Marshal(protocol.Response{Code: 200, Data: []byte("img"), RequestID: id})
+		_ = protocol.SendWithLengthPrefix(client, resp)
+	}()
+	for deadline := time.Now().Add(time.Second); len(a.connectedClients()) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("client not registered")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/one?mode=analyze", nil)
+	req.Header.Set("X-Request-ID", "req-42")
+	rec := httptest.NewRecorder()
+	withRequestLog(http.HandlerFunc(a.handleAPIOne)).ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"request_id":"req-42"`) {
+		t.Fatalf("api = %d %s", rec.Code, rec.Body)
+	}
+	if got := <-commandIDs; got != protocol.CmdCapture+" req-42" {
+		t.Fatalf("capture command = %q", got)
+	}
+	if got := <-upstreamIDs; got != "req-42" {
+		t.Fatalf("upstream X-Request-ID = %q", got)
+	}
+}
@@ -0,0 +1,274 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metrics 进程内指标，由 /metrics 以 Prometheus 文本格式暴露（拉取方式，无需依赖外部监控服务）。
+// 方法对 nil 接收者安全，测试中直接构造的 App 可不初始化
+type metrics struct {
+	mu         sync.Mutex
+	counters   map[string]*metricFamily
+	histograms map[string]*metricFamily
+}
+
+// metricFamily 同名指标按标签值区分的各条序列
+type metricFamily struct {
+	help    string
+	labels  []string
+	buckets []float64
+	series  map[string]*metricSeries
+}
+
+type metricSeries struct {
+	values []string
+	// 计数器取值；直方图为观测值总和
+	sum float64
+	// 直方图各桶计数（非累计）与总数
+	counts []uint64
+	count  uint64
+}
+
+// 截屏往返耗时（秒）、图片大小（字节）与模型调用耗时（秒）的直方图桶
+var (
+	captureLatencyBuckets = []float64{0.1, 0.25, 0.5, 1, 2, 5, 10}
+	imageSizeBuckets      = []float64{64 << 10, 256 << 10, 512 << 10, 1 << 20, 2 << 20, 4 << 20, 8 << 20}
+	visionLatencyBuckets  = []float64{0.5, 1, 2, 5, 10, 20, 30, 60, 120}
+)
+
+func newMetrics() *metrics {
+	m := &metrics{counters: map[string]*metricFamily{}, histograms: map[string]*metricFamily{}}
+	m.counters["screensot_captures_total"] = &metricFamily{help: "Screenshots received from capture clients by client and result code.", labels: []string{"client", "code"}}
+	m.counters["screensot_vision_calls_total"] = &metricFamily{help: "Upstream model calls by model and HTTP status code (\"error\" when no response was received).", labels: []string{"model", "code"}}
+	m.counters["screensot_vision_tokens_total"] = &metricFamily{help: "Tokens reported by upstream model calls by model and type (prompt or completion).", labels: []string{"model", "type"}}
+	m.counters["screensot_cache_lookups_total"] = &metricFamily{help: "Answer cache lookups by result (hit or miss).", labels: []string{"result"}}
+	m.histograms["screensot_capture_roundtrip_seconds"] = &metricFamily{help: "Time from sending a capture command to receiving the client's screenshot.", labels: []string{"client"}, buckets: captureLatencyBuckets}
+	m.histograms["screensot_capture_image_bytes"] = &metricFamily{help: "Size of screenshots received from capture clients.", buckets: imageSizeBuckets}
+	m.histograms["screensot_vision_call_duration_seconds"] = &metricFamily{help: "Upstream model call latency by model, including failed calls.", labels: []string{"model"}, buckets: visionLatencyBuckets}
+	for _, f := range m.counters {
+		f.series = map[string]*metricSeries{}
+	}
+	for _, f := range m.histograms {
+		f.series = map[string]*metricSeries{}
+	}
+	return m
+}
+
+func (f *metricFamily) get(values []string) *metricSeries {
+	key := strings.Join(values, "\xff")
+	s := f.series[key]
+	if s == nil {
+		s = &metricSeries{values: values, counts: make([]uint64, len(f.buckets))}
+		f.series[key] = s
+	}
+	return s
+}
+
+// add 计数器加 v
+func (m *metrics) add(name string, v float64, labels ...string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counters[name].get(labels).sum += v
+}
+
+// observe 直方图记录一个观测值
+func (m *metrics) observe(name string, v float64, labels ...string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f := m.histograms[name]
+	s := f.get(labels)
+	s.sum += v
+	s.count++
+	if i := sort.SearchFloat64s(f.buckets, v); i < len(f.buckets) {
+		s.counts[i]++
+	}
+}
+
+// counter 读取计数器当前值，未记录过时为 0
+func (m *metrics) counter(name string, labels ...string) float64 {
+	if m == nil {
+		return 0
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s := m.counters[name].series[strings.Join(labels, "\xff")]; s != nil {
+		return s.sum
+	}
+	return 0
+}
+
+// observeCapture 记录一张客户端回传的截图；commandAt 为下发截屏命令的时间（零值表示未知，不计往返耗时）
+func (m *metrics) observeCapture(client string, code, size int, commandAt time.Time) {
+	m.add("screensot_captures_total", 1, client, strconv.Itoa(code))
+	if code != 200 {
+		return
+	}
+	m.observe("screensot_capture_image_bytes", float64(size))
+	if !commandAt.IsZero() {
+		m.observe("screensot_capture_roundtrip_seconds", time.Since(commandAt).Seconds(), client)
+	}
+}
+
+// observeVisionCall 记录一次上游调用；code 为 0 表示未收到响应
+func (m *metrics) observeVisionCall(model string, code int, d time.Duration) {
+	status := "error"
+	if code != 0 {
+		status = strconv.Itoa(code)
+	}
+	m.add("screensot_vision_calls_total", 1, model, status)
+	m.observe("screensot_vision_call_duration_seconds", d.Seconds(), model)
+}
+
+func (m *metrics) observeUsage(model string, u *Usage) {
+	if u == nil {
+		return
+	}
+	m.add("screensot_vision_tokens_total", float64(u.PromptTokens), model, "prompt")
+	m.add("screensot_vision_tokens_total", float64(u.CompletionTokens), model, "completion")
+}
+
+func (m *metrics) observeCacheLookup(hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	m.add("screensot_cache_lookups_total", 1, result)
+}
+
+// gauge 抓取时计算的瞬时值
+type gauge struct {
+	name, help string
+	value      float64
+}
+
+// gauges 连接数、队列深度与缓存命中率等在抓取时读取当前状态
+func (a *App) gauges() []gauge {
+	a.clientsMutex.Lock()
+	clients := len(a.clients)
+	a.clientsMutex.Unlock()
+	a.jobsMu.Lock()
+	jobs := 0
+	for _, j := range a.jobs {
+		if _, done := j.result(); !done {
+			jobs++
+		}
+	}
+	a.jobsMu.Unlock()
+	waiting, running := a.sched.depth()
+	return []gauge{
+		{"screensot_connected_clients", "Capture clients currently connected.", float64(clients)},
+		{"screensot_scheduler_queue_depth", "Upstream model calls waiting for a scheduler slot.", float64(waiting)},
+		{"screensot_scheduler_running", "Upstream model calls currently running.", float64(running)},
+		{"screensot_analysis_jobs_running", "Background (streaming) analysis jobs still running.", float64(jobs)},
+		{"screensot_cache_hit_ratio", "Answer cache hits divided by lookups since start.", a.cacheHitRatio()},
+	}
+}
+
+// cacheHitRatio 启动以来的缓存命中率，尚无查询时为 0
+func (a *App) cacheHitRatio() float64 {
+	hits, misses := a.metrics.counter("screensot_cache_lookups_total", "hit"), a.metrics.counter("screensot_cache_lookups_total", "miss")
+	if hits+misses == 0 {
+		return 0
+	}
+	return hits / (hits + misses)
+}
+
+// handleMetrics 以 Prometheus 文本格式（0.0.4）输出全部指标
+func (a *App) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	for _, g := range a.gauges() {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", g.name, g.help, g.name, g.name, formatFloat(g.value))
+	}
+	a.metrics.write(w)
+}
+
+// write 按名称与标签排序输出计数器与直方图，保证输出稳定
+func (m *metrics) write(w io.Writer) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, name := range familyNames(m.counters) {
+		f := m.counters[name]
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, f.help, name)
+		for _, s := range f.sorted() {
+			fmt.Fprintf(w, "%s%s %s\n", name, labelString(f.labels, s.values, ""), formatFloat(s.sum))
+		}
+	}
+	for _, name := range familyNames(m.histograms) {
+		f := m.histograms[name]
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, f.help, name)
+		for _, s := range f.sorted() {
+			var cum uint64
+			for i, b := range f.buckets {
+				cum += s.counts[i]
+				fmt.Fprintf(w, "%s_bucket%s %d\n", name, labelString(f.labels, s.values, formatFloat(b)), cum)
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", name, labelString(f.labels, s.values, "+Inf"), s.count)
+			fmt.Fprintf(w, "%s_sum%s %s\n", name, labelString(f.labels, s.values, ""), formatFloat(s.sum))
+			fmt.Fprintf(w, "%s_count%s %d\n", name, labelString(f.labels, s.values, ""), s.count)
+		}
+	}
+}
+
+func familyNames(m map[string]*metricFamily) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (f *metricFamily) sorted() []*metricSeries {
+	keys := make([]string, 0, len(f.series))
+	for k := range f.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]*metricSeries, len(keys))
+	for i, k := range keys {
+		out[i] = f.series[k]
+	}
+	return out
+}
+
+// labelEscaper 标签值只需转义反斜杠、双引号与换行
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labelString 生成 {a="x",le="0.5"}；le 为空时不输出桶边界，无标签时返回空串
+func labelString(names, values []string, le string) string {
+	var parts []string
+	for i, n := range names {
+		parts = append(parts, n+`="`+labelEscaper.Replace(values[i])+`"`)
+	}
+	if le != "" {
+		parts = append(parts, `le="`+le+`"`)
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
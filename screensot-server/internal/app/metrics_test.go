@@ -0,0 +1,74 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsExposition(t *testing.T) {
+	m := newMetrics()
+	m.observeCapture(`a"b`, 200, 100<<10, time.Now().Add(-300*time.Millisecond))
+	m.observeCapture("c", 500, 0, time.Time{})
+	m.observeCacheLookup(true)
+	m.observeCacheLookup(false)
+	m.observeCacheLookup(false)
+	m.observeCacheLookup(false)
+	a := &App{state: &state{}, metrics: m}
+
+	rec := httptest.NewRecorder()
+	a.handleMetrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	out := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE screensot_connected_clients gauge\nscreensot_connected_clients 0\n",
+		"screensot_cache_hit_ratio 0.25\n",
+		`screensot_captures_total{client="a\"b",code="200"} 1`,
+		`screensot_captures_total{client="c",code="500"} 1`,
+		"# TYPE screensot_capture_image_bytes histogram\n",
+		`screensot_capture_image_bytes_bucket{le="65536"} 0`,
+		`screensot_capture_image_bytes_bucket{le="262144"} 1`,
+		`screensot_capture_image_bytes_bucket{le="+Inf"} 1`,
+		`screensot_capture_roundtrip_seconds_bucket{client="a\"b",le="0.25"} 0`,
+		`screensot_capture_roundtrip_seconds_bucket{client="a\"b",le="0.5"} 1`,
+		`screensot_capture_roundtrip_seconds_count{client="a\"b"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in\n%s", want, out)
+		}
+	}
+}
+
+func TestMetricsVisionCalls(t *testing.T) {
+	fail := true
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			http.Error(w, "busy", http.StatusTooManyRequests)
+			return
+		}
+		fmt.Fprint(w, `{"choices":[{"message":{"content":"ok"}}],"usage":{"prompt_tokens":10,"completion_tokens":3}}`)
+	}))
+	defer up.Close()
+	a := &App{cfg: Config{SiliconflowBaseURL: up.URL, SiliconflowAPIKey: "k"}, metrics: newMetrics()}
+	if _, _, err := a.chatCompletion(context.Background(), "m1", nil, false, nil); err == nil {
+		t.Fatal("expected upstream error")
+	}
+	fail = false
+	if _, _, err := a.chatCompletion(context.Background(), "m1", nil, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	for labels, want := range map[[2]string]float64{{"m1", "429"}: 1, {"m1", "200"}: 1} {
+		if got := a.metrics.counter("screensot_vision_calls_total", labels[0], labels[1]); got != want {
+			t.Errorf("calls %v = %v", labels, got)
+		}
+	}
+	if got := a.metrics.counter("screensot_vision_tokens_total", "m1", "prompt"); got != 10 {
+		t.Errorf("prompt tokens = %v", got)
+	}
+	if got := a.metrics.counter("screensot_vision_tokens_total", "m1", "completion"); got != 3 {
+		t.Errorf("completion tokens = %v", got)
+	}
+}
@@ -0,0 +1,115 @@
+package app
+
+import (
+	"context"
+	"net/url"
+	"strings"
+)
+
+// PipelineConfig 两阶段识别：视觉模型先转写截图中的文字与版式，再由（纯文本）推理模型基于转写稿作答
+type PipelineConfig struct {
+	// 默认是否启用；可通过 /one?pipeline=1 或 pipeline=0 按请求切换
+	Enabled bool `json:"enabled"`
+	// 转写模型（需支持图片）；为空时使用模型列表中的第一个
+	TranscribeModel string `json:"transcribe_model"`
+	// 转写提示词；为空时使用内置提示词
+	TranscribePrompt string `json:"transcribe_prompt"`
+	// 推理模型，可为纯文本模型；为空时沿用 profile / 全局 models
+	ReasonModels []string `json:"reason_models"`
+}
+
+// transcriptKey 转写卡片在流式事件与页面占位中的标识，避免与推理模型同名时冲突
+const transcriptKey = "#transcript"
+
+func transcribeSystemPrompt() string {
+	return "你是精确的截图转写助手。只输出截图中的文字内容，不作答、不解释、不添加任何额外文字。"
+}
+
+func defaultTranscribePrompt() string {
+	return "逐字转写图片中的全部文字，保留原有版式：按阅读顺序分行，题干、选项（A/B/C/D…）各占一行，表格用 | 分隔列，公式用纯文本或 LaTeX 表示，无法辨认的字符用 □ 代替。"
+}
+
+func reasonSystemPrompt() string {
+	return "你是严格的题目解析助手。严格输出 JSON 格式，不添加任何额外文字、前缀或解释。若转写内容非题目，请保持 question 为空字符串，answer 填写 \"非题目\"。"
+}
+
+func reasonPrompt() string {
+	return "以下是一张截图的文字转写（可能含少量识别错误）。请据此抽取题目原文并给出标准答案，严格返回 JSON：{\"question\":\"...\",\"answer\":\"...\"}。注意：不要输出任何说明、标题、模型名、Markdown、代码块或多余文本；不要在字段中加入‘题目：’、‘答案：’等前缀。若无法确定题目则 question 为空字符串。\n\n转写内容："
+}
+
+// pipelineFor 解析本次请求的两阶段配置：profile 覆盖全局，请求参数 pipeline 切换启用；未启用返回 nil
+func (a *App) pipelineFor(q url.Values, p *Profile) *PipelineConfig {
+	pl := a.conf().Pipeline
+	if p != nil && p.Pipeline != nil {
+		pl = *p.Pipeline
+	}
+	switch strings.ToLower(q.Get("pipeline")) {
+	case "1", "true", "on":
+		pl.Enabled = true
+	case "0", "false", "off":
+		pl.Enabled = false
+	}
+	if !pl.Enabled {
+		return nil
+	}
+	return &pl
+}
+
+// transcribeModel 转写阶段使用的模型
+func (a *App) transcribeModel(opts analyzeOptions) string {
+	if m := opts.pipeline.TranscribeModel; m != "" {
+		return m
+	}
+	if ms := a.profileModels(opts.profile); len(ms) > 0 {
+		return ms[0]
+	}
+	return ""
+}
+
+// transcribe 第一阶段：视觉模型转写截图（同样经过回退链、缓存、调度与用量记录）；tiles 非空时逐块转写后拼接
+func (a *App) transcribe(ctx context.Context, image int, shot screenshot, tiles []string, opts analyzeOptions) ModelAnswer {
+	m := a.transcribeModel(opts)
+	if m == "" {
+		return ModelAnswer{Model: m, Error: "未配置转写模型"}
+	}
+	prompt := opts.pipeline.TranscribePrompt
+	if prompt == "" {
+		prompt = defaultTranscribePrompt()
+	}
+	vr := visionRequest{
+		model:  m,
+		image:  shot.Base64,
+		caller: opts.requestID,
+		system: transcribeSystemPrompt(),
+		prompt: prompt,
+		raw:    true,
+	}
+	if opts.onDelta != nil {
+		vr.onDelta = func(text string) { opts.onDelta(image, transcriptKey, text) }
+	}
+	if opts.onQueue != nil {
+		vr.onQueue = func(pos int) { opts.onQueue(image, transcriptKey, pos) }
+	}
+	var ans ModelAnswer
+	if len(tiles) > 0 {
+		ans = mergeTileTranscripts(m, a.callTiles(ctx, vr, tiles, opts, shot.Client))
+	} else {
+		ans = a.callWithFallback(ctx, vr, opts, shot.Client)
+	}
+	if ans.Error == "" && ans.Raw == "" {
+		ans.Error = "转写结果为空"
+	}
+	if opts.onTranscript != nil {
+		opts.onTranscript(image, ans)
+	}
+	return ans
+}
+
+// reasonRequest 第二阶段：基于转写稿的纯文本请求，不再发送图片
+func reasonRequest(vr visionRequest, transcript string) visionRequest {
+	vr.image = ""
+	vr.system = reasonSystemPrompt()
+	vr.prompt = reasonPrompt()
+	vr.text = transcript
+	return vr
+}
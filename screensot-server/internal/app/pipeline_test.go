@@ -0,0 +1,73 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestPipelineTranscribeThenReason(t *testing.T) {
+	var reasonInput string
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model    string `json:"model"`
+			Messages []struct {
+				Content json.RawMessage `json:"content"`
+			} `json:"messages"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		switch req.Model {
+		case "vl":
+			fmt.Fprint(w, `{"choices":[{"message":{"content":"1+1=?\nA. 1\nB. 2"}}]}`)
+		case "text":
+			// 推理阶段应只收到纯文本（字符串 content），不含图片
+			var s string
+			if err := json.Unmarshal(req.Messages[1].Content, &s); err != nil {
+				http.Error(w, "expected text content", http.StatusBadRequest)
+				return
+			}
+			reasonInput = s
+			fmt.Fprint(w, `{"choices":[{"message":{"content":"{\"question\":\"1+1=?\",\"answer\":\"B\"}"}}]}`)
+		default:
+			http.Error(w, "unexpected model", http.StatusBadRequest)
+		}
+	}))
+	defer up.Close()
+
+	a := &App{cfg: Config{
+		Models:             []string{"vl"},
+		SiliconflowBaseURL: up.URL,
+		SiliconflowAPIKey:  "k",
+		Pipeline:           PipelineConfig{ReasonModels: []string{"text"}},
+	}}
+	if a.pipelineFor(url.Values{}, nil) != nil {
+		t.Fatal("pipeline should be disabled by default")
+	}
+	opts := analyzeOptions{pipeline: a.pipelineFor(url.Values{"pipeline": {"1"}}, nil)}
+	if opts.pipeline == nil || a.transcribeModel(opts) != "vl" {
+		t.Fatalf("pipeline = %+v", opts.pipeline)
+	}
+	items := a.analyzeImages(context.Background(), []screenshot{{Client: "c", Base64: "aW1n"}}, opts)
+	it := items[0]
+	if it.Transcript == nil || it.Transcript.Model != "vl" || !strings.Contains(it.Transcript.Raw, "B. 2") {
+		t.Fatalf("transcript = %+v", it.Transcript)
+	}
+	if len(it.ModelAnswers) != 1 || it.ModelAnswers[0].Model != "text" || it.ModelAnswers[0].Answer != "B" {
+		t.Fatalf("answers = %+v", it.ModelAnswers)
+	}
+	if !strings.Contains(reasonInput, "B. 2") {
+		t.Fatalf("reason input = %q", reasonInput)
+	}
+
+	// 转写失败时不再调用推理模型
+	opts.pipeline.TranscribeModel = "missing"
+	items = a.analyzeImages(context.Background(), []screenshot{{Client: "c", Base64: "aW1n"}}, opts)
+	if items[0].Transcript.Error == "" || items[0].ModelAnswers[0].Error == "" {
+		t.Fatalf("item = %+v", items[0])
+	}
+}
@@ -0,0 +1,234 @@
+package app
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+)
+
+// PreprocessConfig 发送给模型前的图片预处理；各步骤按字段顺序执行，零值表示跳过
+type PreprocessConfig struct {
+	// 裁剪区域（相对原图的 0~1 比例），便于适配不同分辨率的客户端
+	Crop *CropRect `json:"crop"`
+	// 最长边上限（像素），超出时等比缩小
+	MaxDimension int `json:"max_dimension"`
+	// 转为灰度
+	Grayscale bool `json:"grayscale"`
+	// 对比度系数，>1 增强、<1 减弱；0 或 1 表示不变
+	Contrast float64 `json:"contrast"`
+	// >0 时重新编码为 JPEG 并使用该质量（1~100），否则保持 PNG
+	JPEGQuality int `json:"jpeg_quality"`
+	// 编码后体积上限（字节）；超出时依次降低 JPEG 质量、缩小尺寸
+	MaxBytes int `json:"max_bytes"`
+}
+
+// CropRect 以比例表示的裁剪矩形
+type CropRect struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+// preparedImage 预处理后发送给模型的图片
+type preparedImage struct {
+	Base64 string
+	MIME   string
+	// 处理摘要，如 "1280x720 jpeg 183KB"；未处理时为空
+	Info string
+	// 对象存储签名地址；非空时代替 data URI 发送
+	URL string
+}
+
+// dataURL 构造 image_url 使用的 data URI
+func (p preparedImage) dataURL() string { return "data:" + p.MIME + ";base64," + p.Base64 }
+
+// imageURL 发送给模型的图片地址：优先签名地址
+func (p preparedImage) imageURL() string {
+	if p.URL != "" {
+		return p.URL
+	}
+	return p.dataURL()
+}
+
+// preprocessImage 按配置处理 base64 图片；pre 为 nil 时原样返回。原图不受影响，仍用于页面展示。
+func preprocessImage(b64 string, pre *PreprocessConfig) (preparedImage, error) {
+	if pre == nil {
+		return preparedImage{Base64: b64, MIME: "image/png"}, nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return preparedImage{}, fmt.Errorf("decode base64: %w", err)
+	}
+	src, _, err := image.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return preparedImage{}, fmt.Errorf("decode image: %w", err)
+	}
+	img := toNRGBA(src)
+	if pre.Crop != nil {
+		img = cropRatio(img, *pre.Crop)
+	}
+	if pre.MaxDimension > 0 {
+		img = fitWithin(img, pre.MaxDimension)
+	}
+	if pre.Grayscale || (pre.Contrast > 0 && pre.Contrast != 1) {
+		adjustTone(img, pre.Grayscale, pre.Contrast)
+	}
+
+	quality := pre.JPEGQuality
+	if quality > 100 {
+		quality = 100
+	}
+	out, mime, err := encodeImage(img, quality)
+	if err != nil {
+		return preparedImage{}, err
+	}
+	// 体积预算：PNG 超限时改用 JPEG；先降质量（最低 40），再每轮缩小 20%
+	for pre.MaxBytes > 0 && len(out) > pre.MaxBytes {
+		switch {
+		case quality <= 0:
+			quality = 85
+		case quality > 40:
+			quality -= 15
+			if quality < 40 {
+				quality = 40
+			}
+		default:
+			b := img.Bounds()
+			if b.Dx() < 64 || b.Dy() < 64 {
+				return preparedImage{}, fmt.Errorf("image exceeds size budget %d bytes", pre.MaxBytes)
+			}
+			img = resizeArea(img, b.Dx()*4/5, b.Dy()*4/5)
+		}
+		if out, mime, err = encodeImage(img, quality); err != nil {
+			return preparedImage{}, err
+		}
+	}
+	b := img.Bounds()
+	return preparedImage{
+		Base64: base64.StdEncoding.EncodeToString(out),
+		MIME:   mime,
+		Info:   fmt.Sprintf("%dx%d %s %dKB", b.Dx(), b.Dy(), mime[len("image/"):], (len(out)+1023)/1024),
+	}, nil
+}
+
+// encodeImage quality>0 编码为 JPEG，否则 PNG
+func encodeImage(img image.Image, quality int) ([]byte, string, error) {
+	var buf bytes.Buffer
+	if quality > 0 {
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+			return nil, "", fmt.Errorf("encode jpeg: %w", err)
+		}
+		return buf.Bytes(), "image/jpeg", nil
+	}
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, "", fmt.Errorf("encode png: %w", err)
+	}
+	return buf.Bytes(), "image/png", nil
+}
+
+func toNRGBA(src image.Image) *image.NRGBA {
+	b := src.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), src, b.Min, draw.Src)
+	return dst
+}
+
+// cropRatio 按比例裁剪；区域越界时截断到图片范围，结果为空则返回原图
+func cropRatio(img *image.NRGBA, c CropRect) *image.NRGBA {
+	b := img.Bounds()
+	r := image.Rect(
+		int(c.X*float64(b.Dx())), int(c.Y*float64(b.Dy())),
+		int((c.X+c.Width)*float64(b.Dx())), int((c.Y+c.Height)*float64(b.Dy())),
+	).Intersect(b)
+	if r.Empty() {
+		return img
+	}
+	return toNRGBA(img.SubImage(r))
+}
+
+// fitWithin 等比缩小至最长边不超过 max
+func fitWithin(img *image.NRGBA, max int) *image.NRGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= max && h <= max {
+		return img
+	}
+	if w >= h {
+		h = h * max / w
+		w = max
+	} else {
+		w = w * max / h
+		h = max
+	}
+	return resizeArea(img, w, h)
+}
+
+// resizeArea 区域平均缩小（box filter），对截图中的细小文字比最近邻更清晰
+func resizeArea(src *image.NRGBA, w, h int) *image.NRGBA {
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+	sb := src.Bounds()
+	sw, sh := sb.Dx(), sb.Dy()
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := y*sh/h, (y+1)*sh/h
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < w; x++ {
+			x0, x1 := x*sw/w, (x+1)*sw/w
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var r, g, bl, al, n uint32
+			for sy := y0; sy < y1; sy++ {
+				off := sy*src.Stride + x0*4
+				for sx := x0; sx < x1; sx++ {
+					r += uint32(src.Pix[off])
+					g += uint32(src.Pix[off+1])
+					bl += uint32(src.Pix[off+2])
+					al += uint32(src.Pix[off+3])
+					off += 4
+					n++
+				}
+			}
+			dst.SetNRGBA(x, y, color.NRGBA{uint8(r / n), uint8(g / n), uint8(bl / n), uint8(al / n)})
+		}
+	}
+	return dst
+}
+
+// adjustTone 原地转灰度并调整对比度（以 128 为中心线性拉伸）
+func adjustTone(img *image.NRGBA, gray bool, contrast float64) {
+	if contrast <= 0 {
+		contrast = 1
+	}
+	adj := func(v float64) uint8 {
+		v = (v-128)*contrast + 128
+		if v < 0 {
+			return 0
+		}
+		if v > 255 {
+			return 255
+		}
+		return uint8(v + 0.5)
+	}
+	for i := 0; i+3 < len(img.Pix); i += 4 {
+		r, g, b := float64(img.Pix[i]), float64(img.Pix[i+1]), float64(img.Pix[i+2])
+		if gray {
+			l := 0.299*r + 0.587*g + 0.114*b
+			r, g, b = l, l, l
+		}
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2] = adj(r), adj(g), adj(b)
+	}
+}
@@ -0,0 +1,77 @@
+package app
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func testPNG(t *testing.T, w, h int) string {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	seed := uint32(1)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			seed = seed*1664525 + 1013904223 // 伪随机噪声，避免 PNG 压缩得过小
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x), uint8(y), uint8(seed >> 24), 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func decodeSize(t *testing.T, b64 string) (int, int) {
+	t.Helper()
+	raw, _ := base64.StdEncoding.DecodeString(b64)
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg.Width, cfg.Height
+}
+
+func TestPreprocessNil(t *testing.T) {
+	src := testPNG(t, 8, 8)
+	got, err := preprocessImage(src, nil)
+	if err != nil || got.Base64 != src || got.MIME != "image/png" || got.Info != "" {
+		t.Fatalf("got %+v, %v", got, err)
+	}
+}
+
+func TestPreprocessCropScaleJPEG(t *testing.T) {
+	src := testPNG(t, 400, 200)
+	got, err := preprocessImage(src, &PreprocessConfig{
+		Crop:         &CropRect{X: 0.5, Y: 0, Width: 0.5, Height: 1},
+		MaxDimension: 100,
+		Grayscale:    true,
+		Contrast:     1.5,
+		JPEGQuality:  80,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.MIME != "image/jpeg" {
+		t.Fatalf("mime = %s", got.MIME)
+	}
+	if w, h := decodeSize(t, got.Base64); w != 100 || h != 100 {
+		t.Fatalf("size = %dx%d", w, h)
+	}
+}
+
+func TestPreprocessSizeBudget(t *testing.T) {
+	src := testPNG(t, 256, 256)
+	got, err := preprocessImage(src, &PreprocessConfig{MaxBytes: 20000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := base64.StdEncoding.DecodeString(got.Base64)
+	if len(raw) > 20000 || got.MIME != "image/jpeg" {
+		t.Fatalf("len = %d, mime = %s", len(raw), got.MIME)
+	}
+}
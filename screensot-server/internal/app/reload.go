@@ -0,0 +1,155 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// configPollInterval 检查配置文件是否变化的间隔；连续两次检查内容不变才加载，避免读到写了一半的文件
+const configPollInterval = 2 * time.Second
+
+// conf 当前生效的配置快照。热加载以原子方式整体替换快照，进行中的请求不受影响；调用方不得修改返回值
+func (a *App) conf() *Config {
+	if c := a.live.Load(); c != nil {
+		return c
+	}
+	return &a.cfg
+}
+
+// reloadConfig 重新读取并校验配置文件，失败时保留当前配置。
+// 模型、提供方地址与密钥、识别方案与提示词、模板、预算、题库、日志等立即生效；
+// 只在启动时读取的项（见 carryRestartOnly）沿用旧值并告警。已连接的截屏客户端不受影响
+func (a *App) reloadConfig(reason string) error {
+	a.reloadMu.Lock()
+	defer a.reloadMu.Unlock()
+	old := a.conf()
+	next, err := readConfig(old.path)
+	system := auditActor{Name: "system"}
+	if err != nil {
+		slog.Error("config reload failed, keeping current config", "reason", reason, "err", err)
+		a.recordAudit(system, auditEntry{Action: "config.reload", Outcome: auditError, Detail: err.Error()})
+		return err
+	}
+	restart := carryRestartOnly(*old, &next)
+	a.live.Store(&next)
+	setupLogging(next.Log)
+	detail := reason
+	if n := a.reloadTokens(next.TCP.Auth.Tokens); n > 0 {
+		detail += fmt.Sprintf("; closed %d connections with invalid tokens", n)
+	}
+	if len(restart) > 0 {
+		slog.Warn("config changes that require a restart were not applied", "fields", restart)
+		detail += "; restart required for " + strings.Join(restart, ", ")
+	}
+	slog.Info("config reloaded", "reason", reason, "path", next.path, "models", next.Models, "key", maskSecret(next.SiliconflowAPIKey), "template", next.TemplatePath)
+	a.recordAudit(system, auditEntry{Action: "config.reload", Models: next.Models, Outcome: auditOK, Detail: detail})
+	return nil
+}
+
+// carryRestartOnly 只在启动时读取的配置项（监听与 HTTP 参数、截屏通道 TLS 与认证限流参数、登录认证、存储后端、缓存、调度并发、数据目录）
+// 沿用旧值，使诊断页与实际运行一致；返回有变化而未生效的项
+func carryRestartOnly(old Config, next *Config) []string {
+	var changed []string
+	diff := func(name string, o, n interface{}) {
+		if !reflect.DeepEqual(o, n) {
+			changed = append(changed, name)
+		}
+	}
+	// 保留策略每次清理时读取，可热加载；存储后端与其余参数在启动时创建
+	oldStorage, nextStorage := old.Storage, next.Storage
+	oldStorage.Retention, nextStorage.Retention = RetentionConfig{}, RetentionConfig{}
+	diff("server", old.Server, next.Server)
+	diff("tcp.tls", old.TCP.TLS, next.TCP.TLS)
+	// 令牌列表可热加载（见 reloadTokens），失败限流参数在启动时读取
+	oldAuth, nextAuth := old.TCP.Auth, next.TCP.Auth
+	oldAuth.Tokens, nextAuth.Tokens = nil, nil
+	diff("tcp.auth limits", oldAuth, nextAuth)
+	diff("http_auth", old.HTTPAuth, next.HTTPAuth)
+	diff("storage", oldStorage, nextStorage)
+	diff("cache", old.Cache, next.Cache)
+	diff("scheduler", old.Scheduler, next.Scheduler)
+	diff("data_dir", old.DataDir, next.DataDir)
+	// 提供方的地址与密钥可热加载，并发与限流由启动时创建的调度器执行
+	for name, p := range next.Providers {
+		o := old.Providers[name]
+		if o.MaxConcurrency != p.MaxConcurrency || o.RPM != p.RPM || o.TPM != p.TPM {
+			changed = append(changed, "providers."+name+" limits")
+		}
+	}
+
+	retention := next.Storage.Retention
+	tokens := next.TCP.Auth.Tokens
+	next.Server, next.TCP.TLS, next.TCP.Auth, next.HTTPAuth = old.Server, old.TCP.TLS, old.TCP.Auth, old.HTTPAuth
+	next.TCP.Auth.Tokens = tokens
+	next.Storage, next.Cache, next.Scheduler, next.DataDir = old.Storage, old.Cache, old.Scheduler, old.DataDir
+	next.Storage.Retention = retention
+	sort.Strings(changed)
+	return changed
+}
+
+// watchConfig 收到 SIGHUP 时立即热加载，并轮询配置文件的修改时间与大小，变化稳定后热加载
+func (a *App) watchConfig(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		path := a.conf().path
+		last, pending := configStamp(path), false
+		t := time.NewTicker(configPollInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				_ = a.reloadConfig("SIGHUP")
+				last, pending = configStamp(path), false
+			case <-t.C:
+				if s := configStamp(path); s != last {
+					last, pending = s, true
+				} else if pending {
+					pending = false
+					_ = a.reloadConfig("file changed")
+				}
+			}
+		}
+	}()
+}
+
+// configStamp 文件的修改时间与大小；文件不存在时为空串
+func configStamp(path string) string {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%d/%d", fi.ModTime().UnixNano(), fi.Size())
+}
+
+// ConfigCommand 实现 server config check [配置文件]：严格解析并校验配置（未指定时按启动时的规则查找），有问题时返回错误
+func ConfigCommand(args []string) error {
+	if len(args) == 0 || args[0] != "check" || len(args) > 2 {
+		return errors.New("usage: server config check [config.json]")
+	}
+	path := resolveConfigPath()
+	if len(args) == 2 {
+		path = args[1]
+	}
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	c, err := readConfig(path)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s: ok (models: %s)\n", path, strings.Join(c.Models, ", "))
+	return nil
+}
@@ -0,0 +1,99 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigFileStrict(t *testing.T) {
+	dir := t.TempDir()
+	for body, want := range map[string]string{
+		"{\n  \"models\": [\"a\"],\n  \"modles\": [\"b\"]\n}":                `:3:3: unknown field "modles"`,
+		"{\n  \"stream\": \"yes\"\n}":                                        `:2:18: stream: expected bool, got JSON string`,
+		"{\n  \"models\": [\"a\"]\n  \"stream\": true\n}":                    `:3:3: invalid character`,
+		"{\"models\": [\"a\"]}\n{}":                                          `unexpected content after the top-level object`,
+		"{\"cache\": {\"backend\": \"redis\"}, \"bank\": {\"mode\": \"x\"}}": "cache.backend: unknown value \"redis\" (allowed: memory, disk, off, none)\n  - bank.mode",
+	} {
+		path := filepath.Join(dir, "config.json")
+		if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		_, err := readConfig(path)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: err = %v, want %q", body, err, want)
+		}
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	t.Setenv("VISION_MODELS", "")
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	write := func(body string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"models": ["m1"], "siliconflow_api_key": "k1", "server": {"http_addr": ":1"}}`)
+	cfg, err := readConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &App{state: &state{}, cfg: cfg}
+
+	write(`{"models": ["m2", "m3"], "siliconflow_api_key": "k2", "server": {"http_addr": ":2"}, "storage": {"retention": {"max_age_days": 7}}}`)
+	if err := a.reloadConfig("test"); err != nil {
+		t.Fatal(err)
+	}
+	c := a.conf()
+	if strings.Join(c.Models, ",") != "m2,m3" || c.SiliconflowAPIKey != "k2" || c.Storage.Retention.MaxAgeDays != 7 {
+		t.Fatalf("reloadable fields not applied: %+v", c)
+	}
+	if c.Server.HTTPAddr != ":1" {
+		t.Fatalf("listen address changed without restart: %q", c.Server.HTTPAddr)
+	}
+
+	// 校验失败时保留当前配置
+	write(`{"models": ["m4"], "unknown": 1}`)
+	if err := a.reloadConfig("test"); err == nil {
+		t.Fatal("expected error for unknown field")
+	}
+	if a.conf() != c {
+		t.Fatal("failed reload replaced the config")
+	}
+}
+
+func TestCarryRestartOnly(t *testing.T) {
+	old := Config{Server: ServerConfig{HTTPAddr: ":1"}, Providers: map[string]ProviderConfig{"p": {BaseURL: "u1", RPM: 10}}}
+	next := Config{Server: ServerConfig{HTTPAddr: ":2"}, Providers: map[string]ProviderConfig{"p": {BaseURL: "u2", RPM: 20}}}
+	changed := carryRestartOnly(old, &next)
+	if strings.Join(changed, ",") != "providers.p limits,server" || next.Server.HTTPAddr != ":1" || next.Providers["p"].BaseURL != "u2" {
+		t.Fatalf("changed %v, next %+v", changed, next)
+	}
+}
+
+func TestDefaultRetention(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	for body, want := range map[string]int{
+		`{"models": ["m"]}`: 0,
+		`{"models": ["m"], "storage": {"backend": "local"}}`:                                     defaultRetentionDays,
+		`{"models": ["m"], "storage": {"backend": "local", "retention": {"max_total_mb": 100}}}`: 0,
+		`{"models": ["m"], "storage": {"backend": "local", "retention": {"max_age_days": -1}}}`:  -1,
+		`{"models": ["m"], "storage": {"backend": "local", "retention": {"max_age_days": 7}}}`:   7,
+	} {
+		if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := readConfig(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := cfg.Storage.Retention.MaxAgeDays; got != want {
+			t.Errorf("%s: max_age_days = %d, want %d", body, got, want)
+		}
+	}
+}
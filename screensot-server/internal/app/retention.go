@@ -0,0 +1,288 @@
+package app
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultRetentionDays 开启保存但未配置任何保留限制时的默认保留天数
+const defaultRetentionDays = 30
+
+// RetentionConfig 截图保留策略；各项为 0 表示不限，置顶（pinned）的截图不受影响
+type RetentionConfig struct {
+	// 超过该天数的截图删除；全部限制都未配置时默认 30，负数表示永久保留
+	MaxAgeDays int `json:"max_age_days"`
+	// 总大小上限（MB），超出时从最旧的截图开始删除
+	MaxTotalMB int `json:"max_total_mb"`
+	// 每个客户端最多保留的截图数
+	MaxPerClient int `json:"max_per_client"`
+	// 每个会话最多保留的截图数（未指定会话的截图不计）
+	MaxPerSession int `json:"max_per_session"`
+	// 后台清理间隔（分钟），默认 60
+	IntervalMinutes int `json:"interval_minutes"`
+}
+
+func (r RetentionConfig) enabled() bool {
+	return r.MaxAgeDays > 0 || r.MaxTotalMB > 0 || r.MaxPerClient > 0 || r.MaxPerSession > 0
+}
+
+func (r RetentionConfig) interval() time.Duration {
+	if r.IntervalMinutes <= 0 {
+		return time.Hour
+	}
+	return time.Duration(r.IntervalMinutes) * time.Minute
+}
+
+// GCItem 一张被清理（或将被清理）的截图
+type GCItem struct {
+	ID      string    `json:"id"`
+	Time    time.Time `json:"time"`
+	Client  string    `json:"client"`
+	Session string    `json:"session,omitempty"`
+	Bytes   int64     `json:"bytes"`
+	// 触发清理的规则：age / client / session / size
+	Reason string `json:"reason"`
+}
+
+// GCReport 一次清理的结果
+type GCReport struct {
+	DryRun  bool      `json:"dry_run"`
+	Time    time.Time `json:"time"`
+	Scanned int       `json:"scanned"`
+	Pinned  int       `json:"pinned"`
+	Removed []GCItem  `json:"removed"`
+	// 释放与剩余的字节数（图片加元数据）
+	FreedBytes int64 `json:"freed_bytes"`
+	KeptBytes  int64 `json:"kept_bytes"`
+	// 删除失败的截图
+	Errors []string `json:"errors,omitempty"`
+	// 删除的签名已过期的 uploads/ 对象数（预演时不清理）
+	UploadsRemoved int `json:"uploads_removed,omitempty"`
+}
+
+// gcCapture 参与清理计划的截图摘要
+type gcCapture struct {
+	Capture
+	bytes int64
+}
+
+// all 读取存储中的全部截图元数据；无法读取的记录跳过
+func (s *captureStore) all() ([]Capture, error) {
+	items, err := s.scan()
+	out := make([]Capture, 0, len(items))
+	for _, it := range items {
+		out = append(out, it.Capture)
+	}
+	return out, err
+}
+
+// scan 读取全部截图元数据，bytes 为元数据本身的字节数；无法读取的记录跳过
+func (s *captureStore) scan() ([]gcCapture, error) {
+	keys, err := s.blobs.List("captures/")
+	var out []gcCapture
+	for _, k := range keys {
+		id := strings.TrimSuffix(strings.TrimPrefix(k, "captures/"), ".json")
+		if !validID(id) {
+			continue
+		}
+		b, err := s.blobs.Get(k)
+		if err != nil {
+			continue
+		}
+		c, err := decodeCapture(id, b)
+		if err != nil {
+			continue
+		}
+		out = append(out, gcCapture{Capture: c, bytes: int64(len(b))})
+	}
+	return out, err
+}
+
+// size 图片与元数据的字节数；旧记录未登记图片大小时读取图片
+func (s *captureStore) size(c gcCapture) int64 {
+	n := c.ImageBytes
+	if n == 0 {
+		if img, err := s.image(c.ID); err == nil {
+			n = int64(len(img))
+		}
+	}
+	return n + c.bytes
+}
+
+// remove 先删元数据再删图片与缩略图，与 save 的顺序相反，中途失败也不会留下指向缺失图片的元数据
+func (s *captureStore) remove(id string) error {
+	if !validID(id) {
+		return errBlobNotFound
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.blobs.Delete(captureKey(id)); err != nil {
+		return err
+	}
+	if err := s.blobs.Delete(imageKey(id)); err != nil {
+		return err
+	}
+	for _, w := range thumbWidths {
+		if err := s.blobs.Delete(thumbKey(id, w)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// planGC 按保留策略选出待删除的截图：先按年龄，再按客户端/会话数量（保留最新的），最后按总大小从最旧的删起
+func planGC(caps []gcCapture, r RetentionConfig, now time.Time) (removed []GCItem, pinned int, kept int64) {
+	sort.Slice(caps, func(i, j int) bool { return caps[i].Time.After(caps[j].Time) })
+	drop := func(c gcCapture, reason string) {
+		removed = append(removed, GCItem{ID: c.ID, Time: c.Time, Client: c.Client, Session: c.Session, Bytes: c.bytes, Reason: reason})
+	}
+	perClient := map[string]int{}
+	perSession := map[string]int{}
+	var rest []gcCapture
+	for _, c := range caps {
+		if c.Pinned {
+			pinned++
+			kept += c.bytes
+			continue
+		}
+		switch {
+		case r.MaxAgeDays > 0 && now.Sub(c.Time) > time.Duration(r.MaxAgeDays)*24*time.Hour:
+			drop(c, "age")
+			continue
+		case r.MaxPerClient > 0 && perClient[c.Client] >= r.MaxPerClient:
+			drop(c, "client")
+			continue
+		case r.MaxPerSession > 0 && c.Session != "" && perSession[c.Session] >= r.MaxPerSession:
+			drop(c, "session")
+			continue
+		}
+		perClient[c.Client]++
+		if c.Session != "" {
+			perSession[c.Session]++
+		}
+		rest = append(rest, c)
+	}
+	limit := int64(r.MaxTotalMB) << 20
+	for _, c := range rest {
+		if limit > 0 && kept+c.bytes > limit {
+			drop(c, "size")
+			continue
+		}
+		kept += c.bytes
+	}
+	return removed, pinned, kept
+}
+
+// runGC 执行一次清理；dryRun 时只生成报告
+func (a *App) runGC(dryRun bool) (GCReport, error) {
+	rep := GCReport{DryRun: dryRun, Time: time.Now(), Removed: []GCItem{}}
+	items, err := a.captures.scan()
+	if err != nil {
+		return rep, err
+	}
+	for i := range items {
+		items[i].bytes = a.captures.size(items[i])
+	}
+	rep.Scanned = len(items)
+	removed, pinned, kept := planGC(items, a.conf().Storage.Retention, rep.Time)
+	rep.Pinned, rep.KeptBytes = pinned, kept
+	for _, it := range removed {
+		if !dryRun {
+			if err := a.captures.remove(it.ID); err != nil {
+				rep.Errors = append(rep.Errors, fmt.Sprintf("%s: %v", it.ID, err))
+				rep.KeptBytes += it.Bytes
+				continue
+			}
+			if a.index != nil {
+				a.index.remove(it.ID)
+			}
+			slog.Info("gc: removed capture", "id", it.ID, "client", it.Client, "time", it.Time.Format(time.RFC3339), "reason", it.Reason, "bytes", it.Bytes)
+		}
+		rep.Removed = append(rep.Removed, it)
+		rep.FreedBytes += it.Bytes
+	}
+	if !dryRun {
+		n, err := a.sweepUploads()
+		rep.UploadsRemoved = n
+		if err != nil {
+			rep.Errors = append(rep.Errors, fmt.Sprintf("uploads/: %v", err))
+		}
+	}
+	return rep, nil
+}
+
+// sweepUploads 删除签名已过期的 uploads/ 对象，不受保留策略开关影响
+func (a *App) sweepUploads() (int, error) {
+	n, err := a.captures.sweepUploads(a.conf().Storage.presignTTL(), time.Now())
+	if n > 0 {
+		slog.Info("gc: removed expired presign uploads", "count", n)
+	}
+	return n, err
+}
+
+// startGC 按保留策略周期性清理，并删除签名已过期的 uploads/ 对象；关闭存储时不启动。保留策略可热加载，
+// 每轮读取当前配置：未配置策略时只清理 uploads/，清理间隔变化时重置定时器
+func (a *App) startGC() {
+	if a.captures == nil {
+		return
+	}
+	go func() {
+		if !a.conf().Storage.Retention.enabled() {
+			slog.Warn("capture storage has no retention limit (max_age_days < 0); saved screenshots are never deleted")
+		}
+		interval := a.conf().Storage.Retention.interval()
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			r := a.conf().Storage.Retention
+			if r.enabled() {
+				rep, err := a.runGC(false)
+				if err != nil {
+					slog.Warn("capture gc", "err", err)
+				} else if len(rep.Removed) > 0 || len(rep.Errors) > 0 {
+					slog.Info("gc finished", "scanned", rep.Scanned, "removed", len(rep.Removed), "freed_bytes", rep.FreedBytes, "errors", len(rep.Errors))
+				}
+			} else if _, err := a.sweepUploads(); err != nil {
+				slog.Warn("presign uploads gc", "err", err)
+			}
+			if r.interval() != interval {
+				interval = r.interval()
+				t.Reset(interval)
+			}
+			<-t.C
+		}
+	}()
+}
+
+// handleAPIGC GET 返回按当前保留策略的清理预演报告（不删除）；POST 立即执行一次清理
+func (a *App) handleAPIGC(w http.ResponseWriter, r *http.Request) {
+	if a.captures == nil {
+		writeJSONError(w, http.StatusNotFound, "capture storage disabled")
+		return
+	}
+	var dryRun bool
+	switch r.Method {
+	case http.MethodGet:
+		dryRun = true
+	case http.MethodPost:
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	rep, err := a.runGC(dryRun)
+	if err != nil {
+		if !dryRun {
+			a.auditRequest(r, "captures.gc", auditError, err.Error())
+		}
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !dryRun {
+		a.auditRequest(r, "captures.gc", auditOK, fmt.Sprintf("删除 %d 张截图，释放 %d 字节", len(rep.Removed), rep.FreedBytes))
+	}
+	writeJSON(w, http.StatusOK, rep)
+}
@@ -0,0 +1,113 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPlanGC(t *testing.T) {
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	at := func(days int) time.Time { return now.AddDate(0, 0, -days) }
+	caps := []gcCapture{
+		{Capture: Capture{ID: "old", Time: at(40), Client: "a"}, bytes: 10},
+		{Capture: Capture{ID: "pinned", Time: at(90), Client: "a", Pinned: true}, bytes: 10},
+		{Capture: Capture{ID: "a1", Time: at(1), Client: "a"}, bytes: 10},
+		{Capture: Capture{ID: "a2", Time: at(2), Client: "a"}, bytes: 10},
+		{Capture: Capture{ID: "a3", Time: at(3), Client: "a"}, bytes: 10},
+		{Capture: Capture{ID: "s1", Time: at(1), Client: "b", Session: "x"}, bytes: 10},
+		{Capture: Capture{ID: "s2", Time: at(2), Client: "b", Session: "x"}, bytes: 10},
+	}
+	removed, pinned, kept := planGC(caps, RetentionConfig{MaxAgeDays: 30, MaxPerClient: 2, MaxPerSession: 1}, now)
+	reasons := map[string]string{}
+	for _, it := range removed {
+		reasons[it.ID] = it.Reason
+	}
+	want := map[string]string{"old": "age", "a3": "client", "s2": "session"}
+	if len(reasons) != len(want) {
+		t.Fatalf("removed = %+v", removed)
+	}
+	for id, r := range want {
+		if reasons[id] != r {
+			t.Fatalf("removed = %+v, want %s:%s", removed, id, r)
+		}
+	}
+	if pinned != 1 || kept != 40 {
+		t.Fatalf("pinned = %d, kept = %d", pinned, kept)
+	}
+
+	// 总大小上限：置顶的截图计入大小但不删除，剩余空间留给最新的截图
+	removed, _, kept = planGC(caps, RetentionConfig{MaxTotalMB: 1}, now)
+	if len(removed) != 0 || kept != 70 {
+		t.Fatalf("size under limit: removed = %+v, kept = %d", removed, kept)
+	}
+	for i := range caps {
+		caps[i].bytes = 300 << 10
+	}
+	removed, _, _ = planGC(caps, RetentionConfig{MaxTotalMB: 1}, now)
+	if len(removed) != 4 || removed[0].Reason != "size" {
+		t.Fatalf("size over limit: removed = %+v", removed)
+	}
+	for _, it := range removed {
+		if it.ID == "pinned" || it.ID == "a1" || it.ID == "s1" {
+			t.Fatalf("newest or pinned capture removed: %+v", removed)
+		}
+	}
+}
+
+func TestGCEndpoint(t *testing.T) {
+	a := &App{captures: newCaptureStore(StorageConfig{Backend: "local", Dir: t.TempDir()})}
+	a.cfg.Storage.Retention = RetentionConfig{MaxPerClient: 1}
+	a.index = buildSearchIndex(a.captures)
+	items := []ImageEntry{
+		{Client: "pc", Base64: "aW1n", ModelAnswers: []ModelAnswer{{Model: "m", Question: "甲题", Answer: "A"}}},
+		{Client: "pc", Base64: "aW1n", ModelAnswers: []ModelAnswer{{Model: "m", Question: "乙题", Answer: "B"}}},
+		{Client: "pc", Base64: "aW1n", ModelAnswers: []ModelAnswer{{Model: "m", Question: "丙题", Answer: "C"}}},
+	}
+	a.saveCaptures(items, analyzeOptions{requestID: "r"})
+	w := httptest.NewRecorder()
+	a.handleAPICapture(w, httptest.NewRequest(http.MethodPatch, "/api/v1/capture?id="+items[0].ID, strings.NewReader(`{"pinned":true}`)))
+	if w.Code != 200 {
+		t.Fatalf("pin: %d %s", w.Code, w.Body.String())
+	}
+
+	gc := func(method string) GCReport {
+		w := httptest.NewRecorder()
+		a.handleAPIGC(w, httptest.NewRequest(method, "/api/v1/captures/gc", nil))
+		var rep GCReport
+		if err := json.Unmarshal(w.Body.Bytes(), &rep); err != nil || w.Code != 200 {
+			t.Fatalf("%s gc: %d %s", method, w.Code, w.Body.String())
+		}
+		return rep
+	}
+	rep := gc(http.MethodGet)
+	if !rep.DryRun || rep.Scanned != 3 || rep.Pinned != 1 || len(rep.Removed) != 1 {
+		t.Fatalf("dry run = %+v", rep)
+	}
+	victim := rep.Removed[0].ID
+	if _, err := a.captures.get(victim); err != nil {
+		t.Fatalf("dry run deleted capture: %v", err)
+	}
+
+	rep = gc(http.MethodPost)
+	if rep.DryRun || len(rep.Removed) != 1 || rep.Removed[0].ID != victim || rep.FreedBytes == 0 {
+		t.Fatalf("gc = %+v", rep)
+	}
+	if _, err := a.captures.get(victim); err != errBlobNotFound {
+		t.Fatalf("metadata after gc: %v", err)
+	}
+	if _, err := a.captures.image(victim); err != errBlobNotFound {
+		t.Fatalf("image after gc: %v", err)
+	}
+	for _, r := range a.index.search(SearchQuery{}) {
+		if r.ID == victim {
+			t.Fatal("removed capture still indexed")
+		}
+	}
+	if rep := gc(http.MethodGet); len(rep.Removed) != 0 || rep.Scanned != 2 {
+		t.Fatalf("after gc = %+v", rep)
+	}
+}
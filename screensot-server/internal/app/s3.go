@@ -0,0 +1,297 @@
+package app
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3Store S3 兼容对象存储（AWS S3、MinIO、七牛 Kodo 的 S3 兼容接口等），使用 AWS Signature V4 签名
+type s3Store struct {
+	// 服务地址，如 https://s3.cn-east-1.qiniucs.com
+	endpoint *url.URL
+	region   string
+	bucket   string
+	// 对象 key 前缀，如 "screenshot/"
+	prefix    string
+	accessKey string
+	secretKey string
+	// 路径风格 endpoint/bucket/key；否则为虚拟主机风格 bucket.endpoint/key
+	pathStyle bool
+	client    *http.Client
+	now       func() time.Time
+}
+
+// newS3Store 按配置创建；backend=qiniu 且未配置 endpoint 时按 region 使用七牛 Kodo 的 S3 兼容域名
+func newS3Store(c StorageConfig, qiniu bool) (*s3Store, error) {
+	endpoint := c.Endpoint
+	region := c.Region
+	if qiniu {
+		if region == "" {
+			region = "cn-east-1"
+		}
+		if endpoint == "" {
+			endpoint = "https://s3." + region + ".qiniucs.com"
+		}
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	if endpoint == "" || c.Bucket == "" {
+		return nil, fmt.Errorf("storage endpoint and bucket are required")
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid storage endpoint %q", endpoint)
+	}
+	prefix := strings.Trim(c.Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return &s3Store{
+		endpoint:  u,
+		region:    region,
+		bucket:    c.Bucket,
+		prefix:    prefix,
+		accessKey: c.AccessKey,
+		secretKey: c.SecretKey,
+		pathStyle: c.PathStyle,
+		client:    &http.Client{Timeout: 60 * time.Second},
+		now:       time.Now,
+	}, nil
+}
+
+// objectURL 对象（key 为空时为 bucket 根）的地址
+func (s *s3Store) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	p := strings.TrimSuffix(u.Path, "/")
+	if s.pathStyle {
+		p += "/" + s.bucket
+	} else {
+		u.Host = s.bucket + "." + u.Host
+	}
+	u.Path = p + "/" + s.prefix + key
+	u.RawPath = s3Escape(u.Path, false)
+	u.RawQuery = ""
+	return &u
+}
+
+func (s *s3Store) Put(key string, data []byte) error {
+	resp, err := s.do(http.MethodPut, s.objectURL(key), data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return s3Error(resp)
+}
+
+func (s *s3Store) Get(key string) ([]byte, error) {
+	resp, err := s.do(http.MethodGet, s.objectURL(key), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errBlobNotFound
+	}
+	if err := s3Error(resp); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func (s *s3Store) Delete(key string) error {
+	resp, err := s.do(http.MethodDelete, s.objectURL(key), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	return s3Error(resp)
+}
+
+// List 使用 ListObjectsV2 分页列出
+func (s *s3Store) List(prefix string) ([]string, error) {
+	var keys []string
+	token := ""
+	for {
+		u := s.objectURL("")
+		u.Path = strings.TrimSuffix(u.Path, s.prefix)
+		u.RawPath = s3Escape(u.Path, false)
+		q := url.Values{"list-type": {"2"}, "prefix": {s.prefix + prefix}}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		u.RawQuery = s3Query(q)
+		resp, err := s.do(http.MethodGet, u, nil)
+		if err != nil {
+			return keys, err
+		}
+		var out struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = s3Error(resp)
+		if err == nil {
+			err = xml.NewDecoder(resp.Body).Decode(&out)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return keys, err
+		}
+		for _, c := range out.Contents {
+			keys = append(keys, strings.TrimPrefix(c.Key, s.prefix))
+		}
+		if !out.IsTruncated || out.NextContinuationToken == "" {
+			break
+		}
+		token = out.NextContinuationToken
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// PresignGet 生成限时可访问的 GET 地址，供模型服务直接拉取图片
+func (s *s3Store) PresignGet(key string, ttl time.Duration) (string, error) {
+	u := s.objectURL(key)
+	t := s.now().UTC()
+	q := url.Values{
+		"X-Amz-Algorithm":     {"AWS4-HMAC-SHA256"},
+		"X-Amz-Credential":    {s.accessKey + "/" + s.scope(t)},
+		"X-Amz-Date":          {t.Format("20060102T150405Z")},
+		"X-Amz-Expires":       {fmt.Sprint(int(ttl / time.Second))},
+		"X-Amz-SignedHeaders": {"host"},
+	}
+	u.RawQuery = s3Query(q)
+	sig := s.signature(t, http.MethodGet, u, map[string]string{"host": u.Host}, "UNSIGNED-PAYLOAD")
+	u.RawQuery += "&X-Amz-Signature=" + sig
+	return u.String(), nil
+}
+
+// do 发送带 Authorization 签名头的请求
+func (s *s3Store) do(method string, u *url.URL, body []byte) (*http.Response, error) {
+	t := s.now().UTC()
+	sum := sha256.Sum256(body)
+	payload := hex.EncodeToString(sum[:])
+	headers := map[string]string{
+		"host":                 u.Host,
+		"x-amz-content-sha256": payload,
+		"x-amz-date":           t.Format("20060102T150405Z"),
+	}
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range headers {
+		if k != "host" {
+			req.Header.Set(k, v)
+		}
+	}
+	names := sortedKeys(headers)
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, s.scope(t), strings.Join(names, ";"), s.signature(t, method, u, headers, payload)))
+	return s.client.Do(req)
+}
+
+func (s *s3Store) scope(t time.Time) string {
+	return t.Format("20060102") + "/" + s.region + "/s3/aws4_request"
+}
+
+// signature 计算 SigV4 签名；headers 的键为小写
+func (s *s3Store) signature(t time.Time, method string, u *url.URL, headers map[string]string, payload string) string {
+	names := sortedKeys(headers)
+	var canonHeaders strings.Builder
+	for _, k := range names {
+		canonHeaders.WriteString(k + ":" + strings.TrimSpace(headers[k]) + "\n")
+	}
+	q, _ := url.ParseQuery(u.RawQuery)
+	canon := strings.Join([]string{
+		method,
+		s3Escape(u.Path, false),
+		s3Query(q),
+		canonHeaders.String(),
+		strings.Join(names, ";"),
+		payload,
+	}, "\n")
+	sum := sha256.Sum256([]byte(canon))
+	toSign := strings.Join([]string{"AWS4-HMAC-SHA256", t.Format("20060102T150405Z"), s.scope(t), hex.EncodeToString(sum[:])}, "\n")
+	key := []byte("AWS4" + s.secretKey)
+	for _, part := range []string{t.Format("20060102"), s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	return hex.EncodeToString(hmacSHA256(key, toSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// s3Query 按 SigV4 规则编码并排序查询参数
+func s3Query(q url.Values) string {
+	var parts []string
+	for k, vs := range q {
+		for _, v := range vs {
+			parts = append(parts, s3Escape(k, true)+"="+s3Escape(v, true))
+		}
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, "&")
+}
+
+// s3Escape SigV4 的 URI 编码：仅保留 A-Z a-z 0-9 - _ . ~ 不编码；路径中的 / 不编码
+func s3Escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// s3Error 非 2xx 响应转为错误，附带服务端错误码
+func s3Error(resp *http.Response) error {
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	var e struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if xml.Unmarshal(b, &e) == nil && e.Code != "" {
+		return fmt.Errorf("object storage: %s: %s %s", resp.Status, e.Code, e.Message)
+	}
+	return fmt.Errorf("object storage: %s", resp.Status)
+}
@@ -0,0 +1,294 @@
+package app
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// SchedulerConfig 全局上游调用调度
+type SchedulerConfig struct {
+	// 所有请求共享的上游并发上限，默认 8
+	MaxConcurrency int `json:"max_concurrency"`
+}
+
+func (c SchedulerConfig) maxConcurrency() int {
+	if c.MaxConcurrency <= 0 {
+		return 8
+	}
+	return c.MaxConcurrency
+}
+
+// 每次调用的预估 token（图片约 1k + 最大输出），实际用量在调用结束后回填
+const estimatedCallTokens = 1000 + visionMaxTokens
+
+// scheduler 所有请求共享的上游调用调度器：全局与按 provider 的并发上限、RPM/TPM 限流，
+// 各调用方（一次 /one 请求）各自排队，按轮询公平出队。
+type scheduler struct {
+	mu        sync.Mutex
+	global    int
+	running   int
+	providers map[string]*providerLimiter
+	// 有待执行调用的调用方，按首次入队顺序轮询
+	callers []*callerQueue
+	next    int
+	timer   *time.Timer
+	timerAt time.Time
+	now     func() time.Time
+}
+
+// providerLimiter 单个 provider 的并发与滑动窗口（1 分钟）计数
+type providerLimiter struct {
+	maxConcurrency, rpm, tpm int
+	running                  int
+	window                   []*windowStamp
+}
+
+type windowStamp struct {
+	at     time.Time
+	tokens int
+}
+
+type callerQueue struct {
+	id      string
+	waiting []*ticket
+}
+
+type ticket struct {
+	provider   string
+	tokens     int
+	ready      chan struct{}
+	stamp      *windowStamp
+	onPosition func(int)
+	position   int
+}
+
+func newScheduler(cfg SchedulerConfig, providers map[string]ProviderConfig) *scheduler {
+	s := &scheduler{global: cfg.maxConcurrency(), providers: map[string]*providerLimiter{}, now: time.Now}
+	for name, p := range providers {
+		s.providers[name] = &providerLimiter{maxConcurrency: p.MaxConcurrency, rpm: p.RPM, tpm: p.TPM}
+	}
+	return s
+}
+
+// acquire 排队等待执行名额；onPosition（可为 nil）在排队位置变化时回调，0 表示开始执行。
+// 返回的 release 需在调用结束后执行，传入实际消耗的 token（未知时传 0 保留预估值）。
+func (s *scheduler) acquire(ctx context.Context, caller, provider string, onPosition func(int)) (func(usedTokens int), error) {
+	t := &ticket{provider: provider, tokens: estimatedCallTokens, ready: make(chan struct{}), onPosition: onPosition, position: -1}
+	s.mu.Lock()
+	q := s.callerQueue(caller)
+	q.waiting = append(q.waiting, t)
+	notify := s.dispatchLocked()
+	s.mu.Unlock()
+	notify()
+
+	select {
+	case <-t.ready:
+	case <-ctx.Done():
+		s.mu.Lock()
+		select {
+		case <-t.ready:
+			// 取消与出队同时发生：已占用名额，立即归还
+			s.mu.Unlock()
+			s.release(t, 0)
+		default:
+			s.removeLocked(caller, t)
+			notify := s.dispatchLocked()
+			s.mu.Unlock()
+			notify()
+		}
+		return nil, ctx.Err()
+	}
+	if onPosition != nil {
+		onPosition(0)
+	}
+	var once sync.Once
+	return func(used int) { once.Do(func() { s.release(t, used) }) }, nil
+}
+
+func (s *scheduler) release(t *ticket, used int) {
+	s.mu.Lock()
+	s.running--
+	if p := s.providers[t.provider]; p != nil {
+		p.running--
+		if used > 0 && t.stamp != nil {
+			t.stamp.tokens = used
+		}
+	}
+	notify := s.dispatchLocked()
+	s.mu.Unlock()
+	notify()
+}
+
+// queued 当前排队中的调用数
+func (s *scheduler) queued() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, q := range s.callers {
+		n += len(q.waiting)
+	}
+	return n
+}
+
+// depth 返回排队中与执行中的调用数
+func (s *scheduler) depth() (waiting, running int) {
+	if s == nil {
+		return 0, 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, q := range s.callers {
+		waiting += len(q.waiting)
+	}
+	return waiting, s.running
+}
+
+func (s *scheduler) callerQueue(id string) *callerQueue {
+	for _, q := range s.callers {
+		if q.id == id {
+			return q
+		}
+	}
+	q := &callerQueue{id: id}
+	s.callers = append(s.callers, q)
+	return q
+}
+
+func (s *scheduler) removeLocked(caller string, t *ticket) {
+	for _, q := range s.callers {
+		if q.id != caller {
+			continue
+		}
+		for i, w := range q.waiting {
+			if w == t {
+				q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// dispatchLocked 按轮询依次为各调用方放行一个可执行的调用，直至没有可放行的；
+// 返回需在解锁后执行的排队位置通知。
+func (s *scheduler) dispatchLocked() func() {
+	now := s.now()
+	var wake time.Time
+	for s.running < s.global {
+		progressed := false
+		for i := 0; i < len(s.callers) && s.running < s.global; i++ {
+			q := s.callers[(s.next+i)%len(s.callers)]
+			for j, t := range q.waiting {
+				ok, retry := s.admit(t, now)
+				if !retry.IsZero() && (wake.IsZero() || retry.Before(wake)) {
+					wake = retry
+				}
+				if !ok {
+					continue
+				}
+				q.waiting = append(q.waiting[:j], q.waiting[j+1:]...)
+				close(t.ready)
+				progressed = true
+				break
+			}
+		}
+		s.next++
+		// 清理已无排队的调用方
+		kept := s.callers[:0]
+		for _, q := range s.callers {
+			if len(q.waiting) > 0 {
+				kept = append(kept, q)
+			}
+		}
+		s.callers = kept
+		if !progressed || len(s.callers) == 0 {
+			break
+		}
+	}
+	if len(s.callers) > 0 {
+		s.next %= len(s.callers)
+	} else {
+		s.next = 0
+	}
+	// RPM/TPM 窗口阻塞时，定时在最早记录过期后重新调度
+	if !wake.IsZero() && (s.timer == nil || wake.Before(s.timerAt)) {
+		if s.timer != nil {
+			s.timer.Stop()
+		}
+		s.timerAt = wake
+		s.timer = time.AfterFunc(wake.Sub(now), func() {
+			s.mu.Lock()
+			s.timer = nil
+			notify := s.dispatchLocked()
+			s.mu.Unlock()
+			notify()
+		})
+	}
+	return s.positionsLocked()
+}
+
+// admit 检查并占用名额；受滑动窗口限制时返回最早可重试时间
+func (s *scheduler) admit(t *ticket, now time.Time) (bool, time.Time) {
+	p := s.providers[t.provider]
+	if p == nil {
+		s.running++
+		return true, time.Time{}
+	}
+	if p.maxConcurrency > 0 && p.running >= p.maxConcurrency {
+		return false, time.Time{}
+	}
+	// 丢弃 1 分钟以前的记录
+	cut := 0
+	for cut < len(p.window) && now.Sub(p.window[cut].at) >= time.Minute {
+		cut++
+	}
+	p.window = p.window[cut:]
+	if p.rpm > 0 && len(p.window) >= p.rpm {
+		return false, p.window[0].at.Add(time.Minute)
+	}
+	if p.tpm > 0 && len(p.window) > 0 {
+		used := 0
+		for _, w := range p.window {
+			used += w.tokens
+		}
+		if used+t.tokens > p.tpm {
+			return false, p.window[0].at.Add(time.Minute)
+		}
+	}
+	s.running++
+	p.running++
+	t.stamp = &windowStamp{at: now, tokens: t.tokens}
+	p.window = append(p.window, t.stamp)
+	return true, time.Time{}
+}
+
+// positionsLocked 按轮询出队顺序计算排队位置（从 1 开始），收集有变化的通知
+func (s *scheduler) positionsLocked() func() {
+	var calls []func()
+	pos := 0
+	for round := 0; ; round++ {
+		found := false
+		for i := range s.callers {
+			q := s.callers[(s.next+i)%len(s.callers)]
+			if round >= len(q.waiting) {
+				continue
+			}
+			found = true
+			pos++
+			t := q.waiting[round]
+			if t.position != pos && t.onPosition != nil {
+				p, cb := pos, t.onPosition
+				calls = append(calls, func() { cb(p) })
+			}
+			t.position = pos
+		}
+		if !found {
+			break
+		}
+	}
+	return func() {
+		for _, c := range calls {
+			c()
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package app
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSchedulerFairAcrossCallers(t *testing.T) {
+	s := newScheduler(SchedulerConfig{MaxConcurrency: 1}, nil)
+	ctx := context.Background()
+
+	release, err := s.acquire(ctx, "warm", "p", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	start := func(caller string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rel, err := s.acquire(ctx, caller, "p", nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			order = append(order, caller)
+			mu.Unlock()
+			rel(0)
+		}()
+	}
+	// A 先排入 3 个，B 后排入 1 个：B 应在 A 的第二个之前执行
+	for i := 0; i < 3; i++ {
+		start("A")
+		waitQueued(t, s, i+1)
+	}
+	start("B")
+	waitQueued(t, s, 4)
+	release(0)
+	wg.Wait()
+	if len(order) != 4 || order[0] != "A" || order[1] != "B" {
+		t.Fatalf("order = %v", order)
+	}
+}
+
+func TestSchedulerProviderRPM(t *testing.T) {
+	s := newScheduler(SchedulerConfig{MaxConcurrency: 10}, map[string]ProviderConfig{"p": {RPM: 2}})
+	now := time.Unix(1000, 0)
+	s.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		rel, err := s.acquire(context.Background(), "c", "p", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rel(0)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var positions []int
+	if _, err := s.acquire(ctx, "c", "p", func(p int) { positions = append(positions, p) }); err == nil {
+		t.Fatal("third call within a minute should wait")
+	}
+	if len(positions) == 0 || positions[0] != 1 {
+		t.Fatalf("positions = %v", positions)
+	}
+	if s.queued() != 0 {
+		t.Fatal("cancelled ticket must leave the queue")
+	}
+}
+
+func waitQueued(t *testing.T, s *scheduler, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for s.queued() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("queued = %d, want %d", s.queued(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,340 @@
+package app
+
+import (
+	"html/template"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// searchIndex 截图历史的内存倒排索引：启动时从存储重建，保存/修改截图时增量更新
+type searchIndex struct {
+	mu sync.RWMutex
+	// 词项 -> 截图 ID -> 词频
+	postings map[string]map[string]int
+	docs     map[string]searchDoc
+}
+
+// searchDoc 参与过滤与展示的截图摘要
+type searchDoc struct {
+	Capture
+	terms map[string]int
+}
+
+// SearchResult 一条搜索结果
+type SearchResult struct {
+	ID       string    `json:"id"`
+	Time     time.Time `json:"time"`
+	Client   string    `json:"client"`
+	Session  string    `json:"session,omitempty"`
+	Tags     []string  `json:"tags,omitempty"`
+	Models   []string  `json:"models"`
+	Question string    `json:"question"`
+	Answer   string    `json:"answer"`
+	Score    float64   `json:"score"`
+	URL      string    `json:"url"`
+}
+
+// SearchQuery 搜索条件；各过滤项为空表示不限
+type SearchQuery struct {
+	Q       string `json:"q"`
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Client  string `json:"client"`
+	Model   string `json:"model"`
+	Session string `json:"session"`
+	Limit   int    `json:"limit"`
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{postings: map[string]map[string]int{}, docs: map[string]searchDoc{}}
+}
+
+// buildSearchIndex 载入存储中的全部截图元数据建立索引
+func buildSearchIndex(s *captureStore) *searchIndex {
+	idx := newSearchIndex()
+	if s == nil {
+		return idx
+	}
+	caps, err := s.all()
+	if err != nil {
+		slog.Warn("build search index", "err", err)
+	}
+	for _, c := range caps {
+		idx.add(c)
+	}
+	return idx
+}
+
+// captureText 参与全文检索的字段：题目、答案、模型原始输出、转写稿、客户端、会话与标签
+func captureText(c Capture) string {
+	parts := []string{c.Client, c.Session, strings.Join(c.Tags, " ")}
+	for _, ans := range c.ModelAnswers {
+		parts = append(parts, ans.Question, ans.Answer, ans.Raw)
+	}
+	if c.Transcript != nil {
+		parts = append(parts, c.Transcript.Raw)
+	}
+	return strings.Join(parts, "\n")
+}
+
+// add 索引（或重新索引）一张截图
+func (x *searchIndex) add(c Capture) {
+	terms := map[string]int{}
+	for _, t := range indexTerms(captureText(c)) {
+		terms[t]++
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.removeLocked(c.ID)
+	x.docs[c.ID] = searchDoc{Capture: c, terms: terms}
+	for t, n := range terms {
+		p := x.postings[t]
+		if p == nil {
+			p = map[string]int{}
+			x.postings[t] = p
+		}
+		p[c.ID] = n
+	}
+}
+
+// remove 从索引中移除截图
+func (x *searchIndex) remove(id string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.removeLocked(id)
+}
+
+func (x *searchIndex) removeLocked(id string) {
+	doc, ok := x.docs[id]
+	if !ok {
+		return
+	}
+	for t := range doc.terms {
+		if p := x.postings[t]; p != nil {
+			delete(p, id)
+			if len(p) == 0 {
+				delete(x.postings, t)
+			}
+		}
+	}
+	delete(x.docs, id)
+}
+
+// search 所有查询词项都出现的截图按 TF-IDF 得分降序；无查询词时按时间倒序列出满足过滤条件的截图
+func (x *searchIndex) search(q SearchQuery) []SearchResult {
+	limit := q.Limit
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	terms := uniqueTerms(tokenize(q.Q))
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	scores := map[string]float64{}
+	if len(terms) == 0 {
+		for id := range x.docs {
+			scores[id] = 0
+		}
+	} else {
+		for i, t := range terms {
+			p := x.postings[t]
+			idf := math.Log(1 + float64(len(x.docs))/float64(len(p)+1))
+			next := map[string]float64{}
+			for id, tf := range p {
+				if prev, ok := scores[id]; ok || i == 0 {
+					next[id] = prev + float64(tf)*idf
+				}
+			}
+			scores = next
+			if len(scores) == 0 {
+				break
+			}
+		}
+	}
+
+	results := []SearchResult{}
+	for id, score := range scores {
+		doc := x.docs[id]
+		if !q.matches(doc.Capture) {
+			continue
+		}
+		results = append(results, newSearchResult(doc.Capture, score))
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Time.After(results[j].Time)
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// matches 日期（本地时区 YYYY-MM-DD，含首尾）、客户端（包含）、模型、会话过滤
+func (q SearchQuery) matches(c Capture) bool {
+	day := c.Time.Local().Format("2006-01-02")
+	if q.From != "" && day < q.From {
+		return false
+	}
+	if q.To != "" && day > q.To {
+		return false
+	}
+	if q.Client != "" && !strings.Contains(c.Client, q.Client) {
+		return false
+	}
+	if q.Session != "" && c.Session != q.Session {
+		return false
+	}
+	if q.Model != "" {
+		found := false
+		for _, ans := range c.ModelAnswers {
+			if ans.Model == q.Model || ans.requestedModel() == q.Model {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// newSearchResult 取首个成功答案作为摘要
+func newSearchResult(c Capture, score float64) SearchResult {
+	r := SearchResult{ID: c.ID, Time: c.Time, Client: c.Client, Session: c.Session, Tags: c.Tags, Score: score, URL: "/chat?id=" + c.ID}
+	for _, ans := range c.ModelAnswers {
+		r.Models = append(r.Models, ans.Model)
+		if r.Question == "" && r.Answer == "" && ans.Error == "" {
+			r.Question, r.Answer = ans.Question, ans.Answer
+		}
+	}
+	return r
+}
+
+// tokenize 查询分词：中日韩文字按相邻二字切分（单字成段时保留单字），其他字母数字按连续片段切分；统一小写、全角转半角
+func tokenize(s string) []string {
+	return splitTerms(s, false)
+}
+
+// indexTerms 索引分词：在 tokenize 的基础上为多字的中日韩片段另加单字，使“猫”这样的单字查询也能命中
+func indexTerms(s string) []string {
+	return splitTerms(s, true)
+}
+
+func splitTerms(s string, unigrams bool) []string {
+	var out []string
+	var word []rune
+	var cjk []rune
+	flushWord := func() {
+		if len(word) > 0 {
+			out = append(out, string(word))
+			word = word[:0]
+		}
+	}
+	flushCJK := func() {
+		switch {
+		case len(cjk) == 1:
+			out = append(out, string(cjk))
+		case len(cjk) > 1:
+			for i := 0; i+1 < len(cjk); i++ {
+				out = append(out, string(cjk[i:i+2]))
+			}
+			if unigrams {
+				for _, r := range cjk {
+					out = append(out, string(r))
+				}
+			}
+		}
+		cjk = cjk[:0]
+	}
+	for _, r := range foldWidth(s) {
+		switch {
+		case isCJK(r):
+			flushWord()
+			cjk = append(cjk, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			flushCJK()
+			word = append(word, unicode.ToLower(r))
+		default:
+			flushWord()
+			flushCJK()
+		}
+	}
+	flushWord()
+	flushCJK()
+	return out
+}
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+func uniqueTerms(ts []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, t := range ts {
+		if !seen[t] {
+			seen[t] = true
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+func searchQueryFrom(r *http.Request) SearchQuery {
+	q := r.URL.Query()
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	return SearchQuery{
+		Q:       strings.TrimSpace(q.Get("q")),
+		From:    q.Get("from"),
+		To:      q.Get("to"),
+		Client:  strings.TrimSpace(q.Get("client")),
+		Model:   strings.TrimSpace(q.Get("model")),
+		Session: strings.TrimSpace(q.Get("session")),
+		Limit:   limit,
+	}
+}
+
+// handleAPISearch GET ?q=&from=&to=&client=&model=&session=&limit= 返回 JSON 搜索结果
+func (a *App) handleAPISearch(w http.ResponseWriter, r *http.Request) {
+	if a.captures == nil || a.index == nil {
+		writeJSONError(w, http.StatusNotFound, "capture storage disabled")
+		return
+	}
+	sq := searchQueryFrom(r)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"query": sq, "results": a.index.search(sq)})
+}
+
+// SearchPageData 搜索页模板数据
+type SearchPageData struct {
+	Query   SearchQuery
+	Results []SearchResult
+}
+
+// handleSearch 渲染搜索页
+func (a *App) handleSearch(w http.ResponseWriter, r *http.Request) {
+	if a.captures == nil || a.index == nil {
+		http.Error(w, "capture storage disabled", http.StatusNotFound)
+		return
+	}
+	tmpl, err := template.New("search").Funcs(templateFuncs).Parse(string(searchTemplate))
+	if err != nil {
+		http.Error(w, "Internal Server Error: unable to parse template", http.StatusInternalServerError)
+		return
+	}
+	sq := searchQueryFrom(r)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := tmpl.Execute(w, SearchPageData{Query: sq, Results: a.index.search(sq)}); err != nil {
+		http.Error(w, "Internal Server Error: unable to execute template", http.StatusInternalServerError)
+	}
+}
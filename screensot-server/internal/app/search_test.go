@@ -0,0 +1,102 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenize(t *testing.T) {
+	got := tokenize("TCP三次握手，ＳＹＮ 包")
+	want := []string{"tcp", "三次", "次握", "握手", "syn", "包"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("tokenize = %q, want %q", got, want)
+	}
+	got = indexTerms("握手，包")
+	want = []string{"握手", "握", "手", "包"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("indexTerms = %q, want %q", got, want)
+	}
+}
+
+func TestSearchIndex(t *testing.T) {
+	x := newSearchIndex()
+	day := time.Date(2026, 3, 2, 12, 0, 0, 0, time.Local)
+	x.add(Capture{ID: "a", Time: day, Client: "lab-1", Session: "net", ModelAnswers: []ModelAnswer{{Model: "m1", Question: "TCP 三次握手的第二步是什么", Answer: "SYN+ACK"}}})
+	x.add(Capture{ID: "b", Time: day.AddDate(0, 0, 5), Client: "lab-2", ModelAnswers: []ModelAnswer{{Model: "m2", Question: "UDP 是否可靠", Answer: "否"}}})
+	x.add(Capture{ID: "c", Time: day.AddDate(0, 0, 6), Client: "lab-2", Tags: []string{"网络"}, ModelAnswers: []ModelAnswer{{Model: "m2", Question: "握手失败的原因", Answer: "超时"}}})
+
+	ids := func(rs []SearchResult) []string {
+		var out []string
+		for _, r := range rs {
+			out = append(out, r.ID)
+		}
+		return out
+	}
+	if got := ids(x.search(SearchQuery{Q: "握手"})); len(got) != 2 {
+		t.Fatalf("握手 = %v", got)
+	}
+	// 单字查询命中多字片段中的字
+	if got := ids(x.search(SearchQuery{Q: "握"})); len(got) != 2 {
+		t.Fatalf("握 = %v", got)
+	}
+	if got := ids(x.search(SearchQuery{Q: "败"})); !reflect.DeepEqual(got, []string{"c"}) {
+		t.Fatalf("败 = %v", got)
+	}
+	if got := ids(x.search(SearchQuery{Q: "tcp 握手"})); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Fatalf("tcp 握手 = %v", got)
+	}
+	if got := ids(x.search(SearchQuery{Q: "握手", Client: "lab-2"})); !reflect.DeepEqual(got, []string{"c"}) {
+		t.Fatalf("client filter = %v", got)
+	}
+	if got := ids(x.search(SearchQuery{Q: "网络"})); !reflect.DeepEqual(got, []string{"c"}) {
+		t.Fatalf("tag = %v", got)
+	}
+	if got := ids(x.search(SearchQuery{From: "2026-03-07", Model: "m2"})); !reflect.DeepEqual(got, []string{"c", "b"}) {
+		t.Fatalf("date+model filter = %v", got)
+	}
+	if got := ids(x.search(SearchQuery{Session: "net"})); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Fatalf("session filter = %v", got)
+	}
+	x.remove("a")
+	if got := x.search(SearchQuery{Q: "tcp"}); len(got) != 0 {
+		t.Fatalf("after remove = %v", ids(got))
+	}
+}
+
+func TestSearchAPIAndTags(t *testing.T) {
+	a := &App{captures: newCaptureStore(StorageConfig{Backend: "local", Dir: t.TempDir()})}
+	a.index = buildSearchIndex(a.captures)
+	items := []ImageEntry{{Client: "pc", Base64: "aW1n", ModelAnswers: []ModelAnswer{{Model: "m", Question: "二叉树的高度", Answer: "3"}}}}
+	a.saveCaptures(items, analyzeOptions{requestID: "r", session: "exam"})
+
+	w := httptest.NewRecorder()
+	a.handleAPICapture(w, httptest.NewRequest(http.MethodPatch, "/api/v1/capture?id="+items[0].ID, strings.NewReader(`{"tags":["数据结构"," ",""]}`)))
+	if w.Code != 200 {
+		t.Fatalf("patch: %d %s", w.Code, w.Body.String())
+	}
+
+	// 重建索引应能从存储恢复标签与会话
+	a.index = buildSearchIndex(a.captures)
+	w = httptest.NewRecorder()
+	a.handleAPISearch(w, httptest.NewRequest(http.MethodGet, "/api/v1/search?q=数据结构&session=exam", nil))
+	var resp struct {
+		Results []SearchResult `json:"results"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Results) != 1 || resp.Results[0].URL != "/chat?id="+items[0].ID || !reflect.DeepEqual(resp.Results[0].Tags, []string{"数据结构"}) {
+		t.Fatalf("results = %+v", resp.Results)
+	}
+
+	w = httptest.NewRecorder()
+	a.handleSearch(w, httptest.NewRequest(http.MethodGet, "/search?q=二叉树", nil))
+	if w.Code != 200 || !strings.Contains(w.Body.String(), "/chat?id="+items[0].ID) {
+		t.Fatalf("search page: %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,148 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"screensot-server/internal/protocol"
+)
+
+// ServerConfig 监听地址、HTTP 超时、请求体上限与优雅关闭
+type ServerConfig struct {
+	// HTTP 监听地址，默认 ":8848"；支持 "[::1]:8848" 等 IPv6 地址与 "unix:/run/screensot/http.sock"
+	HTTPAddr string `json:"http_addr"`
+	// 截屏通道监听地址，默认 ":12345"，格式同 http_addr
+	TCPAddr string `json:"tcp_addr"`
+	// HTTP 超时（秒）：读取请求头默认 10，读取整个请求默认 60，写响应默认 120（流式接口不受写超时限制），空闲连接默认 120
+	ReadHeaderTimeoutSeconds int `json:"read_header_timeout_seconds"`
+	ReadTimeoutSeconds       int `json:"read_timeout_seconds"`
+	WriteTimeoutSeconds      int `json:"write_timeout_seconds"`
+	IdleTimeoutSeconds       int `json:"idle_timeout_seconds"`
+	// 请求头上限（KB），默认 64；请求体上限（MB），默认 32
+	MaxHeaderKB int `json:"max_header_kb"`
+	MaxBodyMB   int `json:"max_body_mb"`
+	// 收到 SIGINT/SIGTERM 后等待进行中请求与识别任务的时长（秒），默认 30
+	ShutdownTimeoutSeconds int `json:"shutdown_timeout_seconds"`
+}
+
+func (c ServerConfig) httpAddr() string {
+	if c.HTTPAddr == "" {
+		return ":8848"
+	}
+	return c.HTTPAddr
+}
+
+func (c ServerConfig) tcpAddr() string {
+	if c.TCPAddr == "" {
+		return ":12345"
+	}
+	return c.TCPAddr
+}
+
+// seconds 配置值为正时取配置，否则取默认
+func seconds(v, def int) time.Duration {
+	if v <= 0 {
+		v = def
+	}
+	return time.Duration(v) * time.Second
+}
+
+func (c ServerConfig) shutdownTimeout() time.Duration { return seconds(c.ShutdownTimeoutSeconds, 30) }
+
+// listen 监听 TCP 地址或 "unix:" 前缀的 unix socket（启动前删除残留的 socket 文件；
+// 路径上已有的不是 socket 时返回错误，不会误删配置写错时指向的普通文件）
+func listen(addr string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		fi, err := os.Lstat(path)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+		case err != nil:
+			return nil, err
+		case fi.Mode()&os.ModeSocket == 0:
+			return nil, fmt.Errorf("%s exists and is not a unix socket", path)
+		default:
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+		}
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// newHTTPServer 按配置构造带超时与请求体上限的 HTTP 服务
+func (a *App) newHTTPServer() *http.Server {
+	c := a.conf().Server
+	mux := http.NewServeMux()
+	a.routes(mux)
+	maxHeader, maxBody := c.MaxHeaderKB, c.MaxBodyMB
+	if maxHeader <= 0 {
+		maxHeader = 64
+	}
+	if maxBody <= 0 {
+		maxBody = 32
+	}
+	return &http.Server{
+		Handler:           withRequestLog(http.MaxBytesHandler(mux, int64(maxBody)<<20)),
+		ReadHeaderTimeout: seconds(c.ReadHeaderTimeoutSeconds, 10),
+		ReadTimeout:       seconds(c.ReadTimeoutSeconds, 60),
+		WriteTimeout:      seconds(c.WriteTimeoutSeconds, 120),
+		IdleTimeout:       seconds(c.IdleTimeoutSeconds, 120),
+		MaxHeaderBytes:    maxHeader << 10,
+	}
+}
+
+// clearWriteDeadline 流式接口（SSE）持续时间不定，取消该请求的写超时
+func clearWriteDeadline(w http.ResponseWriter) {
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+}
+
+// shutdown 优雅关闭：停止接收新连接，等待进行中的 HTTP 请求与后台识别任务（二者共用一个截止时间），
+// 然后通知并断开截屏客户端。截图、账本与审计日志均在识别完成时同步写入，任务结束即已落盘
+func (a *App) shutdown(srv *http.Server, tcpLn net.Listener) {
+	timeout := a.conf().Server.shutdownTimeout()
+	slog.Info("shutting down, waiting for in-flight requests and analysis jobs", "timeout", timeout)
+	a.httpUp.Store(false)
+	a.tcpUp.Store(false)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if tcpLn != nil {
+		tcpLn.Close()
+	}
+	// 进行中的同步识别仍需等待客户端回传截图，因此先关闭 HTTP、后断开客户端
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Warn("http shutdown", "err", err)
+		srv.Close()
+	}
+	done := make(chan struct{})
+	go func() {
+		a.jobsWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		slog.Warn("analysis jobs still running after shutdown timeout, results will be lost", "timeout", timeout)
+	}
+	slog.Info("disconnected tcp clients", "count", a.disconnectClients())
+}
+
+// disconnectClients 向所有客户端发送关闭通知并断开，返回断开的连接数
+func (a *App) disconnectClients() int {
+	a.clientsMutex.Lock()
+	defer a.clientsMutex.Unlock()
+	for conn := range a.clients {
+		_ = conn.SetWriteDeadline(time.Now().Add(2 * time.Second))
+		_ = protocol.SendWithLengthPrefix(conn, []byte(protocol.CmdShutdown))
+		conn.Close()
+	}
+	return len(a.clients)
+}
@@ -0,0 +1,100 @@
+package app
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"screensot-server/internal/protocol"
+)
+
+func TestServerDefaults(t *testing.T) {
+	a := &App{}
+	srv := a.newHTTPServer()
+	if srv.ReadHeaderTimeout != 10*time.Second || srv.WriteTimeout != 120*time.Second || srv.MaxHeaderBytes != 64<<10 {
+		t.Fatalf("defaults = %+v", srv)
+	}
+	if a.cfg.Server.httpAddr() != ":8848" || a.cfg.Server.tcpAddr() != ":12345" {
+		t.Fatal("default addresses")
+	}
+}
+
+// 经 unix socket 提供 HTTP；关闭时等待后台任务结束并通知客户端
+func TestUnixSocketAndGracefulShutdown(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "http.sock")
+	a := &App{state: &state{clients: map[net.Conn]*tcpClient{}}}
+	a.cfg.Server = ServerConfig{HTTPAddr: "unix:" + sock, ShutdownTimeoutSeconds: 5}
+	srv := a.newHTTPServer()
+	ln, err := listen(a.cfg.Server.httpAddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+
+	hc := &http.Client{Transport: &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+	}}}
+	resp, err := hc.Get("http://unix/api/v1/me")
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("get over unix socket = %v, %v", resp, err)
+	}
+	resp.Body.Close()
+
+	var jobDone atomic.Bool
+	a.jobsWG.Add(1)
+	go func() {
+		defer a.jobsWG.Done()
+		time.Sleep(100 * time.Millisecond)
+		jobDone.Store(true)
+	}()
+	server, client := net.Pipe()
+	a.clients[server] = &tcpClient{ID: "office"}
+	got := make(chan string, 1)
+	go func() {
+		b, _ := protocol.ReadWithLengthPrefix(client)
+		got <- string(b)
+	}()
+
+	a.shutdown(srv, nil)
+	if !jobDone.Load() {
+		t.Fatal("shutdown returned before the analysis job finished")
+	}
+	if msg := <-got; msg != protocol.CmdShutdown {
+		t.Fatalf("client got %q", msg)
+	}
+	if _, err := hc.Get("http://unix/api/v1/me"); err == nil {
+		t.Fatal("server still accepting after shutdown")
+	}
+}
+
+// 残留的 socket 文件可被替换；路径上的普通文件不会被删除
+func TestListenUnixKeepsRegularFiles(t *testing.T) {
+	dir := t.TempDir()
+	sock := filepath.Join(dir, "http.sock")
+	ln, err := listen("unix:" + sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+	if ln, err = listen("unix:" + sock); err != nil {
+		t.Fatalf("relisten on stale socket: %v", err)
+	}
+	ln.Close()
+
+	plain := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(plain, []byte("{}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listen("unix:" + plain); err == nil {
+		t.Fatal("listen on a regular file succeeded")
+	}
+	if _, err := os.Stat(plain); err != nil {
+		t.Fatalf("regular file removed: %v", err)
+	}
+}
@@ -3,16 +3,27 @@ package app
 import (
 	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // 服务器运行期共享状态
 type state struct {
-	clients           map[net.Conn]bool
-	clientsMutex      sync.Mutex
-	responseCollector chan string
+	clients      map[net.Conn]*tcpClient
+	clientsMutex sync.Mutex
+	// 进行中的截屏请求：请求 ID -> 接收客户端应答的通道，受 clientsMutex 保护
+	captureWaiters map[string]chan screenshot
 	// 最近一次“已识别”的结果，用于 capture 模式下保留上次识别内容
 	lastAnalyses []ImageEntry
 	lastMu       sync.RWMutex
+	// 后台识别任务（流式模式），按 ID 索引
+	jobs   map[string]*analysisJob
+	jobsMu sync.Mutex
+	// 进行中的后台识别任务，关闭时等待其结束
+	jobsWG sync.WaitGroup
+	// HTTP 与截屏通道是否在监听，供 /readyz 判断；开始关闭时置为 false
+	httpUp, tcpUp atomic.Bool
+	startedAt     time.Time
 }
 
 // getLastAnalyses 线程安全读取最近一次识别结果（浅拷贝）
@@ -24,13 +35,13 @@ func (a *App) getLastAnalyses() []ImageEntry {
 	return out
 }
 
-// setLastAnalyses 线程安全设置最近一次识别结果（深拷贝 ModelAnswers）
+// setLastAnalyses 线程安全设置最近一次识别结果（深拷贝 ModelAnswers，Consensus、Transcript 只读共享）
 func (a *App) setLastAnalyses(in []ImageEntry) {
 	a.lastMu.Lock()
 	defer a.lastMu.Unlock()
 	a.lastAnalyses = make([]ImageEntry, len(in))
 	for i := range in {
-		ent := ImageEntry{Base64: in[i].Base64}
+		ent := ImageEntry{ID: in[i].ID, Client: in[i].Client, ImageURL: in[i].ImageURL, Base64: in[i].Base64, Consensus: in[i].Consensus, Transcript: in[i].Transcript}
 		if len(in[i].ModelAnswers) > 0 {
 			ent.ModelAnswers = append([]ModelAnswer(nil), in[i].ModelAnswers...)
 		}
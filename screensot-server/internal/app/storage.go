@@ -0,0 +1,166 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// StorageConfig 截图与识别结果的持久化存储
+type StorageConfig struct {
+	// 后端：local、s3（S3 兼容对象存储）、qiniu（七牛 Kodo，经其 S3 兼容接口）；未配置或 off 表示不保存（需显式开启）
+	Backend string `json:"backend"`
+	// local 后端目录，相对路径相对于 config.json 所在目录，默认 data_dir/captures
+	Dir string `json:"dir"`
+	// s3/qiniu 后端：服务地址（qiniu 未配置时按 region 生成）、区域、存储桶、对象前缀与密钥
+	Endpoint  string `json:"endpoint"`
+	Region    string `json:"region"`
+	Bucket    string `json:"bucket"`
+	Prefix    string `json:"prefix"`
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key"`
+	// 使用路径风格地址（endpoint/bucket/key），MinIO 等自建服务通常需要
+	PathStyle bool `json:"path_style"`
+	// 向模型发送对象存储的限时签名地址而不是 base64 图片，减小请求体；需模型服务能访问该存储
+	PresignImages bool `json:"presign_images"`
+	// 签名地址有效期（分钟），默认 15
+	PresignTTLMinutes int `json:"presign_ttl_minutes"`
+	// 保留策略与后台清理
+	Retention RetentionConfig `json:"retention"`
+}
+
+// errBlobNotFound 对象不存在
+var errBlobNotFound = errors.New("blob not found")
+
+// blobStore 按 key 存取对象的存储后端；key 以 / 分隔层级，如 images/<id>.png
+type blobStore interface {
+	Put(key string, data []byte) error
+	Get(key string) ([]byte, error)
+	Delete(key string) error
+	// List 返回以 prefix 开头的全部 key（升序）
+	List(prefix string) ([]string, error)
+}
+
+// presigner 可生成限时访问地址的存储后端
+type presigner interface {
+	PresignGet(key string, ttl time.Duration) (string, error)
+}
+
+func (c StorageConfig) presignTTL() time.Duration {
+	if c.PresignTTLMinutes <= 0 {
+		return 15 * time.Minute
+	}
+	return time.Duration(c.PresignTTLMinutes) * time.Minute
+}
+
+// storageOff 未配置后端或显式关闭
+func (c StorageConfig) storageOff() bool {
+	switch strings.ToLower(strings.TrimSpace(c.Backend)) {
+	case "", "off", "none":
+		return true
+	}
+	return false
+}
+
+// newBlobStore 按配置创建存储后端；关闭或配置无效时返回 nil
+func newBlobStore(c StorageConfig) blobStore {
+	switch backend := strings.ToLower(strings.TrimSpace(c.Backend)); backend {
+	case "local":
+		return &localStore{dir: c.Dir}
+	case "s3", "qiniu", "kodo":
+		s, err := newS3Store(c, backend != "s3")
+		if err != nil {
+			slog.Warn("storage unavailable, captures will not be saved", "backend", backend, "err", err)
+			return nil
+		}
+		return s
+	case "", "off", "none":
+		return nil
+	default:
+		slog.Warn("unknown storage backend, captures will not be saved", "backend", c.Backend)
+		return nil
+	}
+}
+
+// localStore 本地目录存储，key 即相对路径
+type localStore struct {
+	dir string
+}
+
+func (s *localStore) path(key string) (string, error) {
+	clean := path.Clean("/" + key)[1:]
+	if clean == "" || clean != key {
+		return "", fmt.Errorf("invalid key %q", key)
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(clean)), nil
+}
+
+func (s *localStore) Put(key string, data []byte) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+func (s *localStore) Get(key string) ([]byte, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, errBlobNotFound
+	}
+	return b, err
+}
+
+func (s *localStore) Delete(key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *localStore) List(prefix string) ([]string, error) {
+	var keys []string
+	err := filepath.WalkDir(s.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(p, ".tmp") {
+			return nil
+		}
+		rel, err := filepath.Rel(s.dir, p)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	sort.Strings(keys)
+	return keys, err
+}
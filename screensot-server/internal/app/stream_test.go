@@ -0,0 +1,41 @@
+package app
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadStreamContent(t *testing.T) {
+	body := strings.Join([]string{
+		`data: {"choices":[{"delta":{"reasoning_content":"先看"}}]}`,
+		``,
+		`data: {"choices":[{"delta":{"content":"{\"question\":"}}]}`,
+		`: keep-alive`,
+		`data: {"choices":[{"delta":{"content":"\"1+1\",\"answer\":\"2\"}"}}]}`,
+		`data: {"choices":[],"usage":{"prompt_tokens":812,"completion_tokens":20,"total_tokens":832}}`,
+		`data: [DONE]`,
+		``,
+	}, "\n")
+	var deltas []string
+	got, usage, err := readStreamContent(strings.NewReader(body), func(s string) { deltas = append(deltas, s) })
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if usage == nil || usage.PromptTokens != 812 || usage.CompletionTokens != 20 {
+		t.Fatalf("usage = %+v", usage)
+	}
+	if got != `{"question":"1+1","answer":"2"}` {
+		t.Fatalf("content = %q", got)
+	}
+	if len(deltas) != 3 || deltas[0] != "（思考中）先看" || deltas[2] != got {
+		t.Fatalf("deltas = %q", deltas)
+	}
+}
+
+func TestReadStreamContentError(t *testing.T) {
+	body := "data: {\"choices\":[{\"delta\":{\"content\":\"ab\"}}]}\ndata: {\"error\":{\"message\":\"rate limited\"}}\n"
+	got, _, err := readStreamContent(strings.NewReader(body), nil)
+	if err == nil || err.Error() != "rate limited" || got != "ab" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+}
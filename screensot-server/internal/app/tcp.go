@@ -1,43 +1,74 @@
 package app
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"io"
+	"log/slog"
 	"net"
+	"sort"
+	"time"
+
 	"screensot-server/internal/protocol"
 )
 
-func (a *App) startTCPServer() {
-	a.clients = make(map[net.Conn]bool)
-	a.responseCollector = make(chan string, 1000)
+// screenshot 客户端上报的一张截图
+type screenshot struct {
+	// 客户端标识：登记名、客户端证书 CN 或远端地址
+	Client string
+	Base64 string
+}
 
-	listener, err := net.Listen("tcp", ":12345")
+// startTCPServer 监听截屏通道并在后台接收客户端，返回监听器供关闭时停止接收；监听失败返回 nil
+func (a *App) startTCPServer() net.Listener {
+	addr := a.conf().Server.tcpAddr()
+	listener, err := a.listenTCP(addr)
 	if err != nil {
-		fmt.Println("Error listening:", err.Error())
-		return
+		slog.Error("tcp listen failed", "addr", addr, "err", err)
+		return nil
 	}
-	defer listener.Close()
-	fmt.Println("TCP Server listening on :12345")
+	a.tcpUp.Store(true)
+	slog.Info("tcp server listening", "addr", addr, "tls", a.conf().TCP.TLS.Enabled, "client_certs", a.conf().TCP.TLS.Enabled && a.conf().TCP.TLS.ClientCAFile != "")
 
-	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			fmt.Println("Error accepting:", err.Error())
-			continue
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			if err != nil {
+				slog.Warn("tcp accept", "err", err)
+				continue
+			}
+			go a.handleTCPClient(conn)
 		}
-		fmt.Println("TCP client connected:", conn.RemoteAddr().String())
-
-		a.clientsMutex.Lock()
-		a.clients[conn] = true
-		a.clientsMutex.Unlock()
-
-		go a.handleTCPClient(conn)
-	}
+	}()
+	return listener
 }
 
 func (a *App) handleTCPClient(conn net.Conn) {
+	// TLS 握手在各自的 goroutine 中进行，避免慢客户端阻塞 Accept
+	cl, err := a.conf().TCP.identify(conn)
+	if err == nil && a.tokens.enabled() {
+		err = a.authenticate(conn, cl)
+	}
+	if err != nil {
+		slog.Warn("tcp client rejected", "remote", conn.RemoteAddr().String(), "err", err)
+		conn.Close()
+		return
+	}
+	log := slog.With("client", cl.ID)
+	log.Info("tcp client connected", "remote", cl.Addr)
+	a.clientsMutex.Lock()
+	a.clients[conn] = cl
+	a.clientsMutex.Unlock()
+	// 认证与登记之间令牌可能已被吊销
+	if a.tokens != nil && !a.tokens.valid(cl) {
+		conn.Close()
+	}
+
 	defer func() {
 		conn.Close()
 		a.clientsMutex.Lock()
@@ -46,43 +77,131 @@ func (a *App) handleTCPClient(conn net.Conn) {
 	}()
 
 	for {
-		dataBytes, err := protocol.ReadWithLengthPrefix(conn)
+		dataBytes, err := protocol.ReadWithLimit(conn, a.conf().TCP.maxFrameBytes())
 		if err != nil {
-			if err == io.EOF {
-				fmt.Println("TCP client disconnected:", conn.RemoteAddr().String())
+			if err == io.EOF || errors.Is(err, net.ErrClosed) {
+				log.Info("tcp client disconnected")
 			} else {
-				fmt.Println("Error reading data from client:", err)
+				log.Warn("read from tcp client", "err", err)
 			}
 			return
 		}
 
 		var responseObj protocol.Response
 		if err := json.Unmarshal(dataBytes, &responseObj); err != nil {
-			fmt.Println("Error unmarshalling JSON from client:", err)
+			log.Warn("invalid response from tcp client", "err", err)
+			continue
+		}
+		if responseObj.RequestID == "" {
+			var resend bool
+			responseObj.RequestID, resend = a.legacyReply(cl, responseObj)
+			if resend {
+				if err := protocol.SendWithLengthPrefix(conn, []byte(protocol.CmdCapture)); err != nil {
+					log.Warn("send capture command", "err", err)
+				}
+				continue
+			}
+		}
+		rlog := log.With("request_id", responseObj.RequestID)
+		if responseObj.Code != 200 {
+			rlog.Warn("client failed to capture", "code", responseObj.Code, "err", responseObj.Error)
+		}
+
+		// 只接收对该客户端待回传命令的应答：未下发命令、已超时或请求 ID 不符的帧一律丢弃
+		a.clientsMutex.Lock()
+		waiter := a.captureWaiters[responseObj.RequestID]
+		commandAt, pending := cl.pending[responseObj.RequestID]
+		matched := pending && waiter != nil
+		if pending {
+			delete(cl.pending, responseObj.RequestID)
+		}
+		outstanding := len(cl.pending)
+		a.clientsMutex.Unlock()
+		if !matched {
+			rlog.Warn("dropping unsolicited screenshot", "outstanding_requests", outstanding)
 			continue
 		}
 
 		// 统一在 TCP 层转成 base64，HTTP 层只负责聚合
 		base64Str := base64.StdEncoding.EncodeToString(responseObj.Data)
-		fmt.Printf("Received image from %s, Base64 size: %d\n", conn.RemoteAddr().String(), len(base64Str))
-		if len(base64Str) > 100 {
-			fmt.Println("Base64 Image Data (truncated):", base64Str[:100]+"...")
-		}
-		a.responseCollector <- base64Str
+		rlog.Debug("received screenshot", "bytes", len(responseObj.Data))
+		a.metrics.observeCapture(cl.ID, responseObj.Code, len(responseObj.Data), commandAt)
+		// 通道容量等于命令下发到的客户端数，每个客户端至多应答一次，不会阻塞
+		waiter <- screenshot{Client: cl.ID, Base64: base64Str}
 	}
 }
 
-func (a *App) sendCaptureCommandToClients() {
+// sendCaptureCommandToClients 向所有客户端下发带请求 ID 的截屏命令，并登记接收应答的通道；
+// 返回实际使用的请求 ID（与进行中的请求重复时追加后缀）与命令下发到的客户端。调用方等待结束后须调用 finishCapture 注销
+func (a *App) sendCaptureCommandToClients(ctx context.Context, requestID string) (string, []string, <-chan screenshot) {
 	a.clientsMutex.Lock()
 	defer a.clientsMutex.Unlock()
-	msg := []byte("1")
-	for c := range a.clients {
-		go func(conn net.Conn) {
+	if a.captureWaiters == nil {
+		a.captureWaiters = map[string]chan screenshot{}
+	}
+	if _, dup := a.captureWaiters[requestID]; dup {
+		requestID += "." + newID()
+	}
+	waiter := make(chan screenshot, len(a.clients))
+	a.captureWaiters[requestID] = waiter
+	msg := []byte(protocol.CaptureCommand(requestID))
+	log := logger(ctx)
+	var targets []string
+	for c, cl := range a.clients {
+		msg := msg
+		if cl.Legacy {
+			// 旧版客户端的应答不带请求 ID，同一时刻只能有一条待回传命令
+			if len(cl.pending) > 0 {
+				log.Warn("skipping legacy client busy with another capture request", "client", cl.ID)
+				continue
+			}
+			msg = []byte(protocol.CmdCapture)
+		}
+		if cl.pending == nil {
+			cl.pending = map[string]time.Time{}
+		}
+		cl.pending[requestID] = time.Now()
+		targets = append(targets, cl.ID)
+		go func(conn net.Conn, id string) {
 			if err := protocol.SendWithLengthPrefix(conn, msg); err != nil {
-				fmt.Printf("Failed to send command to client %s: %v\n", conn.RemoteAddr().String(), err)
+				log.Warn("send capture command", "client", id, "err", err)
 			} else {
-				fmt.Printf("Sent command to client %s\n", conn.RemoteAddr().String())
+				log.Debug("sent capture command", "client", id)
 			}
-		}(c)
+		}(c, cl.ID)
+	}
+	sort.Strings(targets)
+	return requestID, targets, waiter
+}
+
+// legacyReply 处理不带请求 ID 的应答。新版客户端总会带回命令中的请求 ID，因此不带 ID 的
+// 400 "Unknown command" 说明对端是不识别 "1:<请求ID>" 的旧版客户端：将其标记为旧版，
+// 此后只发送 "1"，并在恰有一条待回传命令时重发 "1"（resend 为 true）。
+// 旧版客户端不带 ID 的截图仅在恰有一条待回传命令时归入该请求，返回其请求 ID；否则返回空串（帧将被丢弃）
+func (a *App) legacyReply(cl *tcpClient, resp protocol.Response) (requestID string, resend bool) {
+	a.clientsMutex.Lock()
+	defer a.clientsMutex.Unlock()
+	if !cl.Legacy && resp.Code == 400 && resp.Error == "Unknown command" {
+		cl.Legacy = true
+		slog.Error("tcp client does not understand capture commands with request IDs; falling back to bare capture commands for this connection, upgrade the client",
+			"client", cl.ID, "remote", cl.Addr)
+		return "", len(cl.pending) == 1
+	}
+	if !cl.Legacy || len(cl.pending) != 1 {
+		return "", false
+	}
+	for id := range cl.pending {
+		requestID = id
+	}
+	return requestID, false
+}
+
+// finishCapture 注销请求的应答通道；仍未应答的客户端此后回传的截图被丢弃，不会混入后续请求
+func (a *App) finishCapture(requestID string) {
+	a.clientsMutex.Lock()
+	defer a.clientsMutex.Unlock()
+	delete(a.captureWaiters, requestID)
+	for _, cl := range a.clients {
+		delete(cl.pending, requestID)
 	}
 }
@@ -0,0 +1,140 @@
+package app
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"screensot-server/internal/protocol"
+)
+
+// 未下发命令、请求 ID 不符或已超时请求的截图帧被丢弃，不会混入后续请求
+func TestUnsolicitedScreenshotsDropped(t *testing.T) {
+	a := &App{state: &state{clients: map[net.Conn]*tcpClient{}}}
+	server, client := net.Pipe()
+	defer client.Close()
+	go a.handleTCPClient(server)
+	for deadline := time.Now().Add(time.Second); len(a.connectedClients()) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("client not registered")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	send := func(id, data string) {
+		b, _ := json.Marshal(protocol.Response{Code: 200, Data: []byte(data), RequestID: id})
+		if err := protocol.SendWithLengthPrefix(client, b); err != nil {
+			t.Error(err)
+		}
+	}
+	readCommand := func() string {
+		b, err := protocol.ReadWithLengthPrefix(client)
+		if err != nil {
+			t.Error(err)
+		}
+		_, id := protocol.ParseCommand(string(b))
+		return id
+	}
+
+	// 第一次请求：先注入未请求的帧，再按命令应答两次（第二次为重复帧）
+	go func() {
+		send("", "evil")
+		id := readCommand()
+		send("other", "evil")
+		send(id, "good")
+		send(id, "dup")
+	}()
+	shots, targets, err := a.collectScreenshots(withRequestID(context.Background(), "r1"))
+	if err != nil || len(targets) != 1 || len(shots) != 1 || shots[0].Base64 != "Z29vZA==" {
+		t.Fatalf("first request: %+v %v %v", shots, targets, err)
+	}
+
+	// 第二次请求放弃等待后，迟到的应答不会进入第三次请求
+	ctx, cancel := context.WithCancel(withRequestID(context.Background(), "r2"))
+	late := make(chan string, 1)
+	go func() {
+		late <- readCommand()
+		cancel()
+	}()
+	if shots, _, _ := a.collectScreenshots(ctx); len(shots) != 0 {
+		t.Fatalf("cancelled request got %+v", shots)
+	}
+	go func() {
+		send(<-late, "late")
+		send(readCommand(), "fresh")
+	}()
+	shots, _, _ = a.collectScreenshots(withRequestID(context.Background(), "r3"))
+	if len(shots) != 1 || shots[0].Base64 != "ZnJlc2g=" {
+		t.Fatalf("third request: %+v", shots)
+	}
+
+	// 两个请求重叠：客户端依次应答两条命令，各请求都收到自己的截图
+	go func() {
+		first, second := readCommand(), readCommand()
+		send(first, "one:"+first)
+		send(second, "two:"+second)
+	}()
+	type result struct {
+		shots []screenshot
+		id    string
+	}
+	results := make(chan result, 2)
+	for _, id := range []string{"r4", "r5"} {
+		go func(id string) {
+			shots, _, _ := a.collectScreenshots(withRequestID(context.Background(), id))
+			results <- result{shots, id}
+		}(id)
+	}
+	for i := 0; i < 2; i++ {
+		r := <-results
+		if len(r.shots) != 1 {
+			t.Fatalf("overlapping request %s: %+v", r.id, r.shots)
+		}
+		b, _ := base64.StdEncoding.DecodeString(r.shots[0].Base64)
+		if _, id, _ := strings.Cut(string(b), ":"); id != r.id {
+			t.Fatalf("request %s got %q", r.id, b)
+		}
+	}
+}
+
+// 旧版客户端只认 "1"：收到带请求 ID 的命令时应答不带 ID 的 400，服务端改发 "1" 并把其应答归入唯一的待回传请求
+func TestLegacyClientFallback(t *testing.T) {
+	a := &App{state: &state{clients: map[net.Conn]*tcpClient{}}}
+	server, client := net.Pipe()
+	defer client.Close()
+	go a.handleTCPClient(server)
+	for deadline := time.Now().Add(time.Second); len(a.connectedClients()) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("client not registered")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	go func() {
+		for {
+			b, err := protocol.ReadWithLengthPrefix(client)
+			if err != nil {
+				return
+			}
+			resp := protocol.Response{Code: 200, Data: []byte("shot")}
+			if string(b) != "1" {
+				resp = protocol.Response{Code: 400, Error: "Unknown command"}
+			}
+			out, _ := json.Marshal(resp)
+			if err := protocol.SendWithLengthPrefix(client, out); err != nil {
+				return
+			}
+		}
+	}()
+	for _, id := range []string{"r1", "r2"} {
+		shots, _, _ := a.collectScreenshots(withRequestID(context.Background(), id))
+		if len(shots) != 1 || shots[0].Base64 != "c2hvdA==" {
+			t.Fatalf("%s: %+v", id, shots)
+		}
+	}
+	if cls := a.connectedClients(); len(cls) != 1 || !cls[0].Legacy {
+		t.Fatalf("clients = %+v", cls)
+	}
+}
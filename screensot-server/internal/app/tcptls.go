@@ -0,0 +1,158 @@
+package app
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+)
+
+// TCPConfig 截屏通道设置（监听地址见 server.tcp_addr）
+type TCPConfig struct {
+	// TLS 加密与客户端证书认证
+	TLS TLSConfig `json:"tls"`
+	// 客户端登记表：证书 CN -> 客户端信息。启用客户端证书时，非空则只允许登记过的 CN 连接
+	Clients map[string]RegisteredClient `json:"clients"`
+	// 令牌认证
+	Auth TokenAuthConfig `json:"auth"`
+	// 客户端回传截图帧的上限（MB），默认 64；超出时在分配内存前断开连接
+	MaxFrameMB int `json:"max_frame_mb"`
+}
+
+func (c TCPConfig) maxFrameBytes() uint32 {
+	if c.MaxFrameMB <= 0 || c.MaxFrameMB > 4095 {
+		return 64 << 20
+	}
+	return uint32(c.MaxFrameMB) << 20
+}
+
+// TLSConfig 截屏通道 TLS；证书路径相对于 config.json 所在目录
+type TLSConfig struct {
+	Enabled  bool   `json:"enabled"`
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+	// 签发客户端证书的 CA；配置后要求并校验客户端证书（双向 TLS）
+	ClientCAFile string `json:"client_ca_file"`
+}
+
+// RegisteredClient 登记的客户端
+type RegisteredClient struct {
+	// 显示名，作为截图来源；为空时使用证书 CN
+	Name string `json:"name"`
+	// 停用后拒绝连接（如证书泄露）
+	Disabled bool `json:"disabled"`
+}
+
+// tcpClient 一个已连接的截屏客户端
+type tcpClient struct {
+	// 客户端标识：登记名 > 证书 CN > 远端地址
+	ID   string `json:"id"`
+	Addr string `json:"addr"`
+	// 双向 TLS 时为客户端证书 CN
+	CommonName string `json:"common_name,omitempty"`
+	// 令牌认证时为令牌名
+	Token string    `json:"token,omitempty"`
+	TLS   bool      `json:"tls"`
+	Since time.Time `json:"since"`
+	// 旧版客户端：不识别带请求 ID 的命令，只发送不带 ID 的 "1"（见 legacyReply）。受 clientsMutex 保护
+	Legacy bool `json:"legacy,omitempty"`
+	// 认证时令牌的哈希，热加载时据此判断令牌是否被更换
+	tokenHash string
+	// 待回传的截屏请求 ID 及各自的命令下发时间（用于统计往返耗时）；并发请求各占一项。受 clientsMutex 保护
+	pending map[string]time.Time
+}
+
+// serverTLSConfig 按配置构造监听端 TLS；未启用时返回 nil
+func (c TCPConfig) serverTLSConfig() (*tls.Config, error) {
+	if !c.TLS.Enabled {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.TLS.CertFile, c.TLS.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load server certificate: %w", err)
+	}
+	tc := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if c.TLS.ClientCAFile != "" {
+		pem, err := os.ReadFile(c.TLS.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", c.TLS.ClientCAFile)
+		}
+		tc.ClientCAs = pool
+		tc.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tc, nil
+}
+
+// identify 完成 TLS 握手并确定客户端身份；双向 TLS 下按登记表放行
+func (c TCPConfig) identify(conn net.Conn) (*tcpClient, error) {
+	cl := &tcpClient{ID: conn.RemoteAddr().String(), Addr: conn.RemoteAddr().String(), Since: time.Now()}
+	tc, ok := conn.(*tls.Conn)
+	if !ok {
+		return cl, nil
+	}
+	cl.TLS = true
+	_ = tc.SetDeadline(time.Now().Add(10 * time.Second))
+	if err := tc.Handshake(); err != nil {
+		return nil, fmt.Errorf("tls handshake: %w", err)
+	}
+	_ = tc.SetDeadline(time.Time{})
+	certs := tc.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return cl, nil
+	}
+	cn := certs[0].Subject.CommonName
+	cl.CommonName = cn
+	cl.ID = cn
+	reg, known := c.Clients[cn]
+	switch {
+	case len(c.Clients) > 0 && !known:
+		return nil, fmt.Errorf("client certificate %q is not registered", cn)
+	case reg.Disabled:
+		return nil, fmt.Errorf("client %q is disabled", cn)
+	case reg.Name != "":
+		cl.ID = reg.Name
+	}
+	return cl, nil
+}
+
+// listenTCP 按配置监听截屏端口，启用 TLS 时返回 TLS 监听器
+func (a *App) listenTCP(addr string) (net.Listener, error) {
+	tc, err := a.conf().TCP.serverTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	ln, err := listen(addr)
+	if err != nil || tc == nil {
+		return ln, err
+	}
+	return tls.NewListener(ln, tc), nil
+}
+
+// connectedClients 当前连接的客户端，按连接时间排序
+func (a *App) connectedClients() []tcpClient {
+	a.clientsMutex.Lock()
+	defer a.clientsMutex.Unlock()
+	out := make([]tcpClient, 0, len(a.clients))
+	for _, cl := range a.clients {
+		out = append(out, *cl)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Since.Before(out[j].Since) })
+	return out
+}
+
+// handleAPIClients GET 返回已连接的截屏客户端
+func (a *App) handleAPIClients(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"clients": a.connectedClients()})
+}
@@ -0,0 +1,103 @@
+package app
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMutualTLSIdentity(t *testing.T) {
+	dir := t.TempDir()
+	if err := GenCerts([]string{"-dir", dir, "-hosts", "127.0.0.1", "-clients", "office,stranger,lost"}); err != nil {
+		t.Fatal(err)
+	}
+	// 再次运行复用已有 CA
+	caBefore, _ := os.ReadFile(filepath.Join(dir, "ca.pem"))
+	if err := GenCerts([]string{"-dir", dir, "-hosts", "127.0.0.1", "-clients", "office"}); err != nil {
+		t.Fatal(err)
+	}
+	if caAfter, _ := os.ReadFile(filepath.Join(dir, "ca.pem")); string(caAfter) != string(caBefore) {
+		t.Fatal("gen-certs replaced existing CA")
+	}
+
+	cfg := TCPConfig{
+		TLS: TLSConfig{
+			Enabled:      true,
+			CertFile:     filepath.Join(dir, "server.pem"),
+			KeyFile:      filepath.Join(dir, "server-key.pem"),
+			ClientCAFile: filepath.Join(dir, "ca.pem"),
+		},
+		Clients: map[string]RegisteredClient{"office": {Name: "办公室"}, "lost": {Disabled: true}},
+	}
+	tc, err := cfg.serverTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", tc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	pem, _ := os.ReadFile(filepath.Join(dir, "ca.pem"))
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(pem)
+	connect := func(client string) (*tcpClient, error) {
+		cc := &tls.Config{RootCAs: roots, ServerName: "127.0.0.1"}
+		if client != "" {
+			cert, err := tls.LoadX509KeyPair(filepath.Join(dir, "client-"+client+".pem"), filepath.Join(dir, "client-"+client+"-key.pem"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			cc.Certificates = []tls.Certificate{cert}
+		}
+		go func() {
+			conn, err := tls.Dial("tcp", ln.Addr().String(), cc)
+			if err == nil {
+				_ = conn.Handshake()
+				defer conn.Close()
+				buf := make([]byte, 1)
+				_, _ = conn.Read(buf)
+			}
+		}()
+		conn, err := ln.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		return cfg.identify(conn)
+	}
+
+	cl, err := connect("office")
+	if err != nil || cl.ID != "办公室" || cl.CommonName != "office" || !cl.TLS {
+		t.Fatalf("office = %+v, %v", cl, err)
+	}
+	for _, name := range []string{"stranger", "lost", ""} {
+		if cl, err := connect(name); err == nil {
+			t.Fatalf("%q accepted as %+v", name, cl)
+		}
+	}
+
+	// 未启用 TLS 时以远端地址标识
+	plain, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	go func() {
+		if c, err := net.Dial("tcp", plain.Addr().String()); err == nil {
+			c.Close()
+		}
+	}()
+	conn, err := plain.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if cl, err := (TCPConfig{}).identify(conn); err != nil || cl.ID != conn.RemoteAddr().String() || cl.TLS {
+		t.Fatalf("plain = %+v, %v", cl, err)
+	}
+}
@@ -0,0 +1,37 @@
+package app
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// 内置模板与 web/result.html 均应能渲染完整数据
+func TestResultTemplatesRender(t *testing.T) {
+	items := []ImageEntry{{
+		ID:     "0123456789abcdef",
+		Client: "127.0.0.1:50000",
+		Base64: "aW1n",
+		ModelAnswers: []ModelAnswer{
+			{Model: "m1", Question: "q", Answer: "A", Usage: &Usage{PromptTokens: 10, CompletionTokens: 2}, Cost: 0.01, Cached: true, ImageInfo: "10x10 png 1KB"},
+			{Model: "m2", Error: "HTTP 500"},
+			{Model: "m4", Question: "q", Answer: "C", Verdict: &Verdict{Judge: "j", Correction: "A", Confidence: 0.9, Reason: "r"}},
+			{Model: "m3", Pending: true},
+		},
+	}}
+	items[0].Consensus = buildConsensus(items[0].ModelAnswers)
+	items[0].Transcript = &ModelAnswer{Model: "vl", Raw: "1+1=?"}
+	items[0].BankHit = &BankHit{Entry: BankEntry{Question: "q", Answer: "A"}, Score: 0.9}
+	// 第二张未保存的截图仍以 base64 内联
+	items = append(items, ImageEntry{Client: "c2", Base64: "aW1n"})
+	items[0].ImageURL = imageURL(items[0].ID)
+	for _, path := range []string{"../../web/result.html", "missing.html"} {
+		a := &App{cfg: Config{TemplatePath: path}}
+		w := httptest.NewRecorder()
+		a.renderPage(w, PageData{Items: items, JobID: "j1", Profile: "fast", Notice: "n"})
+		if w.Code != 200 || !strings.Contains(w.Body.String(), "模型：m1") || !strings.Contains(w.Body.String(), "转写（模型：vl）") || !strings.Contains(w.Body.String(), "题库命中") ||
+			!strings.Contains(w.Body.String(), `src="/images/0123456789abcdef"`) || !strings.Contains(w.Body.String(), `src="data:image/png;base64,aW1n"`) {
+			t.Fatalf("%s: status %d\n%s", path, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,363 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// TilingConfig 超大或超宽截图的分块识别：按重叠分块分别识别后合并，避免网关降采样导致小字不可读
+type TilingConfig struct {
+	// 默认是否启用；可通过 /one?tiles=1 或 tiles=0 按请求切换
+	Enabled bool `json:"enabled"`
+	// answer（默认）：各分块分别作答后合并去重；transcribe：各分块分别转写，拼接后由推理模型作答（即两阶段识别）
+	Mode string `json:"mode"`
+	// 最长边超过该值（像素）才分块，默认 2560
+	MinDimension int `json:"min_dimension"`
+	// 分块边长（像素），默认 1280
+	TileSize int `json:"tile_size"`
+	// 相邻分块重叠（像素），默认 160，使跨边界的文字至少完整出现在一个分块中
+	Overlap int `json:"overlap"`
+	// 分块数上限，默认 12；超出时增大分块边长
+	MaxTiles int `json:"max_tiles"`
+}
+
+const (
+	tileModeAnswer     = "answer"
+	tileModeTranscribe = "transcribe"
+)
+
+func (c TilingConfig) minDimension() int {
+	if c.MinDimension <= 0 {
+		return 2560
+	}
+	return c.MinDimension
+}
+
+func (c TilingConfig) tileSize() int {
+	if c.TileSize <= 0 {
+		return 1280
+	}
+	return c.TileSize
+}
+
+func (c TilingConfig) overlap() int {
+	if c.Overlap < 0 {
+		return 0
+	}
+	if c.Overlap == 0 {
+		return 160
+	}
+	return c.Overlap
+}
+
+func (c TilingConfig) maxTiles() int {
+	if c.MaxTiles <= 0 {
+		return 12
+	}
+	return c.MaxTiles
+}
+
+// tilingFor 解析本次请求的分块配置：profile 覆盖全局，请求参数 tiles / tile_mode 再覆盖；未启用返回 nil
+func (a *App) tilingFor(q url.Values, p *Profile) *TilingConfig {
+	tc := a.conf().Tiling
+	if p != nil && p.Tiling != nil {
+		tc = *p.Tiling
+	}
+	switch strings.ToLower(q.Get("tiles")) {
+	case "1", "true", "on":
+		tc.Enabled = true
+	case "0", "false", "off":
+		tc.Enabled = false
+	}
+	if m := strings.ToLower(q.Get("tile_mode")); m != "" {
+		tc.Mode = m
+	}
+	if !tc.Enabled {
+		return nil
+	}
+	if tc.Mode != tileModeTranscribe {
+		tc.Mode = tileModeAnswer
+	}
+	return &tc
+}
+
+// tileRects 计算覆盖 w×h 的重叠分块；不超过阈值时返回 nil
+func (c TilingConfig) tileRects(w, h int) []image.Rectangle {
+	if w <= c.minDimension() && h <= c.minDimension() {
+		return nil
+	}
+	size, overlap := c.tileSize(), c.overlap()
+	for {
+		if overlap >= size {
+			overlap = size / 4
+		}
+		xs, ys := tileStarts(w, size, overlap), tileStarts(h, size, overlap)
+		if len(xs)*len(ys) <= c.maxTiles() {
+			rects := make([]image.Rectangle, 0, len(xs)*len(ys))
+			for _, y := range ys {
+				for _, x := range xs {
+					rects = append(rects, image.Rect(x, y, min(x+size, w), min(y+size, h)))
+				}
+			}
+			return rects
+		}
+		size = size * 5 / 4
+	}
+}
+
+// tileStarts 一维方向上各分块的起点：均匀分布，相邻分块至少重叠 overlap
+func tileStarts(length, size, overlap int) []int {
+	if length <= size {
+		return []int{0}
+	}
+	n := (length - overlap + size - overlap - 1) / (size - overlap)
+	starts := make([]int, n)
+	for i := range starts {
+		starts[i] = i * (length - size) / (n - 1)
+	}
+	return starts
+}
+
+// splitTiles 将 base64 截图切为 PNG 分块；无需分块时返回 nil
+func splitTiles(b64 string, c TilingConfig) ([]string, error) {
+	raw, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return nil, fmt.Errorf("decode base64: %w", err)
+	}
+	src, _, err := image.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("decode image: %w", err)
+	}
+	img := toNRGBA(src)
+	rects := c.tileRects(img.Bounds().Dx(), img.Bounds().Dy())
+	if len(rects) == 0 {
+		return nil, nil
+	}
+	tiles := make([]string, 0, len(rects))
+	for _, r := range rects {
+		out, _, err := encodeImage(img.SubImage(r), 0)
+		if err != nil {
+			return nil, err
+		}
+		tiles = append(tiles, base64.StdEncoding.EncodeToString(out))
+	}
+	return tiles, nil
+}
+
+// tilesFor 按请求的分块配置切分截图；未启用、无需分块或切分失败时返回 nil（失败时整图识别）
+func (a *App) tilesFor(shot screenshot, opts analyzeOptions) []string {
+	if opts.tiling == nil {
+		return nil
+	}
+	tiles, err := splitTiles(shot.Base64, *opts.tiling)
+	if err != nil {
+		slog.Warn("split tiles", "err", err)
+		return nil
+	}
+	return tiles
+}
+
+// callTiles 对每个分块分别调用（经回退链），回调的流式输出带分块序号前缀
+func (a *App) callTiles(ctx context.Context, vr visionRequest, tiles []string, opts analyzeOptions, client string) []ModelAnswer {
+	answers := make([]ModelAnswer, len(tiles))
+	var wg sync.WaitGroup
+	for t := range tiles {
+		t := t
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tv := vr
+			tv.image = tiles[t]
+			tv.tiled = true
+			if vr.onDelta != nil {
+				prefix := fmt.Sprintf("[分块 %d/%d] ", t+1, len(tiles))
+				tv.onDelta = func(text string) { vr.onDelta(prefix + text) }
+			}
+			answers[t] = a.callWithFallback(ctx, tv, opts, client)
+		}()
+	}
+	wg.Wait()
+	return answers
+}
+
+// mergeTileAnswers 合并各分块的作答：跳过失败与“非题目”的分块，去除跨边界重复的题目，多题时编号列出
+func mergeTileAnswers(model string, parts []ModelAnswer) ModelAnswer {
+	out := ModelAnswer{Model: model, Tiles: len(parts), Cached: allCached(parts)}
+	type qa struct{ q, a, key string }
+	var kept []qa
+	var errs []string
+	for i, p := range parts {
+		out.addUsage(p)
+		if p.Requested != "" {
+			out.Requested = p.Requested
+			out.Skipped = append(out.Skipped, p.Skipped...)
+		}
+		if p.Error != "" {
+			errs = append(errs, fmt.Sprintf("分块 %d: %s", i+1, p.Error))
+			continue
+		}
+		q := strings.TrimSpace(p.Question)
+		if q == "" {
+			continue
+		}
+		key := questionKey(q)
+		dup := false
+		for j := range kept {
+			if !similarQuestion(kept[j].key, key) {
+				continue
+			}
+			// 跨边界的题目保留更完整（更长）的一份
+			if len([]rune(key)) > len([]rune(kept[j].key)) {
+				kept[j] = qa{q, p.Answer, key}
+			}
+			dup = true
+			break
+		}
+		if !dup {
+			kept = append(kept, qa{q, p.Answer, key})
+		}
+	}
+	switch len(kept) {
+	case 0:
+		if len(errs) == len(parts) {
+			out.Error = strings.Join(errs, "; ")
+			return out
+		}
+		out.Answer = "非题目"
+	case 1:
+		out.Question, out.Answer = kept[0].q, kept[0].a
+	default:
+		var qs, as []string
+		for i, k := range kept {
+			qs = append(qs, fmt.Sprintf("%d. %s", i+1, k.q))
+			as = append(as, fmt.Sprintf("%d. %s", i+1, k.a))
+		}
+		out.Question, out.Answer = strings.Join(qs, "\n"), strings.Join(as, "\n")
+	}
+	var raws []string
+	for i, p := range parts {
+		raws = append(raws, fmt.Sprintf("[分块 %d] %s", i+1, p.Raw))
+	}
+	out.Raw = strings.Join(raws, "\n")
+	return out
+}
+
+// mergeTileTranscripts 拼接各分块转写稿；去掉与已拼接内容末尾重叠的开头行，以及重复出现的长行
+func mergeTileTranscripts(model string, parts []ModelAnswer) ModelAnswer {
+	out := ModelAnswer{Model: model, Tiles: len(parts), Cached: allCached(parts)}
+	var lines, errs []string
+	seen := map[string]bool{}
+	for i, p := range parts {
+		out.addUsage(p)
+		if p.Error != "" {
+			errs = append(errs, fmt.Sprintf("分块 %d: %s", i+1, p.Error))
+			continue
+		}
+		next := strings.Split(strings.TrimSpace(p.Raw), "\n")
+		next = next[overlapLines(lines, next):]
+		for _, l := range next {
+			k := questionKey(l)
+			if len([]rune(k)) >= 8 && seen[k] {
+				continue
+			}
+			seen[k] = true
+			lines = append(lines, l)
+		}
+	}
+	if len(errs) == len(parts) {
+		out.Error = strings.Join(errs, "; ")
+		return out
+	}
+	out.Raw = strings.TrimSpace(strings.Join(lines, "\n"))
+	return out
+}
+
+// overlapLines 返回 next 开头与 prev 末尾重合的行数
+func overlapLines(prev, next []string) int {
+	for n := min(len(prev), len(next)); n > 0; n-- {
+		match := true
+		for i := 0; i < n; i++ {
+			if questionKey(prev[len(prev)-n+i]) != questionKey(next[i]) {
+				match = false
+				break
+			}
+		}
+		if match {
+			return n
+		}
+	}
+	return 0
+}
+
+// allCached 所有分块均命中缓存
+func allCached(parts []ModelAnswer) bool {
+	for _, p := range parts {
+		if !p.Cached {
+			return false
+		}
+	}
+	return len(parts) > 0
+}
+
+// addUsage 累加各分块调用的用量与费用
+func (m *ModelAnswer) addUsage(p ModelAnswer) {
+	if p.Usage != nil {
+		if m.Usage == nil {
+			m.Usage = &Usage{}
+		}
+		m.Usage.PromptTokens += p.Usage.PromptTokens
+		m.Usage.CompletionTokens += p.Usage.CompletionTokens
+		m.Usage.TotalTokens += p.Usage.TotalTokens
+	}
+	m.Cost += p.Cost
+}
+
+// questionKey 题目去重用的归一化：去空白与标点、全角转半角、小写
+func questionKey(s string) string {
+	var b strings.Builder
+	for _, r := range foldWidth(s) {
+		if unicode.IsSpace(r) || unicode.IsPunct(r) || unicode.IsSymbol(r) {
+			continue
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// similarQuestion 判断两道题是否为同一题（跨分块边界被截断）：互相包含，或二元组重合度 ≥ 0.7
+func similarQuestion(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	if strings.Contains(a, b) || strings.Contains(b, a) {
+		return true
+	}
+	ga, gb := bigrams(a), bigrams(b)
+	if len(ga) == 0 || len(gb) == 0 {
+		return false
+	}
+	common := 0
+	for g := range ga {
+		if gb[g] {
+			common++
+		}
+	}
+	return float64(common) >= 0.7*float64(min(len(ga), len(gb)))
+}
+
+func bigrams(s string) map[string]bool {
+	rs := []rune(s)
+	out := map[string]bool{}
+	for i := 0; i+1 < len(rs); i++ {
+		out[string(rs[i:i+2])] = true
+	}
+	return out
+}
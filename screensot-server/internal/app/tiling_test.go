@@ -0,0 +1,108 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+)
+
+func TestTileRects(t *testing.T) {
+	c := TilingConfig{MinDimension: 1000, TileSize: 600, Overlap: 100}
+	if c.tileRects(1000, 800) != nil {
+		t.Fatal("small image should not be tiled")
+	}
+	rects := c.tileRects(2000, 500)
+	if len(rects) != 4 {
+		t.Fatalf("rects = %v", rects)
+	}
+	for i := 1; i < len(rects); i++ {
+		if rects[i].Min.X >= rects[i-1].Max.X-100 {
+			t.Fatalf("tiles %d and %d overlap less than 100px: %v", i-1, i, rects)
+		}
+	}
+	if last := rects[len(rects)-1]; last.Max.X != 2000 || last.Max.Y != 500 {
+		t.Fatalf("last tile = %v", last)
+	}
+
+	// 超出分块数上限时增大分块
+	c.MaxTiles = 2
+	if rects := c.tileRects(2000, 500); len(rects) > 2 {
+		t.Fatalf("rects = %v", rects)
+	}
+}
+
+func TestMergeTileAnswers(t *testing.T) {
+	parts := []ModelAnswer{
+		{Model: "m", Question: "下列哪个是质数？A. 4 B. 6", Answer: "C", Usage: &Usage{PromptTokens: 10, CompletionTokens: 2}, Cost: 0.1},
+		{Model: "m", Question: "下列哪个是质数？A. 4 B. 6 C. 7 D. 9", Answer: "C", Usage: &Usage{PromptTokens: 10, CompletionTokens: 2}, Cost: 0.1},
+		{Model: "m", Question: "", Answer: "非题目"},
+		{Model: "m", Question: "2 的 10 次方是多少？", Answer: "1024"},
+		{Model: "m", Error: "HTTP 500"},
+	}
+	ans := mergeTileAnswers("m", parts)
+	want := "1. 下列哪个是质数？A. 4 B. 6 C. 7 D. 9\n2. 2 的 10 次方是多少？"
+	if ans.Question != want || ans.Answer != "1. C\n2. 1024" || ans.Error != "" {
+		t.Fatalf("answer = %+v", ans)
+	}
+	if ans.Tiles != 5 || ans.Usage.PromptTokens != 20 || ans.Cost < 0.19 {
+		t.Fatalf("usage = %+v cost %v", ans.Usage, ans.Cost)
+	}
+	if ans := mergeTileAnswers("m", []ModelAnswer{{Error: "x"}, {Error: "y"}}); ans.Error == "" {
+		t.Fatal("all tiles failing should be an error")
+	}
+}
+
+func TestMergeTileTranscripts(t *testing.T) {
+	ans := mergeTileTranscripts("vl", []ModelAnswer{
+		{Raw: "第一题：计算 1+1\nA. 1\nB. 2"},
+		{Raw: "A. 1\nB. 2\nC. 3"},
+	})
+	if ans.Raw != "第一题：计算 1+1\nA. 1\nB. 2\nC. 3" {
+		t.Fatalf("raw = %q", ans.Raw)
+	}
+}
+
+func TestAnalyzeImagesTiled(t *testing.T) {
+	var calls int32
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		var req struct {
+			Model string `json:"model"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		fmt.Fprint(w, `{"choices":[{"message":{"content":"{\"question\":\"同一道题\",\"answer\":\"A\"}"}}]}`)
+	}))
+	defer up.Close()
+
+	var buf bytes.Buffer
+	_ = png.Encode(&buf, image.NewGray(image.Rect(0, 0, 400, 100)))
+	shot := screenshot{Client: "c", Base64: base64.StdEncoding.EncodeToString(buf.Bytes())}
+
+	a := &App{cfg: Config{
+		Models:             []string{"m"},
+		SiliconflowBaseURL: up.URL,
+		SiliconflowAPIKey:  "k",
+		Tiling:             TilingConfig{MinDimension: 200, TileSize: 200, Overlap: 50},
+	}}
+	if a.tilingFor(url.Values{}, nil) != nil {
+		t.Fatal("tiling should be disabled by default")
+	}
+	opts := analyzeOptions{tiling: a.tilingFor(url.Values{"tiles": {"1"}}, nil)}
+	items := a.analyzeImages(context.Background(), []screenshot{shot}, opts)
+	ans := items[0].ModelAnswers[0]
+	if ans.Tiles != 3 || ans.Question != "同一道题" || ans.Answer != "A" {
+		t.Fatalf("answer = %+v", ans)
+	}
+	if calls != 3 {
+		t.Fatalf("calls = %d", calls)
+	}
+}
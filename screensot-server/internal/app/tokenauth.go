@@ -0,0 +1,359 @@
+package app
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"screensot-server/internal/protocol"
+)
+
+// TokenAuthConfig 截屏通道令牌认证；配置了任一令牌即要求客户端连接后先发送 Hello 帧
+type TokenAuthConfig struct {
+	Tokens []ClientToken `json:"tokens"`
+	// 同一 IP 在 window_minutes 内认证失败 max_failures 次（默认 5 次 / 10 分钟）后封禁 ban_minutes（默认 15）分钟
+	MaxFailures   int `json:"max_failures"`
+	WindowMinutes int `json:"window_minutes"`
+	BanMinutes    int `json:"ban_minutes"`
+}
+
+// ClientToken 一个注册令牌；只保存哈希（由 gen-token 子命令生成）
+type ClientToken struct {
+	// 令牌名，作为客户端标识
+	Name string `json:"name"`
+	// "sha256:<hex>"
+	Hash string `json:"hash"`
+	// 共享令牌：多台客户端共用，标识为 "名称/客户端自报名称"
+	Shared bool `json:"shared"`
+	// 吊销后拒绝新连接
+	Revoked bool `json:"revoked"`
+}
+
+func (c TokenAuthConfig) enabled() bool { return len(c.Tokens) > 0 }
+
+// hashToken 令牌为高熵随机串，单次 SHA-256 即可防止配置泄露后直接冒用
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// tokenAuth 令牌校验、运行期吊销与按 IP 的失败限流
+type tokenAuth struct {
+	*ipLimiter
+	// 运行期吊销的令牌名，持久化到 data_dir/revoked_tokens.json
+	path string
+	mu   sync.Mutex
+	// 当前配置的令牌，热加载时整体替换
+	tokens  []ClientToken
+	revoked map[string]bool
+}
+
+func newTokenAuth(cfg TokenAuthConfig, path string) *tokenAuth {
+	t := &tokenAuth{
+		ipLimiter: newIPLimiter(cfg.MaxFailures, cfg.WindowMinutes, cfg.BanMinutes),
+		tokens:    cfg.Tokens,
+		path:      path,
+		revoked:   map[string]bool{},
+	}
+	if b, err := os.ReadFile(path); err == nil {
+		var names []string
+		if err := json.Unmarshal(b, &names); err != nil {
+			slog.Warn("read revoked tokens", "err", err)
+		}
+		for _, n := range names {
+			t.revoked[n] = true
+		}
+	}
+	return t
+}
+
+// enabled 配置了任一令牌即要求认证
+func (t *tokenAuth) enabled() bool {
+	if t == nil {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.tokens) > 0
+}
+
+// setTokens 热加载时替换令牌列表
+func (t *tokenAuth) setTokens(tokens []ClientToken) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tokens = tokens
+}
+
+// valid 已认证的连接所用令牌是否仍然有效：令牌仍在配置中、哈希未变且未被吊销；
+// 未启用认证时所有连接有效，启用后未认证的连接无效
+func (t *tokenAuth) valid(cl *tcpClient) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.tokens) == 0 {
+		return true
+	}
+	for _, ct := range t.tokens {
+		if ct.Name == cl.Token && strings.EqualFold(ct.Hash, cl.tokenHash) {
+			return !ct.Revoked && !t.revoked[ct.Name]
+		}
+	}
+	return false
+}
+
+// check 校验令牌，返回匹配的令牌配置
+func (t *tokenAuth) check(token string) (ClientToken, error) {
+	h := hashToken(token)
+	t.mu.Lock()
+	tokens := t.tokens
+	t.mu.Unlock()
+	for _, ct := range tokens {
+		if subtle.ConstantTimeCompare([]byte(strings.ToLower(ct.Hash)), []byte(h)) != 1 {
+			continue
+		}
+		if ct.Revoked || t.isRevoked(ct.Name) {
+			return ct, fmt.Errorf("token %q revoked", ct.Name)
+		}
+		return ct, nil
+	}
+	return ClientToken{}, fmt.Errorf("invalid token")
+}
+
+func (t *tokenAuth) isRevoked(name string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.revoked[name]
+}
+
+// revoke 吊销令牌并持久化
+func (t *tokenAuth) revoke(name string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.revoked[name] = true
+	names := make([]string, 0, len(t.revoked))
+	for n := range t.revoked {
+		names = append(names, n)
+	}
+	b, _ := json.MarshalIndent(names, "", "  ")
+	if err := os.MkdirAll(filepath.Dir(t.path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(t.path, b, 0o644)
+}
+
+// ipLimiter 按 IP 统计认证失败，窗口内达到次数后暂时封禁
+type ipLimiter struct {
+	maxFailures int
+	window, ban time.Duration
+	mu          sync.Mutex
+	// IP -> 窗口内的失败时间
+	failures map[string][]time.Time
+	banned   map[string]time.Time
+	now      func() time.Time
+}
+
+// newIPLimiter 参数为 0 时取默认：5 次 / 10 分钟，封禁 15 分钟
+func newIPLimiter(maxFailures, windowMinutes, banMinutes int) *ipLimiter {
+	l := &ipLimiter{
+		maxFailures: maxFailures,
+		window:      time.Duration(windowMinutes) * time.Minute,
+		ban:         time.Duration(banMinutes) * time.Minute,
+		failures:    map[string][]time.Time{},
+		banned:      map[string]time.Time{},
+		now:         time.Now,
+	}
+	if l.maxFailures <= 0 {
+		l.maxFailures = 5
+	}
+	if l.window <= 0 {
+		l.window = 10 * time.Minute
+	}
+	if l.ban <= 0 {
+		l.ban = 15 * time.Minute
+	}
+	return l
+}
+
+// allowed 该 IP 当前是否未被封禁
+func (l *ipLimiter) allowed(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	until, ok := l.banned[ip]
+	if !ok {
+		return true
+	}
+	if l.now().After(until) {
+		delete(l.banned, ip)
+		return true
+	}
+	return false
+}
+
+// fail 记录一次失败；达到上限时封禁该 IP，返回是否刚被封禁
+func (l *ipLimiter) fail(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	recent := l.failures[ip][:0]
+	for _, f := range l.failures[ip] {
+		if now.Sub(f) < l.window {
+			recent = append(recent, f)
+		}
+	}
+	recent = append(recent, now)
+	if len(recent) >= l.maxFailures {
+		delete(l.failures, ip)
+		l.banned[ip] = now.Add(l.ban)
+		return true
+	}
+	l.failures[ip] = recent
+	return false
+}
+
+// authenticate 读取 Hello 帧并校验令牌，成功时补全客户端标识并应答 HelloOK
+func (a *App) authenticate(conn net.Conn, cl *tcpClient) error {
+	ip := remoteIP(conn)
+	if !a.tokens.allowed(ip) {
+		return fmt.Errorf("ip %s is temporarily banned", ip)
+	}
+	deny := func(err error) error {
+		_ = protocol.SendWithLengthPrefix(conn, []byte("denied: "+err.Error()))
+		if a.tokens.fail(ip) {
+			slog.Warn("tcp auth: too many failures, ip banned", "ip", ip)
+		}
+		return err
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	b, err := protocol.ReadWithLimit(conn, protocol.MaxHelloSize)
+	_ = conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		return deny(fmt.Errorf("read hello: %w", err))
+	}
+	var hello protocol.Hello
+	if err := json.Unmarshal(b, &hello); err != nil {
+		return deny(fmt.Errorf("invalid hello"))
+	}
+	ct, err := a.tokens.check(hello.Token)
+	if err != nil {
+		return deny(err)
+	}
+	cl.Token, cl.tokenHash = ct.Name, ct.Hash
+	// 客户端证书已给出登记身份时以证书为准
+	if cl.CommonName == "" {
+		cl.ID = ct.Name
+		if ct.Shared {
+			name := hello.Name
+			if name == "" {
+				name = cl.Addr
+			}
+			cl.ID = ct.Name + "/" + name
+		}
+	}
+	return protocol.SendWithLengthPrefix(conn, []byte(protocol.HelloOK))
+}
+
+// revokeToken 吊销令牌并立即断开使用该令牌的连接，返回断开的连接数
+func (a *App) revokeToken(name string) (int, error) {
+	if err := a.tokens.revoke(name); err != nil {
+		return 0, err
+	}
+	n := a.closeInvalidTokenClients()
+	slog.Info("tcp auth: token revoked", "token", name, "closed", n)
+	return n, nil
+}
+
+// reloadTokens 热加载时替换令牌列表，并断开所用令牌已被移除、更换或吊销的连接（启用认证后也断开未认证的连接）
+func (a *App) reloadTokens(tokens []ClientToken) int {
+	if a.tokens == nil {
+		return 0
+	}
+	a.tokens.setTokens(tokens)
+	n := a.closeInvalidTokenClients()
+	if n > 0 {
+		slog.Info("tcp auth: tokens reloaded, closed connections with invalid tokens", "closed", n)
+	}
+	return n
+}
+
+// closeInvalidTokenClients 断开令牌已失效的连接，返回断开的连接数
+func (a *App) closeInvalidTokenClients() int {
+	a.clientsMutex.Lock()
+	defer a.clientsMutex.Unlock()
+	n := 0
+	for conn, cl := range a.clients {
+		if !a.tokens.valid(cl) {
+			conn.Close()
+			n++
+		}
+	}
+	return n
+}
+
+// handleAPIRevokeToken POST {"name": "..."} 吊销客户端令牌
+func (a *App) handleAPIRevokeToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+		writeJSONError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+	known := false
+	for _, ct := range a.conf().TCP.Auth.Tokens {
+		known = known || ct.Name == req.Name
+	}
+	if !known {
+		writeJSONError(w, http.StatusNotFound, "token not found")
+		return
+	}
+	n, err := a.revokeToken(req.Name)
+	if err != nil {
+		a.auditRequest(r, "clients.revoke", auditError, fmt.Sprintf("%s: %v", req.Name, err))
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	a.auditRequest(r, "clients.revoke", auditOK, fmt.Sprintf("%s，断开 %d 个连接", req.Name, n))
+	writeJSON(w, http.StatusOK, map[string]interface{}{"revoked": req.Name, "disconnected": n})
+}
+
+func remoteIP(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
+}
+
+// GenToken 实现 gen-token 子命令：生成随机令牌，打印令牌（交给客户端）与写入配置的哈希
+func GenToken(args []string) error {
+	flags := flag.NewFlagSet("gen-token", flag.ContinueOnError)
+	name := flags.String("name", "client", "令牌名，作为客户端标识")
+	shared := flags.Bool("shared", false, "多台客户端共用的令牌")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return err
+	}
+	token := hex.EncodeToString(buf)
+	entry, _ := json.Marshal(ClientToken{Name: *name, Hash: hashToken(token), Shared: *shared})
+	fmt.Printf("token (give to the client, shown once): %s\n", token)
+	fmt.Printf("add to tcp.auth.tokens in config.json: %s\n", entry)
+	return nil
+}
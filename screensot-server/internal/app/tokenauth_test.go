@@ -0,0 +1,161 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"screensot-server/internal/protocol"
+)
+
+func TestTokenAuthHandshake(t *testing.T) {
+	cfg := TokenAuthConfig{Tokens: []ClientToken{
+		{Name: "office", Hash: hashToken("secret-1")},
+		{Name: "lab", Hash: hashToken("secret-2"), Shared: true},
+		{Name: "old", Hash: hashToken("secret-3"), Revoked: true},
+	}}
+	a := &App{state: &state{clients: map[net.Conn]*tcpClient{}}, tokens: newTokenAuth(cfg, filepath.Join(t.TempDir(), "revoked.json"))}
+	a.cfg.TCP.Auth = cfg
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	// dial 以给定令牌握手，返回服务端的认证结果与客户端收到的应答
+	dial := func(token, name string) (*tcpClient, net.Conn, string, error) {
+		reply := make(chan string, 1)
+		go func() {
+			c, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				reply <- err.Error()
+				return
+			}
+			b, _ := json.Marshal(protocol.Hello{Token: token, Name: name})
+			_ = protocol.SendWithLengthPrefix(c, b)
+			r, _ := protocol.ReadWithLengthPrefix(c)
+			reply <- string(r)
+		}()
+		conn, err := ln.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		cl := &tcpClient{ID: conn.RemoteAddr().String(), Addr: conn.RemoteAddr().String()}
+		err = a.authenticate(conn, cl)
+		return cl, conn, <-reply, err
+	}
+
+	cl, conn, reply, err := dial("secret-1", "")
+	if err != nil || cl.ID != "office" || cl.Token != "office" || reply != protocol.HelloOK {
+		t.Fatalf("office = %+v, %q, %v", cl, reply, err)
+	}
+	a.clients[conn] = cl
+	if cl, _, _, err := dial("secret-2", "pc-7"); err != nil || cl.ID != "lab/pc-7" {
+		t.Fatalf("shared = %+v, %v", cl, err)
+	}
+	if _, _, reply, err := dial("secret-3", ""); err == nil || !strings.HasPrefix(reply, "denied:") {
+		t.Fatalf("revoked in config accepted: %q", reply)
+	}
+
+	// 吊销立即断开已有连接，并持久化
+	w := httptest.NewRecorder()
+	a.handleAPIRevokeToken(w, httptest.NewRequest(http.MethodPost, "/api/v1/clients/revoke", strings.NewReader(`{"name":"office"}`)))
+	if w.Code != 200 || !strings.Contains(w.Body.String(), `"disconnected":1`) {
+		t.Fatalf("revoke: %d %s", w.Code, w.Body.String())
+	}
+	if _, err := conn.Write([]byte("x")); err == nil {
+		t.Fatal("revoked connection still open")
+	}
+	if _, _, _, err := dial("secret-1", ""); err == nil {
+		t.Fatal("revoked token accepted")
+	}
+	if reloaded := newTokenAuth(cfg, a.tokens.path); !reloaded.isRevoked("office") {
+		t.Fatal("revocation not persisted")
+	}
+	w = httptest.NewRecorder()
+	a.handleAPIRevokeToken(w, httptest.NewRequest(http.MethodPost, "/api/v1/clients/revoke", strings.NewReader(`{"name":"nobody"}`)))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("unknown token: %d", w.Code)
+	}
+	// 未认证的对端声明超大 Hello 帧：不分配缓冲区，直接拒绝
+	go func() {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err == nil {
+			c.Write([]byte{0xff, 0xff, 0xff, 0xff})
+		}
+	}()
+	big, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.authenticate(big, &tcpClient{Addr: big.RemoteAddr().String()}); !errors.Is(err, protocol.ErrFrameTooLarge) {
+		t.Fatalf("oversized hello: %v", err)
+	}
+}
+
+func TestTokenAuthRateLimit(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	ta := newTokenAuth(TokenAuthConfig{MaxFailures: 3, WindowMinutes: 1, BanMinutes: 5}, filepath.Join(t.TempDir(), "r.json"))
+	ta.now = func() time.Time { return now }
+
+	ta.fail("10.0.0.1")
+	now = now.Add(2 * time.Minute) // 窗口外的失败不计
+	ta.fail("10.0.0.1")
+	if ta.fail("10.0.0.1") || !ta.allowed("10.0.0.1") {
+		t.Fatal("banned before reaching the limit")
+	}
+	if !ta.fail("10.0.0.1") || ta.allowed("10.0.0.1") {
+		t.Fatal("not banned after 3 failures in window")
+	}
+	if !ta.allowed("10.0.0.2") {
+		t.Fatal("other IP banned")
+	}
+	now = now.Add(6 * time.Minute)
+	if !ta.allowed("10.0.0.1") {
+		t.Fatal("ban did not expire")
+	}
+}
+
+func TestReloadTokens(t *testing.T) {
+	cfg := TokenAuthConfig{Tokens: []ClientToken{
+		{Name: "office", Hash: hashToken("secret-1")},
+		{Name: "lab", Hash: hashToken("secret-2")},
+		{Name: "home", Hash: hashToken("secret-3")},
+	}}
+	a := &App{state: &state{clients: map[net.Conn]*tcpClient{}}, tokens: newTokenAuth(cfg, filepath.Join(t.TempDir(), "revoked.json"))}
+	conns := map[string]net.Conn{}
+	for _, ct := range cfg.Tokens {
+		server, client := net.Pipe()
+		defer client.Close()
+		a.clients[server] = &tcpClient{ID: ct.Name, Token: ct.Name, tokenHash: ct.Hash}
+		conns[ct.Name] = server
+	}
+
+	// 移除 lab、更换 office 的令牌，home 不变
+	if n := a.reloadTokens([]ClientToken{
+		{Name: "office", Hash: hashToken("secret-new")},
+		{Name: "home", Hash: hashToken("secret-3")},
+	}); n != 2 {
+		t.Fatalf("closed %d connections, want 2", n)
+	}
+	for name, conn := range conns {
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Millisecond))
+		_, err := conn.Write([]byte("x"))
+		if closed := errors.Is(err, net.ErrClosed) || errors.Is(err, io.ErrClosedPipe); closed != (name != "home") {
+			t.Errorf("%s: write err = %v", name, err)
+		}
+	}
+	if _, err := a.tokens.check("secret-1"); err == nil {
+		t.Fatal("replaced token still accepted")
+	}
+	if ct, err := a.tokens.check("secret-new"); err != nil || ct.Name != "office" {
+		t.Fatalf("new token rejected: %+v, %v", ct, err)
+	}
+}
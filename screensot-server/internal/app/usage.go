@@ -0,0 +1,373 @@
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Usage chat.completions 返回的 token 用量
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// cost 按每百万 token 单价计算一次调用的费用
+func (o ModelOptions) cost(u Usage) float64 {
+	return float64(u.PromptTokens)*o.PromptPrice/1e6 + float64(u.CompletionTokens)*o.CompletionPrice/1e6
+}
+
+// BudgetConfig 费用与用量预算；各上限为 0 表示不限制
+type BudgetConfig struct {
+	DailyCost     float64 `json:"daily_cost"`
+	MonthlyCost   float64 `json:"monthly_cost"`
+	DailyTokens   int     `json:"daily_tokens"`
+	MonthlyTokens int     `json:"monthly_tokens"`
+	// 超出后的处理：refuse（默认）拒绝识别；downgrade 改用 downgrade_models
+	Action          string   `json:"action"`
+	DowngradeModels []string `json:"downgrade_models"`
+}
+
+// usageRecord 账本中的一次模型调用
+type usageRecord struct {
+	Time             time.Time `json:"time"`
+	RequestID        string    `json:"request_id"`
+	Client           string    `json:"client"`
+	Model            string    `json:"model"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	Cost             float64   `json:"cost"`
+}
+
+// UsageTotals 一组调用的累计用量
+type UsageTotals struct {
+	Calls            int     `json:"calls"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+func (t *UsageTotals) add(r usageRecord) {
+	t.Calls++
+	t.PromptTokens += r.PromptTokens
+	t.CompletionTokens += r.CompletionTokens
+	t.Cost += r.Cost
+}
+
+// Tokens prompt 与 completion 之和
+func (t UsageTotals) Tokens() int { return t.PromptTokens + t.CompletionTokens }
+
+// UsageRow 按某一维度聚合的一行
+type UsageRow struct {
+	Key string `json:"key"`
+	UsageTotals
+}
+
+// UsageSummary /usage 页面与接口的数据
+type UsageSummary struct {
+	Today     UsageTotals  `json:"today"`
+	Month     UsageTotals  `json:"month"`
+	Budget    BudgetConfig `json:"budget"`
+	ByDay     []UsageRow   `json:"by_day"`
+	ByModel   []UsageRow   `json:"by_model"`
+	ByClient  []UsageRow   `json:"by_client"`
+	ByRequest []UsageRow   `json:"by_request"`
+}
+
+// usageLedger 追加写入 data_dir/usage.jsonl 的用量账本。内存中只保留按日、按月的累计（含按模型、客户端的细分），
+// 预算检查直接读取累计；按请求的明细在生成报表时从文件读取
+type usageLedger struct {
+	mu   sync.Mutex
+	path string
+	// 记录总数
+	n      int
+	days   map[string]*usageBucket
+	months map[string]*usageBucket
+}
+
+// usageBucket 一天或一个月的累计
+type usageBucket struct {
+	UsageTotals
+	byModel  map[string]*UsageTotals
+	byClient map[string]*UsageTotals
+}
+
+func (b *usageBucket) add(r usageRecord) {
+	b.UsageTotals.add(r)
+	addTo(b.byModel, r.Model, r)
+	addTo(b.byClient, r.Client, r)
+}
+
+// openUsageLedger 载入已有账本的累计；文件不存在时从空账本开始
+func openUsageLedger(path string) *usageLedger {
+	l := &usageLedger{path: path}
+	err := scanUsageFile(path, func(r usageRecord) { l.addLocked(r) })
+	if err != nil && !os.IsNotExist(err) {
+		slog.Warn("open usage ledger", "err", err)
+	}
+	return l
+}
+
+// scanUsageFile 逐行读取账本；无法解析的行跳过
+func scanUsageFile(path string, fn func(usageRecord)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var r usageRecord
+		if err := json.Unmarshal(sc.Bytes(), &r); err == nil {
+			fn(r)
+		}
+	}
+	return sc.Err()
+}
+
+// addLocked 计入累计；调用方持有 l.mu（或 l 尚未共享）
+func (l *usageLedger) addLocked(r usageRecord) {
+	if l.days == nil {
+		l.days, l.months = map[string]*usageBucket{}, map[string]*usageBucket{}
+	}
+	t := r.Time.Local()
+	for _, b := range []struct {
+		m   map[string]*usageBucket
+		key string
+	}{{l.days, t.Format("2006-01-02")}, {l.months, t.Format("2006-01")}} {
+		bucket := b.m[b.key]
+		if bucket == nil {
+			bucket = &usageBucket{byModel: map[string]*UsageTotals{}, byClient: map[string]*UsageTotals{}}
+			b.m[b.key] = bucket
+		}
+		bucket.add(r)
+	}
+	l.n++
+}
+
+func (l *usageLedger) append(r usageRecord) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.addLocked(r)
+	if l.path == "" {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0o755); err != nil {
+		slog.Warn("write usage ledger", "err", err)
+		return
+	}
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		slog.Warn("write usage ledger", "err", err)
+		return
+	}
+	defer f.Close()
+	b, _ := json.Marshal(r)
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		slog.Warn("write usage ledger", "err", err)
+	}
+}
+
+// records 账本中的记录数
+func (l *usageLedger) records() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.n
+}
+
+// totals 返回今日与本月累计
+func (l *usageLedger) totals(now time.Time) (today, month UsageTotals) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now = now.Local()
+	if b := l.days[now.Format("2006-01-02")]; b != nil {
+		today = b.UsageTotals
+	}
+	if b := l.months[now.Format("2006-01")]; b != nil {
+		month = b.UsageTotals
+	}
+	return today, month
+}
+
+// summary 按日（最近 31 天）、模型、客户端、请求（最近 50 次）聚合；按请求的明细从账本文件读取
+func (l *usageLedger) summary(now time.Time) UsageSummary {
+	var s UsageSummary
+	s.Today, s.Month = l.totals(now)
+
+	l.mu.Lock()
+	byDay, byModel, byClient := map[string]*UsageTotals{}, map[string]*UsageTotals{}, map[string]*UsageTotals{}
+	since := now.AddDate(0, 0, -30).Format("2006-01-02")
+	for day, b := range l.days {
+		if day >= since {
+			t := b.UsageTotals
+			byDay[day] = &t
+		}
+	}
+	for _, b := range l.months {
+		mergeInto(byModel, b.byModel)
+		mergeInto(byClient, b.byClient)
+	}
+	l.mu.Unlock()
+	s.ByDay = sortedRows(byDay, func(a, b UsageRow) bool { return a.Key > b.Key })
+	s.ByModel = sortedRows(byModel, func(a, b UsageRow) bool { return a.Cost > b.Cost || (a.Cost == b.Cost && a.Tokens() > b.Tokens()) })
+	s.ByClient = sortedRows(byClient, func(a, b UsageRow) bool { return a.Cost > b.Cost || (a.Cost == b.Cost && a.Tokens() > b.Tokens()) })
+
+	if l.path == "" {
+		return s
+	}
+	byReq := map[string]*UsageTotals{}
+	var reqOrder []string
+	err := scanUsageFile(l.path, func(r usageRecord) {
+		if _, ok := byReq[r.RequestID]; !ok {
+			reqOrder = append(reqOrder, r.RequestID)
+		}
+		addTo(byReq, r.RequestID, r)
+	})
+	if err != nil && !os.IsNotExist(err) {
+		slog.Warn("read usage ledger", "err", err)
+	}
+	if len(reqOrder) > 50 {
+		reqOrder = reqOrder[len(reqOrder)-50:]
+	}
+	for i := len(reqOrder) - 1; i >= 0; i-- {
+		s.ByRequest = append(s.ByRequest, UsageRow{Key: reqOrder[i], UsageTotals: *byReq[reqOrder[i]]})
+	}
+	return s
+}
+
+// mergeInto 将 src 的各项累计加到 dst
+func mergeInto(dst, src map[string]*UsageTotals) {
+	for k, t := range src {
+		d, ok := dst[k]
+		if !ok {
+			d = &UsageTotals{}
+			dst[k] = d
+		}
+		d.Calls += t.Calls
+		d.PromptTokens += t.PromptTokens
+		d.CompletionTokens += t.CompletionTokens
+		d.Cost += t.Cost
+	}
+}
+
+func addTo(m map[string]*UsageTotals, key string, r usageRecord) {
+	t, ok := m[key]
+	if !ok {
+		t = &UsageTotals{}
+		m[key] = t
+	}
+	t.add(r)
+}
+
+func sortedRows(m map[string]*UsageTotals, less func(a, b UsageRow) bool) []UsageRow {
+	rows := make([]UsageRow, 0, len(m))
+	for k, t := range m {
+		rows = append(rows, UsageRow{Key: k, UsageTotals: *t})
+	}
+	sort.Slice(rows, func(i, j int) bool { return less(rows[i], rows[j]) })
+	return rows
+}
+
+// recordUsage 记录一次实际发生的模型调用（缓存命中不计）
+func (a *App) recordUsage(requestID, client string, ans ModelAnswer) {
+	if a.usage == nil || ans.Usage == nil {
+		return
+	}
+	a.usage.append(usageRecord{
+		Time:             time.Now(),
+		RequestID:        requestID,
+		Client:           client,
+		Model:            ans.Model,
+		PromptTokens:     ans.Usage.PromptTokens,
+		CompletionTokens: ans.Usage.CompletionTokens,
+		Cost:             ans.Cost,
+	})
+}
+
+// budgetExceeded 返回超出的预算项说明；未超出返回空串
+func (a *App) budgetExceeded() string {
+	b := a.conf().Budget
+	if a.usage == nil {
+		return ""
+	}
+	today, month := a.usage.totals(time.Now())
+	switch {
+	case b.DailyCost > 0 && today.Cost >= b.DailyCost:
+		return fmt.Sprintf("今日费用 %.4f 已达上限 %.4f", today.Cost, b.DailyCost)
+	case b.MonthlyCost > 0 && month.Cost >= b.MonthlyCost:
+		return fmt.Sprintf("本月费用 %.4f 已达上限 %.4f", month.Cost, b.MonthlyCost)
+	case b.DailyTokens > 0 && today.Tokens() >= b.DailyTokens:
+		return fmt.Sprintf("今日 token %d 已达上限 %d", today.Tokens(), b.DailyTokens)
+	case b.MonthlyTokens > 0 && month.Tokens() >= b.MonthlyTokens:
+		return fmt.Sprintf("本月 token %d 已达上限 %d", month.Tokens(), b.MonthlyTokens)
+	}
+	return ""
+}
+
+// applyBudget 预算超出时按配置拒绝识别，或将模型降级为 downgrade_models；
+// 降级同时作用于两阶段识别的转写模型与核验的裁判模型（改用 downgrade_models 的第一个），
+// 回退链也只保留 downgrade_models 中的模型
+func (a *App) applyBudget(opts *analyzeOptions) error {
+	reason := a.budgetExceeded()
+	if reason == "" {
+		return nil
+	}
+	b := a.conf().Budget
+	if strings.EqualFold(b.Action, "downgrade") && len(b.DowngradeModels) > 0 {
+		opts.models = b.DowngradeModels
+		opts.downgrade = b.DowngradeModels
+		if opts.pipeline != nil {
+			pl := *opts.pipeline
+			pl.TranscribeModel = b.DowngradeModels[0]
+			opts.pipeline = &pl
+		}
+		if opts.verify != nil {
+			vc := *opts.verify
+			vc.JudgeModel = b.DowngradeModels[0]
+			opts.verify = &vc
+		}
+		opts.notice = fmt.Sprintf("预算超出（%s），已降级为 %s", reason, strings.Join(b.DowngradeModels, ", "))
+		return nil
+	}
+	return fmt.Errorf("预算超出，拒绝识别：%s", reason)
+}
+
+// handleUsage 渲染用量统计页面
+func (a *App) handleUsage(w http.ResponseWriter, r *http.Request) {
+	if a.usage == nil {
+		http.Error(w, "usage accounting disabled", http.StatusNotFound)
+		return
+	}
+	tmpl, err := template.New("usage").Funcs(templateFuncs).Parse(string(usageTemplate))
+	if err != nil {
+		http.Error(w, "Internal Server Error: unable to parse template", http.StatusInternalServerError)
+		return
+	}
+	s := a.usage.summary(time.Now())
+	s.Budget = a.conf().Budget
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := tmpl.Execute(w, s); err != nil {
+		http.Error(w, "Internal Server Error: unable to execute template", http.StatusInternalServerError)
+	}
+}
+
+// handleAPIUsage 以 JSON 返回用量统计
+func (a *App) handleAPIUsage(w http.ResponseWriter, r *http.Request) {
+	if a.usage == nil {
+		writeJSONError(w, http.StatusNotFound, "usage accounting disabled")
+		return
+	}
+	s := a.usage.summary(time.Now())
+	s.Budget = a.conf().Budget
+	writeJSON(w, http.StatusOK, s)
+}
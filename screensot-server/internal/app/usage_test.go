@@ -0,0 +1,59 @@
+package app
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUsageLedgerPersistAndSummary(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.jsonl")
+	l := openUsageLedger(path)
+	now := time.Now()
+	l.append(usageRecord{Time: now.AddDate(0, -2, 0), RequestID: "r0", Client: "c1", Model: "m1", PromptTokens: 1, Cost: 9})
+	l.append(usageRecord{Time: now, RequestID: "r1", Client: "c1", Model: "m1", PromptTokens: 100, CompletionTokens: 10, Cost: 0.5})
+	l.append(usageRecord{Time: now, RequestID: "r1", Client: "c2", Model: "m2", PromptTokens: 50, CompletionTokens: 5, Cost: 0.25})
+
+	// 重新打开应载入全部记录
+	s := openUsageLedger(path).summary(now)
+	if s.Today.Calls != 2 || s.Today.Tokens() != 165 || s.Today.Cost != 0.75 {
+		t.Fatalf("today = %+v", s.Today)
+	}
+	if len(s.ByModel) != 2 || s.ByModel[0].Key != "m1" || s.ByModel[0].Calls != 2 {
+		t.Fatalf("by model = %+v", s.ByModel)
+	}
+	if len(s.ByRequest) != 2 || s.ByRequest[0].Key != "r1" || s.ByRequest[0].Calls != 2 {
+		t.Fatalf("by request = %+v", s.ByRequest)
+	}
+	if len(s.ByDay) != 1 {
+		t.Fatalf("by day = %+v", s.ByDay)
+	}
+}
+
+func TestApplyBudget(t *testing.T) {
+	a := &App{usage: &usageLedger{}}
+	a.usage.append(usageRecord{Time: time.Now(), Model: "m1", PromptTokens: 1000, Cost: 2})
+
+	a.cfg.Budget = BudgetConfig{DailyCost: 5}
+	opts := analyzeOptions{}
+	if err := a.applyBudget(&opts); err != nil || opts.notice != "" {
+		t.Fatalf("within budget: %v %q", err, opts.notice)
+	}
+
+	a.cfg.Budget = BudgetConfig{DailyCost: 1}
+	if err := a.applyBudget(&opts); err == nil || !strings.Contains(err.Error(), "今日费用") {
+		t.Fatalf("expected refusal, got %v", err)
+	}
+
+	a.cfg.Budget = BudgetConfig{MonthlyTokens: 500, Action: "downgrade", DowngradeModels: []string{"cheap", "cheaper"}}
+	pl := &PipelineConfig{Enabled: true, TranscribeModel: "big-vl"}
+	opts.pipeline, opts.verify = pl, &VerifyConfig{Enabled: true, JudgeModel: "big-judge"}
+	if err := a.applyBudget(&opts); err != nil || len(opts.models) != 2 || opts.models[0] != "cheap" || opts.notice == "" {
+		t.Fatalf("expected downgrade: %v %+v", err, opts)
+	}
+	// 转写与裁判模型同样降级，不修改共享的配置
+	if opts.pipeline.TranscribeModel != "cheap" || opts.verify.JudgeModel != "cheap" || pl.TranscribeModel != "big-vl" {
+		t.Fatalf("transcribe = %q, judge = %q, shared = %q", opts.pipeline.TranscribeModel, opts.verify.JudgeModel, pl.TranscribeModel)
+	}
+}
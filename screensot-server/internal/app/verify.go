@@ -0,0 +1,168 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// VerifyConfig 自我核验：将截图与候选答案交给裁判模型，判断是否正确并给出更正与置信度
+type VerifyConfig struct {
+	// 默认是否启用；可通过 /one?verify=1 或 verify=0 按请求切换
+	Enabled bool `json:"enabled"`
+	// 裁判模型（需支持图片）；为空时使用模型列表中的第一个
+	JudgeModel string `json:"judge_model"`
+	// 裁判提示词，候选题目与答案附在其后；为空时使用内置提示词
+	Prompt string `json:"prompt"`
+}
+
+// Verdict 裁判模型对某个答案的核验结论
+type Verdict struct {
+	Judge string `json:"judge"`
+	// 裁判认为答案正确
+	Agree bool `json:"agree"`
+	// 裁判给出的正确答案（不同意时）
+	Correction string `json:"correction,omitempty"`
+	// 0~1 置信度
+	Confidence float64 `json:"confidence"`
+	Reason     string  `json:"reason,omitempty"`
+	// 裁判调用失败或输出无法解析
+	Error string `json:"error,omitempty"`
+}
+
+func judgeSystemPrompt() string {
+	return "你是严谨的阅卷老师。严格输出 JSON 格式，不添加任何额外文字、前缀或解释。"
+}
+
+func defaultJudgePrompt() string {
+	return "请根据图片中的题目，独立判断下面的候选答案是否正确。严格返回 JSON：{\"verdict\":\"agree 或 disagree\",\"correction\":\"不同意时给出正确答案，否则为空字符串\",\"confidence\":0到1之间的小数,\"reason\":\"一句话理由\"}。"
+}
+
+// verifyFor 解析本次请求的核验配置：profile 覆盖全局，请求参数 verify 切换启用；未启用返回 nil
+func (a *App) verifyFor(q url.Values, p *Profile) *VerifyConfig {
+	vc := a.conf().Verify
+	if p != nil && p.Verify != nil {
+		vc = *p.Verify
+	}
+	switch strings.ToLower(q.Get("verify")) {
+	case "1", "true", "on":
+		vc.Enabled = true
+	case "0", "false", "off":
+		vc.Enabled = false
+	}
+	if !vc.Enabled {
+		return nil
+	}
+	return &vc
+}
+
+// verifyAnswers 并发核验所有成功的答案，附上 Verdict 后按核验结果排序；每个核验完成时经 onAnswer 重新推送该答案
+func (a *App) verifyAnswers(ctx context.Context, image int, shot screenshot, answers []ModelAnswer, opts analyzeOptions) {
+	judge := opts.verify.JudgeModel
+	if judge == "" {
+		if ms := a.profileModels(opts.profile); len(ms) > 0 {
+			judge = ms[0]
+		}
+	}
+	prompt := opts.verify.Prompt
+	if prompt == "" {
+		prompt = defaultJudgePrompt()
+	}
+	var wg sync.WaitGroup
+	for k := range answers {
+		if answers[k].Error != "" || judge == "" {
+			continue
+		}
+		k := k
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			vr := visionRequest{
+				model:  judge,
+				image:  shot.Base64,
+				caller: opts.requestID,
+				system: judgeSystemPrompt(),
+				prompt: fmt.Sprintf("%s\n\n候选题目：%s\n候选答案：%s", prompt, answers[k].Question, answers[k].Answer),
+				raw:    true,
+			}
+			res := a.callWithFallback(ctx, vr, opts, shot.Client)
+			v := parseVerdict(res.Raw)
+			if res.Error != "" {
+				v = Verdict{Error: res.Error}
+			}
+			v.Judge = res.Model
+			answers[k].Verdict = &v
+			if opts.onAnswer != nil {
+				opts.onAnswer(image, answers[k])
+			}
+		}()
+	}
+	wg.Wait()
+	rankAnswers(answers)
+}
+
+// parseVerdict 解析裁判输出；无法解析时 Error 非空
+func parseVerdict(raw string) Verdict {
+	var out struct {
+		Verdict    string      `json:"verdict"`
+		Correction string      `json:"correction"`
+		Confidence json.Number `json:"confidence"`
+		Reason     string      `json:"reason"`
+	}
+	s := strings.TrimSpace(raw)
+	if i, j := strings.Index(s, "{"), strings.LastIndex(s, "}"); i >= 0 && j > i {
+		s = s[i : j+1]
+	}
+	if err := json.Unmarshal([]byte(s), &out); err != nil {
+		return Verdict{Error: "裁判输出无法解析: " + truncate(raw, 120)}
+	}
+	v := Verdict{Correction: strings.TrimSpace(out.Correction), Reason: strings.TrimSpace(out.Reason)}
+	switch strings.ToLower(strings.TrimSpace(out.Verdict)) {
+	case "agree", "同意", "正确", "yes", "true":
+		v.Agree = true
+	case "disagree", "不同意", "错误", "no", "false":
+	default:
+		return Verdict{Error: fmt.Sprintf("未知的裁判结论 %q", out.Verdict)}
+	}
+	if f, err := out.Confidence.Float64(); err == nil {
+		// 兼容以百分数返回的置信度
+		if f > 1 {
+			f /= 100
+		}
+		v.Confidence = clamp01(f)
+	}
+	return v
+}
+
+func clamp01(f float64) float64 {
+	switch {
+	case f < 0:
+		return 0
+	case f > 1:
+		return 1
+	}
+	return f
+}
+
+// verifyScore 排序得分：同意按置信度加分，不同意按置信度减分，未核验居中，调用失败的答案最后
+func verifyScore(ans ModelAnswer) float64 {
+	switch {
+	case ans.Error != "":
+		return -1
+	case ans.Verdict == nil || ans.Verdict.Error != "":
+		return 1
+	case ans.Verdict.Agree:
+		return 1 + ans.Verdict.Confidence
+	default:
+		return 1 - ans.Verdict.Confidence
+	}
+}
+
+// rankAnswers 按核验得分降序稳定排序
+func rankAnswers(answers []ModelAnswer) {
+	sort.SliceStable(answers, func(i, j int) bool { return verifyScore(answers[i]) > verifyScore(answers[j]) })
+}
@@ -0,0 +1,84 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseVerdict(t *testing.T) {
+	v := parseVerdict("```json\n{\"verdict\":\"disagree\",\"correction\":\"B\",\"confidence\":0.8,\"reason\":\"算错了\"}\n```")
+	if v.Error != "" || v.Agree || v.Correction != "B" || v.Confidence != 0.8 {
+		t.Fatalf("verdict = %+v", v)
+	}
+	if v := parseVerdict(`{"verdict":"同意","confidence":"95"}`); v.Error != "" || !v.Agree || v.Confidence != 0.95 {
+		t.Fatalf("verdict = %+v", v)
+	}
+	if v := parseVerdict("看起来是对的"); v.Error == "" {
+		t.Fatal("unparseable output should be an error")
+	}
+}
+
+func TestRankAnswers(t *testing.T) {
+	answers := []ModelAnswer{
+		{Model: "err", Error: "x"},
+		{Model: "wrong", Verdict: &Verdict{Confidence: 0.9}},
+		{Model: "unverified"},
+		{Model: "right", Verdict: &Verdict{Agree: true, Confidence: 0.7}},
+	}
+	rankAnswers(answers)
+	var got []string
+	for _, a := range answers {
+		got = append(got, a.Model)
+	}
+	if strings.Join(got, ",") != "right,unverified,wrong,err" {
+		t.Fatalf("order = %v", got)
+	}
+}
+
+func TestVerifyAnswers(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model    string `json:"model"`
+			Messages []struct {
+				Content json.RawMessage `json:"content"`
+			} `json:"messages"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		switch req.Model {
+		case "judge":
+			// 候选答案 2 正确，其余不正确
+			if strings.Contains(string(req.Messages[1].Content), "候选答案：2") {
+				fmt.Fprint(w, `{"choices":[{"message":{"content":"{\"verdict\":\"agree\",\"confidence\":0.9}"}}]}`)
+			} else {
+				fmt.Fprint(w, `{"choices":[{"message":{"content":"{\"verdict\":\"disagree\",\"correction\":\"2\",\"confidence\":0.9}"}}]}`)
+			}
+		case "good":
+			fmt.Fprint(w, `{"choices":[{"message":{"content":"{\"question\":\"1+1\",\"answer\":\"2\"}"}}]}`)
+		default:
+			fmt.Fprint(w, `{"choices":[{"message":{"content":"{\"question\":\"1+1\",\"answer\":\"3\"}"}}]}`)
+		}
+	}))
+	defer up.Close()
+
+	a := &App{cfg: Config{
+		Models:             []string{"bad", "good"},
+		SiliconflowBaseURL: up.URL,
+		SiliconflowAPIKey:  "k",
+		Verify:             VerifyConfig{JudgeModel: "judge"},
+	}}
+	opts := analyzeOptions{verify: a.verifyFor(url.Values{"verify": {"1"}}, nil)}
+	items := a.analyzeImages(context.Background(), []screenshot{{Client: "c", Base64: "aW1n"}}, opts)
+	ans := items[0].ModelAnswers
+	if len(ans) != 2 || ans[0].Model != "good" || ans[0].Verdict == nil || !ans[0].Verdict.Agree {
+		t.Fatalf("answers = %+v", ans)
+	}
+	if v := ans[1].Verdict; v == nil || v.Agree || v.Correction != "2" || v.Judge != "judge" {
+		t.Fatalf("verdict = %+v", v)
+	}
+}
@@ -1,9 +1,11 @@
 package app
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -14,59 +16,182 @@ import (
 
 // ImageEntry 代表单张图片及多个模型的识别结果
 type ImageEntry struct {
-	Base64       string
-	ModelAnswers []ModelAnswer
+	// 持久化后的截图 ID，用于追问等链接；未保存时为空
+	ID string `json:"id,omitempty"`
+	// 截图来源客户端
+	Client string `json:"client"`
+	// 持久化后的图片地址 /images/<id>，页面优先使用；未保存时为空，仍以 Base64 内联
+	ImageURL     string        `json:"image_url,omitempty"`
+	Base64       string        `json:"base64"`
+	ModelAnswers []ModelAnswer `json:"model_answers"`
+	// 跨模型一致性结论；无有效答案时为 nil
+	Consensus *Consensus `json:"consensus,omitempty"`
+	// 两阶段识别的转写结果（Raw 为转写稿），ModelAnswers 为推理模型基于转写稿的作答
+	Transcript *ModelAnswer `json:"transcript,omitempty"`
+	// 题库命中；未启用题库或未命中时为 nil
+	BankHit *BankHit `json:"bank_hit,omitempty"`
 }
 
 type ModelAnswer struct {
-	Model    string
-	Question string
-	Answer   string
-	Raw      string
-	Error    string
+	Model    string `json:"model"`
+	Question string `json:"question"`
+	Answer   string `json:"answer"`
+	Raw      string `json:"raw"`
+	Error    string `json:"error,omitempty"`
+	// 流式页面占位：模型尚未返回
+	Pending bool `json:"pending,omitempty"`
+	// 命中结果缓存，未实际调用模型
+	Cached bool `json:"cached,omitempty"`
+	// 预处理后实际发送给模型的图片摘要（尺寸/格式/体积）；未预处理时为空
+	ImageInfo string `json:"image_info,omitempty"`
+	// 模型返回的 token 用量；缓存命中时为原始调用的用量
+	Usage *Usage `json:"usage,omitempty"`
+	// 按 model_options 单价计算的费用
+	Cost float64 `json:"cost,omitempty"`
+	// 模型输出无法解析为 JSON 题目/答案，Question/Answer 为粗分结果
+	Unparsed bool `json:"unparsed,omitempty"`
+	// 发生回退时为请求的（回退链首个）模型，Model 为实际作答的模型
+	Requested string `json:"requested,omitempty"`
+	// 回退链中被跳过的模型及原因
+	Skipped []FallbackAttempt `json:"skipped,omitempty"`
+	// 分块识别时的分块数，结果为各分块合并
+	Tiles int `json:"tiles,omitempty"`
+	// 裁判模型的核验结论；未核验时为 nil
+	Verdict *Verdict `json:"verdict,omitempty"`
+}
+
+// analyzeOptions 单次识别的选项与过程回调（回调均可为 nil，用于向页面实时转发进度）
+type analyzeOptions struct {
+	// requestID 本次 /one 请求的 ID，用于用量按请求聚合
+	requestID string
+	// session、tags 随截图保存的会话名与标签（/one?session=&tags=a,b）
+	session string
+	tags    []string
+	// models 显式指定的模型列表（如预算降级），优先于 profile
+	models []string
+	// downgrade 预算降级时的模型列表；非空时回退链只保留其中的模型，避免回退到昂贵模型
+	downgrade []string
+	// notice 需要在页面/接口中提示的信息
+	notice string
+	// noCache 跳过结果缓存（nocache=1），强制重新调用模型
+	noCache bool
+	// profile 通过 profile=name 选择的识别方案，可为 nil
+	profile *Profile
+	// pipeline 两阶段识别配置，nil 表示直接由多模态模型识别
+	pipeline *PipelineConfig
+	// tiling 分块识别配置，nil 表示整图识别
+	tiling *TilingConfig
+	// verify 裁判核验配置，nil 表示不核验
+	verify *VerifyConfig
+	// actor 发起者；targets 截屏命令下发到的客户端。二者写入审计日志
+	actor   auditActor
+	targets []string
+	// onDelta 收到流式片段时回调，text 为该模型目前累计的输出
+	onDelta func(image int, model, text string)
+	// onAnswer 单个模型完成（含失败）时回调
+	onAnswer func(image int, ans ModelAnswer)
+	// onQueue 在全局调度器中的排队位置变化时回调，0 表示开始调用
+	onQueue func(image int, model string, position int)
+	// onTranscript 两阶段识别的转写完成（含失败）时回调
+	onTranscript func(image int, ans ModelAnswer)
+	// onBankHit 题库命中时回调
+	onBankHit func(image int, hit BankHit)
 }
 
 // analyzeImages 对每张图片并发调用多个模型，返回聚合结果。
-func (a *App) analyzeImages(ctx context.Context, base64Images []string) []ImageEntry {
-	// 模型列表：可通过环境变量覆盖，逗号分隔
-	models := a.cfg.Models
+func (a *App) analyzeImages(ctx context.Context, shots []screenshot, opts analyzeOptions) []ImageEntry {
+	// 模型列表：可通过环境变量覆盖，逗号分隔；profile/预算降级可再覆盖
+	models := a.modelsFor(opts)
 
-	items := make([]ImageEntry, len(base64Images))
+	items := make([]ImageEntry, len(shots))
 	var wg sync.WaitGroup
-	wg.Add(len(base64Images))
+	wg.Add(len(shots))
 
-	for i := range base64Images {
+	for i := range shots {
 		i := i
 		go func() {
 			defer wg.Done()
-			entry := ImageEntry{Base64: base64Images[i]}
+			entry := ImageEntry{Client: shots[i].Client, Base64: shots[i].Base64}
+
+			// 超大截图按配置切分为重叠分块
+			tiles := a.tilesFor(shots[i], opts)
+
+			// 两阶段识别：先转写，推理模型只看转写稿
+			if opts.pipeline != nil {
+				t := a.transcribe(ctx, i, shots[i], tiles, opts)
+				entry.Transcript = &t
+			}
+
+			// 题库匹配：转写稿可在调用推理模型前匹配；否则以首个命中的识别题目匹配
+			bankHit := func(text string) bool {
+				hit := a.bankMatch(text)
+				if hit == nil {
+					return false
+				}
+				entry.BankHit = hit
+				if opts.onBankHit != nil {
+					opts.onBankHit(i, *hit)
+				}
+				return true
+			}
+			runModels := models
+			if t := entry.Transcript; t != nil && t.Error == "" && bankHit(t.Raw) && a.bankReplacesModels() {
+				runModels = nil
+			}
 
-			// 针对每个模型并发调用，简单限流：最多并发 4
+			// 针对每个模型并发调用，上游并发与限流由全局调度器统一控制
 			var mu sync.Mutex
 			var mwg sync.WaitGroup
-			sem := make(chan struct{}, 4)
-			entry.ModelAnswers = make([]ModelAnswer, 0, len(models))
+			entry.ModelAnswers = make([]ModelAnswer, 0, len(runModels))
 
-			for _, m := range models {
+			for _, m := range runModels {
 				m := m
 				mwg.Add(1)
 				go func() {
 					defer mwg.Done()
-					select {
-					case sem <- struct{}{}:
-						// ok
-					case <-ctx.Done():
-						return
-					}
-					defer func() { <-sem }()
 
-					ans := a.callVision(ctx, m, base64Images[i])
+					// 回调统一以回退链首个模型标识，页面卡片不因回退而错位
+					vr := visionRequest{model: m, image: shots[i].Base64, caller: opts.requestID}
+					if t := entry.Transcript; t != nil {
+						if t.Error != "" {
+							ans := ModelAnswer{Model: m, Error: "转写失败，未进行推理"}
+							if opts.onAnswer != nil {
+								opts.onAnswer(i, ans)
+							}
+							mu.Lock()
+							entry.ModelAnswers = append(entry.ModelAnswers, ans)
+							mu.Unlock()
+							return
+						}
+						vr = reasonRequest(vr, t.Raw)
+					}
+					if opts.onDelta != nil {
+						vr.onDelta = func(text string) { opts.onDelta(i, m, text) }
+					}
+					if opts.onQueue != nil {
+						vr.onQueue = func(pos int) { opts.onQueue(i, m, pos) }
+					}
+					var ans ModelAnswer
+					if len(tiles) > 0 && entry.Transcript == nil {
+						ans = mergeTileAnswers(m, a.callTiles(ctx, vr, tiles, opts, shots[i].Client))
+					} else {
+						ans = a.callWithFallback(ctx, vr, opts, shots[i].Client)
+					}
+					if opts.onAnswer != nil {
+						opts.onAnswer(i, ans)
+					}
 					mu.Lock()
 					entry.ModelAnswers = append(entry.ModelAnswers, ans)
+					if entry.BankHit == nil && ans.Error == "" && ans.Question != "" {
+						bankHit(ans.Question)
+					}
 					mu.Unlock()
 				}()
 			}
 			mwg.Wait()
+			if opts.verify != nil {
+				a.verifyAnswers(ctx, i, shots[i], entry.ModelAnswers, opts)
+			}
 			items[i] = entry
 		}()
 	}
@@ -74,99 +199,326 @@ func (a *App) analyzeImages(ctx context.Context, base64Images []string) []ImageE
 	return items
 }
 
-// callVision 调用 SiliconFlow 兼容的 chat.completions（多模态），并尝试解析为问/答。
-func (a *App) callVision(ctx context.Context, model, b64 string) ModelAnswer {
-	baseURL := strings.TrimSpace(a.cfg.SiliconflowBaseURL)
-	apiKey := strings.TrimSpace(a.cfg.SiliconflowAPIKey)
-	result := ModelAnswer{Model: model}
-	if apiKey == "" {
-		result.Error = "缺少 API Key（请在 config.json 的 siliconflow_api_key 配置中设置）"
-		return result
+// 模型请求参数；修改提示词或参数时同步提升 promptVersion，使旧缓存失效
+const (
+	visionTemperature = 0.2
+	visionMaxTokens   = 800
+	promptVersion     = "v1"
+)
+
+// visionRequest 单次模型调用的输入
+type visionRequest struct {
+	model string
+	// 原图 base64（PNG）
+	image string
+	// 发送前的预处理，nil 表示原图发送
+	pre *PreprocessConfig
+	// 流式模式下随累计文本回调，可为 nil
+	onDelta func(string)
+	// 调度器中的调用方（请求 ID），同一调用方的调用排在同一队列
+	caller string
+	// 排队位置变化回调，可为 nil
+	onQueue func(position int)
+	// 覆盖默认的系统提示词 / 用户提示词
+	system, prompt string
+	// 纯文本输入（两阶段识别的转写稿）；image 为空时作为用户消息正文附在 prompt 之后
+	text string
+	// 原样返回模型输出，不解析为题目/答案（如转写阶段）
+	raw bool
+	// image 为分块；按比例裁剪针对整图，分块时不再裁剪
+	tiled bool
+}
+
+func (vr visionRequest) systemText() string {
+	if vr.system != "" {
+		return vr.system
+	}
+	return systemPrompt()
+}
+
+func (vr visionRequest) promptText() string {
+	if vr.prompt != "" {
+		return vr.prompt
+	}
+	return promptText()
+}
+
+// userContent 构造 OpenAI 风格的用户消息：有图片时为多模态数组，否则为纯文本
+func (vr visionRequest) userContent(img preparedImage) interface{} {
+	if vr.image == "" {
+		return vr.promptText() + "\n\n" + vr.text
 	}
+	return imageContent(vr.promptText(), img)
+}
 
-	// OpenAI 风格的多模态消息结构
-	userContent := []interface{}{
-		map[string]interface{}{"type": "text", "text": promptText()},
+// imageContent 文本 + 图片的多模态消息内容
+func imageContent(text string, img preparedImage) []interface{} {
+	return []interface{}{
+		map[string]interface{}{"type": "text", "text": text},
 		map[string]interface{}{
 			"type":      "image_url",
-			"image_url": map[string]interface{}{"url": "data:image/png;base64," + b64},
+			"image_url": map[string]interface{}{"url": img.imageURL()},
 		},
 	}
+}
+
+// callVisionScheduled 经全局调度器排队后调用 callVision，结束时按实际 token 归还配额
+func (a *App) callVisionScheduled(ctx context.Context, vr visionRequest) ModelAnswer {
+	if a.sched == nil {
+		return a.callVision(ctx, vr)
+	}
+	provider, _ := a.conf().providerFor(vr.model)
+	release, err := a.sched.acquire(ctx, vr.caller, provider, vr.onQueue)
+	if err != nil {
+		return ModelAnswer{Model: vr.model, Error: fmt.Sprintf("排队等待超时: %v", err)}
+	}
+	ans := a.callVision(ctx, vr)
+	used := 0
+	if ans.Usage != nil {
+		used = ans.Usage.TotalTokens
+		if used == 0 {
+			used = ans.Usage.PromptTokens + ans.Usage.CompletionTokens
+		}
+	}
+	release(used)
+	return ans
+}
+
+// modelsFor 返回本次识别（两阶段时为推理阶段）使用的模型列表：显式指定 > 两阶段推理模型 > profile > 全局
+func (a *App) modelsFor(opts analyzeOptions) []string {
+	if len(opts.models) > 0 {
+		return opts.models
+	}
+	if pl := opts.pipeline; pl != nil && len(pl.ReasonModels) > 0 {
+		return pl.ReasonModels
+	}
+	return a.profileModels(opts.profile)
+}
+
+// profileModels profile 的模型列表，未配置时为全局 models
+func (a *App) profileModels(p *Profile) []string {
+	if p != nil && len(p.Models) > 0 {
+		return p.Models
+	}
+	return a.conf().Models
+}
+
+// callVision 调用 SiliconFlow 兼容的 chat.completions（多模态），并尝试解析为问/答。
+// 配置 stream=true 时以 SSE 方式接收，vr.onDelta（可为 nil）随累计文本实时回调。
+func (a *App) callVision(ctx context.Context, vr visionRequest) ModelAnswer {
+	model := vr.model
+	result := ModelAnswer{Model: model}
+
+	// 预处理仅影响发送给模型的图片，页面仍展示原图
+	var img preparedImage
+	if vr.image != "" {
+		var err error
+		img, err = preprocessImage(vr.image, vr.pre)
+		if err != nil {
+			result.Error = fmt.Sprintf("图片预处理失败: %v", err)
+			return result
+		}
+		result.ImageInfo = img.Info
+		img = a.presignImage(img)
+	}
+
+	messages := []map[string]interface{}{
+		{"role": "system", "content": vr.systemText()},
+		{"role": "user", "content": vr.userContent(img)},
+	}
+	content, usage, err := a.chatCompletion(ctx, model, messages, a.conf().Stream, vr.onDelta)
+	// 即使解析失败也保留用量，失败的调用同样计费
+	if usage != nil {
+		result.Usage = usage
+		result.Cost = a.conf().ModelOptions[model].cost(*usage)
+	}
+	if err != nil {
+		result.Raw = strings.TrimSpace(content)
+		result.Error = err.Error()
+		return result
+	}
+	content = strings.TrimSpace(content)
+	result.Raw = content
+	if vr.raw {
+		return result
+	}
+
+	// 解析 JSON 中的题目/答案（避免与接收者 a 冲突）
+	q, ansText, ok := parseQA(content)
+	if ok {
+		result.Question = q
+		result.Answer = ansText
+	} else {
+		// 若无法解析，作为降级：整段文本粗分
+		result.Question, result.Answer = roughSplitQA(content)
+		result.Unparsed = true
+	}
+	return result
+}
+
+// chatCompletion 向模型所属 provider 发起一次 chat.completions 请求，返回正文与用量。
+// stream 为 true 时以 SSE 方式接收，onDelta（可为 nil）随累计文本实时回调。
+func (a *App) chatCompletion(ctx context.Context, model string, messages []map[string]interface{}, stream bool, onDelta func(string)) (string, *Usage, error) {
+	providerName, provider := a.conf().providerFor(model)
+	baseURL := strings.TrimSpace(provider.BaseURL)
+	apiKey := strings.TrimSpace(provider.APIKey)
+	if baseURL == "" {
+		return "", nil, fmt.Errorf("未配置 provider %q 的 base_url", providerName)
+	}
+	if apiKey == "" && providerName == defaultProvider {
+		return "", nil, errors.New("缺少 API Key（请在 config.json 的 siliconflow_api_key 配置中设置）")
+	}
 
 	// 构造请求体
 	reqBody := map[string]interface{}{
-		"model": model,
-		"messages": []map[string]interface{}{
-			{"role": "system", "content": systemPrompt()},
-			{"role": "user", "content": userContent},
-		},
-		"temperature": 0.2,
-		"max_tokens":  800,
+		"model":       model,
+		"messages":    messages,
+		"temperature": visionTemperature,
+		"max_tokens":  visionMaxTokens,
+	}
+	if stream {
+		reqBody["stream"] = true
+		// 要求在最后一个片段附带 usage
+		reqBody["stream_options"] = map[string]interface{}{"include_usage": true}
 	}
 
 	// 发起 HTTP 请求
 	endpoint := strings.TrimRight(baseURL, "/") + "/v1/chat/completions"
 	bodyBytes, err := json.Marshal(reqBody)
 	if err != nil {
-		result.Error = fmt.Sprintf("序列化请求失败: %v", err)
-		return result
+		return "", nil, fmt.Errorf("序列化请求失败: %v", err)
 	}
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(bodyBytes))
 	if err != nil {
-		result.Error = fmt.Sprintf("构造请求失败: %v", err)
-		return result
+		return "", nil, fmt.Errorf("构造请求失败: %v", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+apiKey)
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	// 请求 ID 透传给网关，便于对照上游日志
+	if id := requestIDFrom(ctx); id != "" {
+		req.Header.Set("X-Request-ID", id)
+	}
+	log := logger(ctx).With("model", model, "provider", providerName)
+	log.Debug("model call", "stream", stream, "body_bytes", len(bodyBytes))
+	start := time.Now()
 
 	client := &http.Client{Timeout: 30 * time.Second}
+	if stream {
+		// 流式输出耗时随 max_tokens 增长，整体时长交由 ctx 控制
+		client = &http.Client{}
+	}
 	resp, err := client.Do(req)
 	if err != nil {
-		result.Error = fmt.Sprintf("请求失败: %v", err)
-		return result
+		log.Warn("model call failed", "err", err, "duration", time.Since(start))
+		a.metrics.observeVisionCall(model, 0, time.Since(start))
+		return "", nil, fmt.Errorf("请求失败: %v", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		b, _ := io.ReadAll(resp.Body)
-		result.Error = fmt.Sprintf("HTTP %d: %s", resp.StatusCode, string(b))
-		return result
+		log.Warn("model call failed", "status", resp.StatusCode, "duration", time.Since(start))
+		a.metrics.observeVisionCall(model, resp.StatusCode, time.Since(start))
+		return "", nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(b))
 	}
+	log.Debug("model responded", "status", resp.StatusCode, "duration", time.Since(start))
 
+	read := readCompletionContent
+	if stream && strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		read = func(r io.Reader) (string, *Usage, error) { return readStreamContent(r, onDelta) }
+	}
+	content, usage, err := read(resp.Body)
+	// 流式调用的耗时计到读完响应
+	a.metrics.observeVisionCall(model, resp.StatusCode, time.Since(start))
+	a.metrics.observeUsage(model, usage)
+	return content, usage, err
+}
+
+// readCompletionContent 解析非流式 chat.completions 响应，返回 choices[0].message.content 与 usage
+func readCompletionContent(r io.Reader) (string, *Usage, error) {
 	var parsed struct {
 		Choices []struct {
 			Message struct {
 				Content string `json:"content"`
 			} `json:"message"`
 		} `json:"choices"`
+		Usage *Usage `json:"usage"`
 	}
-	b, err := io.ReadAll(resp.Body)
+	b, err := io.ReadAll(r)
 	if err != nil {
-		result.Error = fmt.Sprintf("读取响应失败: %v", err)
-		return result
+		return "", nil, fmt.Errorf("读取响应失败: %v", err)
 	}
 	if err := json.Unmarshal(b, &parsed); err != nil {
-		result.Error = fmt.Sprintf("解析响应失败: %v; 原始: %s", err, truncate(string(b), 500))
-		return result
+		return "", nil, fmt.Errorf("解析响应失败: %v; 原始: %s", err, truncate(string(b), 500))
 	}
 	if len(parsed.Choices) == 0 {
-		result.Error = "响应为空"
-		return result
+		return "", parsed.Usage, errors.New("响应为空")
 	}
-	content := strings.TrimSpace(parsed.Choices[0].Message.Content)
-	result.Raw = content
+	return parsed.Choices[0].Message.Content, parsed.Usage, nil
+}
 
-	// 解析 JSON 中的题目/答案（避免与接收者 a 冲突）
-	q, ansText, ok := parseQA(content)
-	if ok {
-		result.Question = q
-		result.Answer = ansText
-	} else {
-		// 若无法解析，作为降级：整段文本粗分
-		result.Question, result.Answer = roughSplitQA(content)
+// readStreamContent 逐行读取 OpenAI 兼容的 SSE 响应（data: {...}，以 [DONE] 结束），
+// 累积 choices[0].delta.content。onDelta 收到累计文本；正文未开始时转发推理模型的 reasoning_content。
+// usage 取自携带该字段的片段（通常为最后一个）。
+func readStreamContent(r io.Reader, onDelta func(string)) (string, *Usage, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	var content, reasoning strings.Builder
+	var usage *Usage
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			break
+		}
+		var chunk struct {
+			Choices []struct {
+				Delta struct {
+					Content          string `json:"content"`
+					ReasoningContent string `json:"reasoning_content"`
+				} `json:"delta"`
+			} `json:"choices"`
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+			Usage *Usage `json:"usage"`
+		}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return content.String(), usage, fmt.Errorf("解析流式片段失败: %v; 原始: %s", err, truncate(data, 200))
+		}
+		if chunk.Error != nil {
+			return content.String(), usage, errors.New(chunk.Error.Message)
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+		d := chunk.Choices[0].Delta
+		if d.Content == "" && d.ReasoningContent == "" {
+			continue
+		}
+		content.WriteString(d.Content)
+		reasoning.WriteString(d.ReasoningContent)
+		if onDelta != nil {
+			if content.Len() > 0 {
+				onDelta(content.String())
+			} else {
+				onDelta("（思考中）" + reasoning.String())
+			}
+		}
 	}
-	return result
+	if err := sc.Err(); err != nil {
+		return content.String(), usage, fmt.Errorf("读取流式响应失败: %v", err)
+	}
+	return content.String(), usage, nil
 }
 
 func systemPrompt() string {
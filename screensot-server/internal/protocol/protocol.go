@@ -2,8 +2,12 @@ package protocol
 
 import (
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
+	"math"
 	"net"
+	"strings"
 )
 
 // Response 为客户端上报的统一 JSON 结构
@@ -11,26 +15,73 @@ type Response struct {
 	Code  int    `json:"code"`
 	Error string `json:"error"`
 	Data  []byte `json:"data"`
+	// 截屏命令携带的请求 ID，原样带回
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Hello 服务端启用令牌认证时，客户端连接后发送的第一帧（JSON）
+type Hello struct {
+	Token string `json:"token"`
+	// 客户端自报名称，共享令牌时用于区分客户端
+	Name string `json:"name,omitempty"`
+}
+
+// HelloOK 认证通过时服务端的应答帧；失败时应答 "denied: 原因" 后断开
+const HelloOK = "ok"
+
+// 服务端下发的命令帧
+const (
+	// CmdCapture 截取主屏并回传
+	CmdCapture = "1"
+	// CmdShutdown 服务端即将关闭，客户端无需应答
+	CmdShutdown = "bye"
+)
+
+// CaptureCommand 截屏命令帧；带请求 ID 时为 "1:<请求 ID>"
+func CaptureCommand(requestID string) string {
+	if requestID == "" {
+		return CmdCapture
+	}
+	return CmdCapture + ":" + requestID
+}
+
+// ParseCommand 将命令帧拆分为命令与请求 ID
+func ParseCommand(frame string) (cmd, requestID string) {
+	cmd, requestID, _ = strings.Cut(frame, ":")
+	return cmd, requestID
 }
 
 // SendWithLengthPrefix 以 4 字节大端长度前缀发送一帧
 func SendWithLengthPrefix(conn net.Conn, data []byte) error {
-	var lengthBuf [4]byte
-	binary.BigEndian.PutUint32(lengthBuf[:], uint32(len(data)))
-	if _, err := conn.Write(lengthBuf[:]); err != nil {
-		return err
-	}
-	_, err := conn.Write(data)
+	// 长度与内容合并为一次写入：同一连接上并发发送的帧不会交错
+	frame := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	copy(frame[4:], data)
+	_, err := conn.Write(frame)
 	return err
 }
 
-// ReadWithLengthPrefix 读取一帧（4 字节大端长度前缀）
+// ReadWithLengthPrefix 读取一帧（4 字节大端长度前缀），不限长度；读取对端可控的帧应使用 ReadWithLimit
 func ReadWithLengthPrefix(conn net.Conn) ([]byte, error) {
+	return ReadWithLimit(conn, math.MaxUint32)
+}
+
+// MaxHelloSize Hello 帧、认证应答与命令帧的长度上限
+const MaxHelloSize = 4 << 10
+
+// ErrFrameTooLarge 帧长度超过上限；此时连接上的数据已无法对齐，调用方应断开
+var ErrFrameTooLarge = errors.New("frame too large")
+
+// ReadWithLimit 读取一帧，长度超过 max 时在分配缓冲区之前返回 ErrFrameTooLarge
+func ReadWithLimit(conn net.Conn, max uint32) ([]byte, error) {
 	var lengthBuf [4]byte
 	if _, err := io.ReadFull(conn, lengthBuf[:]); err != nil {
 		return nil, err
 	}
 	length := binary.BigEndian.Uint32(lengthBuf[:])
+	if length > max {
+		return nil, fmt.Errorf("%w: %d bytes, limit %d", ErrFrameTooLarge, length, max)
+	}
 	data := make([]byte, length)
 	if _, err := io.ReadFull(conn, data); err != nil {
 		return nil, err
@@ -2,6 +2,7 @@ package protocol
 
 import (
 	"encoding/json"
+	"errors"
 	"net"
 	"testing"
 	"time"
@@ -35,3 +36,28 @@ func TestLengthPrefixedFrame(t *testing.T) {
 		t.Fatalf("mismatch: %q != %q", string(got), string(b))
 	}
 }
+
+func TestCaptureCommand(t *testing.T) {
+	if got := CaptureCommand(""); got != CmdCapture {
+		t.Fatalf("without id = %q", got)
+	}
+	cmd, id := ParseCommand(CaptureCommand("req-1"))
+	if cmd != CmdCapture || id != "req-1" {
+		t.Fatalf("parse = %q, %q", cmd, id)
+	}
+	if cmd, id := ParseCommand(CmdShutdown); cmd != CmdShutdown || id != "" {
+		t.Fatalf("parse bye = %q, %q", cmd, id)
+	}
+}
+
+// 超长帧在分配前被拒绝
+func TestReadWithLimit(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	go c1.Write([]byte{0xff, 0xff, 0xff, 0xff})
+	c2.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := ReadWithLimit(c2, MaxHelloSize); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("err = %v, want ErrFrameTooLarge", err)
+	}
+}